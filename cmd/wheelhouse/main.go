@@ -6,12 +6,19 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/jrammler/wheelhouse/internal/controller/web"
+	"github.com/jrammler/wheelhouse/internal/entity"
 	"github.com/jrammler/wheelhouse/internal/service"
+	"github.com/jrammler/wheelhouse/internal/service/audit"
 	"github.com/jrammler/wheelhouse/internal/service/auth"
 	"github.com/jrammler/wheelhouse/internal/service/command"
+	"github.com/jrammler/wheelhouse/internal/service/hook"
+	"github.com/jrammler/wheelhouse/internal/service/notify"
+	"github.com/jrammler/wheelhouse/internal/service/schedule"
 	"github.com/jrammler/wheelhouse/internal/storage"
 	"golang.org/x/term"
 )
@@ -28,16 +35,38 @@ func main() {
 		serve(os.Args[2], os.Args[3])
 	case "hash-password":
 		hashPassword()
+	case "token":
+		if len(os.Args) < 4 {
+			usageExit()
+		}
+		tokenCommand(os.Args[2], os.Args[3], os.Args[4:])
+	case "audit":
+		if len(os.Args) < 4 || os.Args[2] != "verify" {
+			usageExit()
+		}
+		auditVerify(os.Args[3])
 	default:
 		usageExit()
 	}
 }
 
 func usageExit() {
-	fmt.Fprintf(os.Stderr, "Usage: %s [serve <addr> <config-file> | hash-password]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Usage: %s [serve <addr> <config-file> | hash-password | token <create|list|revoke> <config-file> ... | audit verify <audit-log>]\n", os.Args[0])
 	os.Exit(1)
 }
 
+// daemon holds the components of a running server.
+type daemon struct {
+	storage       storage.Storage
+	executions    storage.ExecutionStore
+	auditLog      storage.AuditLog
+	audit         *audit.AuditService
+	notify        *notify.NotifyService
+	scheduler     *schedule.ScheduleService
+	stopScheduler context.CancelFunc
+	server        *web.Server
+}
+
 func serve(addr string, storagePath string) {
 	sto, err := storage.NewJsonStorage(storagePath)
 	if err != nil {
@@ -45,10 +74,44 @@ func serve(addr string, storagePath string) {
 		os.Exit(1)
 	}
 
+	err = checkCommands(sto)
+	if err != nil {
+		slog.Error("Invalid command configuration", "error", err)
+		os.Exit(1)
+	}
+
+	executions, err := newExecutionStore(sto)
+	if err != nil {
+		slog.Error("Error initializing execution history", "error", err)
+		os.Exit(1)
+	}
+
+	auditLog, err := newAuditLog(sto, storagePath)
+	if err != nil {
+		slog.Error("Error initializing audit log", "error", err)
+		os.Exit(1)
+	}
+	auditService := audit.NewAuditService(sto, auditLog)
+
+	logFiles, err := newLogFileStore(sto, storagePath)
+	if err != nil {
+		slog.Error("Error initializing log directory", "error", err)
+		os.Exit(1)
+	}
+	go pruneLogs(sto, logFiles)
+
+	commandService, notifyService := newCommandService(sto, executions, auditService, logFiles)
+	scheduler := schedule.NewScheduleService(sto, commandService)
 	ser := &service.Service{
-		CommandService: command.NewCommandService(sto, nil),
-		AuthService:    auth.NewAuthService(sto),
+		CommandService:      commandService,
+		AuthService:         auth.NewAuthService(sto, newTokenStore(sto, storagePath)),
+		ScheduleService:     scheduler,
+		AuditService:        auditService,
+		NotificationService: notifyService,
+		WebhookService:      hook.NewHookService(sto, commandService),
 	}
+	schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+	go scheduler.Run(schedulerCtx)
 
 	server := web.NewServer(ser, addr)
 	go func() {
@@ -58,33 +121,147 @@ func serve(addr string, storagePath string) {
 		}
 	}()
 
-	signalHandler(sto, server)
+	signalHandler(&daemon{
+		storage:       sto,
+		executions:    executions,
+		auditLog:      auditLog,
+		audit:         auditService,
+		notify:        notifyService,
+		scheduler:     scheduler,
+		stopScheduler: stopScheduler,
+		server:        server,
+	})
+}
+
+// checkCommands verifies the parts of the commands that depend on the system
+// the server runs on, like the users of run_as.
+func checkCommands(sto storage.Storage) error {
+	commands, err := sto.GetCommands(context.Background())
+	if err != nil {
+		return err
+	}
+	return command.CheckCommands(commands)
+}
+
+// newCommandService creates the command service together with the service
+// notifying about its executions. Both have to use the same execution store,
+// as the notifications contain the logs of the executions.
+func newCommandService(sto storage.Storage, executions storage.ExecutionStore, auditService *audit.AuditService, logFiles storage.LogFileStore) (*command.CommandService, *notify.NotifyService) {
+	notifyService := notify.NewNotifyService(sto, executions, nil)
+	commandService := command.NewCommandService(sto, executions, nil, auditService, logFiles, notifyService)
+	return commandService, notifyService
+}
+
+// newExecutionStore opens the history database if one is configured and keeps
+// the history in memory otherwise.
+func newExecutionStore(sto storage.Storage) (storage.ExecutionStore, error) {
+	settings, err := sto.GetSettings(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if settings.HistoryDatabase == "" {
+		return storage.NewMemoryExecutionStore(storage.DefaultMemoryHistoryLength), nil
+	}
+	return storage.NewSqliteExecutionStore(settings.HistoryDatabase)
+}
+
+// newTokenStore returns the store for API tokens, which are kept in tokens.json
+// next to the config file unless configured otherwise.
+func newTokenStore(sto storage.Storage, storagePath string) storage.TokenStore {
+	settings, err := sto.GetSettings(context.Background())
+	if err != nil || settings.TokensFile == "" {
+		return storage.NewJsonTokenStore(filepath.Join(filepath.Dir(storagePath), "tokens.json"))
+	}
+	return storage.NewJsonTokenStore(settings.TokensFile)
+}
+
+// newAuditLog opens the audit log, which is written to audit.log next to the
+// config file unless configured otherwise.
+func newAuditLog(sto storage.Storage, storagePath string) (storage.AuditLog, error) {
+	settings, err := sto.GetSettings(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	path := settings.AuditLog
+	if path == "" {
+		path = filepath.Join(filepath.Dir(storagePath), "audit.log")
+	}
+	return storage.NewFileAuditLog(path)
+}
+
+// newLogFileStore returns the store for the complete output of executions,
+// which is written to the logs directory next to the config file unless
+// configured otherwise.
+func newLogFileStore(sto storage.Storage, storagePath string) (storage.LogFileStore, error) {
+	settings, err := sto.GetSettings(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	dir := settings.LogDir
+	if dir == "" {
+		dir = filepath.Join(filepath.Dir(storagePath), "logs")
+	}
+	return storage.NewDirLogFileStore(dir)
+}
+
+const logPruneInterval = time.Hour
+
+// pruneLogs periodically removes the log files that are older than the
+// configured retention.
+func pruneLogs(sto storage.Storage, logFiles storage.LogFileStore) {
+	for {
+		settings, err := sto.GetSettings(context.Background())
+		if err == nil && settings.LogRetention > 0 {
+			removed, err := logFiles.Prune(time.Now().Add(-time.Duration(settings.LogRetention)))
+			if err != nil {
+				slog.Error("Failed to remove old log files", "error", err)
+			} else if removed > 0 {
+				slog.Info("Removed old log files", "count", removed)
+			}
+		}
+		time.Sleep(logPruneInterval)
+	}
 }
 
-func signalHandler(sto storage.Storage, server *web.Server) {
+func signalHandler(d *daemon) {
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
 	for sig := range signalChan {
 		slog.Info("Received signal", "signal", sig)
 		switch sig {
 		case syscall.SIGHUP:
-			reloadConfig(sto)
+			reloadConfig(d)
 		case syscall.SIGINT, syscall.SIGTERM:
-			shutdownServer(server, signalChan)
+			// no new scheduled executions should start while shutting down
+			d.stopScheduler()
+			shutdownServer(d, signalChan)
 		}
 	}
 }
 
-func reloadConfig(sto storage.Storage) {
-	err := sto.LoadConfig()
+func reloadConfig(d *daemon) {
+	err := d.storage.LoadConfig()
 	if err != nil {
 		slog.Error("Failed to reload config. Continuing with previous config", "error", err)
+		d.audit.Record(context.Background(), entity.AuditEvent{
+			Type:    entity.AuditEventConfigReload,
+			Details: map[string]string{"result": "failed", "error": err.Error()},
+		})
 	} else {
 		slog.Info("Config reloaded successfully")
+		err = checkCommands(d.storage)
+		if err != nil {
+			slog.Error("Invalid command configuration, affected commands will fail", "error", err)
+		}
+		d.audit.Record(context.Background(), entity.AuditEvent{
+			Type:    entity.AuditEventConfigReload,
+			Details: map[string]string{"result": "success"},
+		})
+		d.scheduler.Reload()
 	}
 }
 
-func shutdownServer(server *web.Server, signalChan <-chan os.Signal) {
+func shutdownServer(d *daemon, signalChan <-chan os.Signal) {
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
 		for sig := range signalChan {
@@ -94,10 +271,37 @@ func shutdownServer(server *web.Server, signalChan <-chan os.Signal) {
 			}
 		}
 	}()
-	server.Shutdown(ctx)
+	d.server.Shutdown(ctx)
+	// the notifications about the last executions are still sent, but
+	// failed ones are not retried
+	d.notify.Shutdown(ctx)
+	err := d.executions.Close()
+	if err != nil {
+		slog.Error("Error while closing execution history", "error", err)
+	}
+	err = d.auditLog.Close()
+	if err != nil {
+		slog.Error("Error while closing audit log", "error", err)
+	}
 	os.Exit(0)
 }
 
+func auditVerify(path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening audit log: %s\n", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+
+	count, err := storage.VerifyAuditLog(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Audit log is invalid after %d valid records: %s\n", count, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Audit log is valid, %d records\n", count)
+}
+
 func hashPassword() {
 	fmt.Print("Enter password: ")
 	bytePassword, err := term.ReadPassword(int(syscall.Stdin))
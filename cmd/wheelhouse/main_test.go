@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+	"github.com/jrammler/wheelhouse/internal/service/audit"
+	"github.com/jrammler/wheelhouse/internal/storage"
+)
+
+func TestNotifyWithDefaultHistory(t *testing.T) {
+	// Arrange
+	var mu sync.Mutex
+	bodies := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	config := map[string]any{
+		"commands": []map[string]any{{"name": "hello", "id": "hello", "command": "echo hello"}},
+		"users":    []map[string]any{},
+		"settings": map[string]any{
+			"webhooks": []map[string]any{{"name": "chat", "url": server.URL, "events": []string{"succeeded"}}},
+		},
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	err = os.WriteFile(configPath, data, 0600)
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	sto, err := storage.NewJsonStorage(configPath)
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	executions, err := newExecutionStore(sto)
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	auditLog, err := newAuditLog(sto, configPath)
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	defer auditLog.Close()
+	commandService, notifyService := newCommandService(sto, executions, audit.NewAuditService(sto, auditLog), nil)
+
+	// Act
+	_, err = commandService.ExecuteCommand(context.Background(), entity.User{Username: "test", System: true}, "hello", nil, entity.Trigger{Type: entity.TriggerTypeWeb})
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	commandService.WaitExecutions(ctx)
+	notifyService.Wait(ctx)
+
+	// Assert
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 1 {
+		t.Fatalf("Expected 1 notification, got %d", len(bodies))
+	}
+	if !strings.Contains(bodies[0], "hello") {
+		t.Errorf("Expected the log in the notification, got %q", bodies[0])
+	}
+}
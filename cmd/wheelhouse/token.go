@@ -0,0 +1,113 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/service/auth"
+	"github.com/jrammler/wheelhouse/internal/storage"
+)
+
+func tokenUsageExit() {
+	fmt.Fprintf(os.Stderr, "Usage:\n")
+	fmt.Fprintf(os.Stderr, "  %s token create <config-file> [-name <name>] [-roles <role,...>] [-commands <id,...>] [-expires <duration>] <username>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s token list <config-file>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s token revoke <config-file> <token-id>\n", os.Args[0])
+	os.Exit(1)
+}
+
+func tokenCommand(action string, storagePath string, args []string) {
+	sto, err := storage.NewJsonStorage(storagePath)
+	if err != nil {
+		slog.Error("Error initializing storage", "error", err)
+		os.Exit(1)
+	}
+	authService := auth.NewAuthService(sto, newTokenStore(sto, storagePath))
+
+	switch action {
+	case "create":
+		createToken(authService, args)
+	case "list":
+		listTokens(authService)
+	case "revoke":
+		if len(args) != 1 {
+			tokenUsageExit()
+		}
+		revokeToken(authService, args[0])
+	default:
+		tokenUsageExit()
+	}
+}
+
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
+func createToken(authService *auth.AuthService, args []string) {
+	flags := flag.NewFlagSet("token create", flag.ExitOnError)
+	name := flags.String("name", "", "description of the token")
+	roles := flags.String("roles", "", "comma separated roles the token is limited to")
+	commands := flags.String("commands", "", "comma separated IDs of the commands the token is limited to")
+	expires := flags.Duration("expires", 0, "validity of the token, e.g. 720h (default never expires)")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		tokenUsageExit()
+	}
+
+	token, apiToken, err := authService.CreateToken(context.Background(), flags.Arg(0), *name, splitList(*roles), splitList(*commands), *expires)
+	if err != nil {
+		slog.Error("Error creating token", "error", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Created token %s for user %s.\n", apiToken.Id, apiToken.Username)
+	fmt.Printf("Use it in the header \"Authorization: Bearer <token>\". It is not shown again:\n\n%s\n", token)
+}
+
+func listTokens(authService *auth.AuthService) {
+	tokens, err := authService.ListTokens(context.Background())
+	if err != nil {
+		slog.Error("Error listing tokens", "error", err)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tUSER\tROLES\tCOMMANDS\tCREATED\tEXPIRES")
+	for _, token := range tokens {
+		roles, commands, expires := "*", "*", "never"
+		// tokens limited to an empty list grant no roles or commands
+		if token.Roles != nil {
+			roles = cmp.Or(strings.Join(token.Roles, ","), "-")
+		}
+		if token.Commands != nil {
+			commands = cmp.Or(strings.Join(token.Commands, ","), "-")
+		}
+		if token.Expires != nil {
+			expires = token.Expires.Format(time.DateTime)
+			if token.Expires.Before(time.Now()) {
+				expires += " (expired)"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", token.Id, token.Name, token.Username, roles, commands, token.Created.Format(time.DateTime), expires)
+	}
+	w.Flush()
+}
+
+func revokeToken(authService *auth.AuthService, id string) {
+	err := authService.RevokeToken(context.Background(), id)
+	if err != nil {
+		slog.Error("Error revoking token", "error", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Revoked token %s.\n", id)
+}
@@ -0,0 +1,261 @@
+// Package ansi interprets the escape sequences in the output of terminal
+// programs. Colors and text attributes are kept, all other control sequences
+// are removed.
+package ansi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Style is the formatting of a piece of text. Colors are CSS colors, empty
+// for the default color.
+type Style struct {
+	Foreground string
+	Background string
+	Bold       bool
+	Dim        bool
+	Italic     bool
+	Underline  bool
+}
+
+// CSS returns the style as the value of a style attribute.
+func (s Style) CSS() string {
+	var b strings.Builder
+	if s.Foreground != "" {
+		b.WriteString("color:" + s.Foreground + ";")
+	}
+	if s.Background != "" {
+		b.WriteString("background-color:" + s.Background + ";")
+	}
+	if s.Bold {
+		b.WriteString("font-weight:bold;")
+	}
+	if s.Dim {
+		b.WriteString("opacity:0.7;")
+	}
+	if s.Italic {
+		b.WriteString("font-style:italic;")
+	}
+	if s.Underline {
+		b.WriteString("text-decoration:underline;")
+	}
+	return b.String()
+}
+
+// Segment is a piece of text with the same style.
+type Segment struct {
+	Text  string
+	Style Style
+}
+
+const esc = 0x1b
+
+// Parse splits a line of output into segments of the same style. The style
+// starts out as the default for every line.
+func Parse(line string) []Segment {
+	segments := make([]Segment, 0, 1)
+	var style Style
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			segments = append(segments, Segment{Text: text.String(), Style: style})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c != esc {
+			// other control characters would end up as garbage in the page
+			if (c >= 0x20 && c != 0x7f) || c == '\t' {
+				text.WriteByte(c)
+			}
+			continue
+		}
+		if i+1 >= len(line) {
+			break
+		}
+		switch line[i+1] {
+		case '[':
+			// control sequence: parameters, intermediate bytes, final byte
+			end := i + 2
+			for end < len(line) && (line[end] < 0x40 || line[end] > 0x7e) {
+				end++
+			}
+			if end >= len(line) {
+				i = end
+				continue
+			}
+			if line[end] == 'm' {
+				newStyle := applySgr(style, line[i+2:end])
+				if newStyle != style {
+					flush()
+					style = newStyle
+				}
+			}
+			i = end
+		case ']', 'P', 'X', '^', '_':
+			// strings like window titles, terminated by BEL or ESC \
+			end := i + 2
+			for end < len(line) && line[end] != 0x07 && !(line[end] == esc && end+1 < len(line) && line[end+1] == '\\') {
+				end++
+			}
+			if end < len(line) && line[end] == esc {
+				end++
+			}
+			i = end
+		default:
+			// other escape sequences: intermediate bytes and a final byte
+			end := i + 1
+			for end < len(line) && line[end] >= 0x20 && line[end] <= 0x2f {
+				end++
+			}
+			i = end
+		}
+	}
+	flush()
+	return segments
+}
+
+// Strip removes all escape sequences and control characters from a line.
+func Strip(line string) string {
+	var b strings.Builder
+	for _, segment := range Parse(line) {
+		b.WriteString(segment.Text)
+	}
+	return b.String()
+}
+
+// applySgr applies the parameters of a "select graphic rendition" sequence.
+func applySgr(style Style, params string) Style {
+	codes := strings.Split(params, ";")
+	for i := 0; i < len(codes); i++ {
+		if strings.Contains(codes[i], ":") {
+			// colors with sub-parameters like 38:2::255:0:0
+			sub := strings.Split(codes[i], ":")
+			code, _ := strconv.Atoi(sub[0])
+			if color, _, ok := extendedColor(sub[1:], true); ok {
+				style = setExtended(style, code, color)
+			}
+			continue
+		}
+		code, err := strconv.Atoi(codes[i])
+		if err != nil {
+			if codes[i] != "" {
+				continue
+			}
+			// an empty parameter means 0
+			code = 0
+		}
+		switch {
+		case code == 0:
+			style = Style{}
+		case code == 1:
+			style.Bold = true
+		case code == 2:
+			style.Dim = true
+		case code == 3:
+			style.Italic = true
+		case code == 4:
+			style.Underline = true
+		case code == 22:
+			style.Bold = false
+			style.Dim = false
+		case code == 23:
+			style.Italic = false
+		case code == 24:
+			style.Underline = false
+		case code >= 30 && code <= 37:
+			style.Foreground = palette(code - 30)
+		case code == 39:
+			style.Foreground = ""
+		case code >= 40 && code <= 47:
+			style.Background = palette(code - 40)
+		case code == 49:
+			style.Background = ""
+		case code >= 90 && code <= 97:
+			style.Foreground = palette(code - 90 + 8)
+		case code >= 100 && code <= 107:
+			style.Background = palette(code - 100 + 8)
+		case code == 38 || code == 48:
+			color, used, ok := extendedColor(codes[i+1:], false)
+			if ok {
+				style = setExtended(style, code, color)
+			}
+			i += used
+		}
+	}
+	return style
+}
+
+func setExtended(style Style, code int, color string) Style {
+	switch code {
+	case 38:
+		style.Foreground = color
+	case 48:
+		style.Background = color
+	}
+	return style
+}
+
+// extendedColor parses the parameters following 38 or 48, which are either
+// 5;n for the 256 color palette or 2;r;g;b for true color. It returns the
+// number of parameters used.
+func extendedColor(params []string, subParams bool) (string, int, bool) {
+	if len(params) == 0 {
+		return "", 0, false
+	}
+	switch params[0] {
+	case "5":
+		if len(params) < 2 {
+			return "", len(params), false
+		}
+		n, err := strconv.Atoi(params[1])
+		if err != nil || n < 0 || n > 255 {
+			return "", 2, false
+		}
+		return palette(n), 2, true
+	case "2":
+		rgb := params[1:]
+		// with sub-parameters, a color space ID may precede the components
+		if subParams && len(rgb) == 4 {
+			rgb = rgb[1:]
+		}
+		if len(rgb) < 3 {
+			return "", len(params), false
+		}
+		var values [3]int
+		for j := range values {
+			v, err := strconv.Atoi(rgb[j])
+			if err != nil || v < 0 || v > 255 {
+				return "", 4, false
+			}
+			values[j] = v
+		}
+		return fmt.Sprintf("#%02x%02x%02x", values[0], values[1], values[2]), 4, true
+	}
+	return "", 1, false
+}
+
+// basicColors are the first 16 colors of the palette as used by xterm.
+var basicColors = [16]string{
+	"#000000", "#cd0000", "#00cd00", "#cdcd00", "#0000ee", "#cd00cd", "#00cdcd", "#e5e5e5",
+	"#7f7f7f", "#ff0000", "#00ff00", "#ffff00", "#5c5cff", "#ff00ff", "#00ffff", "#ffffff",
+}
+
+// palette returns a color of the 256 color palette.
+func palette(n int) string {
+	if n < 16 {
+		return basicColors[n]
+	}
+	if n < 232 {
+		// 6x6x6 color cube
+		n -= 16
+		levels := [6]int{0, 95, 135, 175, 215, 255}
+		return fmt.Sprintf("#%02x%02x%02x", levels[n/36], levels[n/6%6], levels[n%6])
+	}
+	gray := 8 + (n-232)*10
+	return fmt.Sprintf("#%02x%02x%02x", gray, gray, gray)
+}
@@ -0,0 +1,56 @@
+package ansi
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	red := Style{Foreground: "#cd0000"}
+	testCases := []struct {
+		name     string
+		line     string
+		expected []Segment
+	}{
+		{"Plain", "hello", []Segment{{Text: "hello"}}},
+		{"Color and reset", "\x1b[31merror\x1b[0m done", []Segment{{Text: "error", Style: red}, {Text: " done"}}},
+		{"Empty reset", "\x1b[31mA\x1b[mB", []Segment{{Text: "A", Style: red}, {Text: "B"}}},
+		{"Bold bright", "\x1b[1;92mok", []Segment{{Text: "ok", Style: Style{Foreground: "#00ff00", Bold: true}}}},
+		{"256 colors", "\x1b[38;5;196;48;5;232mx", []Segment{{Text: "x", Style: Style{Foreground: "#ff0000", Background: "#080808"}}}},
+		{"True color", "\x1b[38;2;1;2;3mx", []Segment{{Text: "x", Style: Style{Foreground: "#010203"}}}},
+		{"Sub-parameters", "\x1b[38:2::1:2:3mx", []Segment{{Text: "x", Style: Style{Foreground: "#010203"}}}},
+		{"Cursor movement", "a\x1b[2K\x1b[1Gb", []Segment{{Text: "ab"}}},
+		{"Window title", "\x1b]0;title\x07text", []Segment{{Text: "text"}}},
+		{"Title with ST", "\x1b]0;title\x1b\\text", []Segment{{Text: "text"}}},
+		{"Charset", "\x1b(Btext", []Segment{{Text: "text"}}},
+		{"Control characters", "a\rb\x08c\td", []Segment{{Text: "abc\td"}}},
+		{"Unterminated", "text\x1b[3", []Segment{{Text: "text"}}},
+		{"Markup is text", "<b>", []Segment{{Text: "<b>"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			segments := Parse(tc.line)
+
+			// Assert
+			if !slices.Equal(segments, tc.expected) {
+				t.Errorf("Expected %+v, got %+v", tc.expected, segments)
+			}
+		})
+	}
+}
+
+func TestStyleCSS(t *testing.T) {
+	// Arrange
+	style := Style{Foreground: "#cd0000", Bold: true, Underline: true}
+
+	// Act
+	css := style.CSS()
+
+	// Assert
+	expected := "color:#cd0000;font-weight:bold;text-decoration:underline;"
+	if css != expected {
+		t.Errorf("Expected %q, got %q", expected, css)
+	}
+}
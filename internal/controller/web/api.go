@@ -0,0 +1,446 @@
+package web
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+	"github.com/jrammler/wheelhouse/internal/service"
+	"github.com/jrammler/wheelhouse/internal/service/command"
+	"github.com/jrammler/wheelhouse/internal/storage"
+)
+
+//go:embed openapi.json
+var openApiDocument []byte
+
+const apiPrefix = "/api/v1"
+
+func SetupApiMux(service *service.Service, mux *http.ServeMux) {
+	mux.HandleFunc("GET "+apiPrefix+"/commands", handleApiCommandsGet(service))
+	mux.HandleFunc("POST "+apiPrefix+"/commands/{id}/executions", handleApiExecutePost(service))
+	mux.HandleFunc("GET "+apiPrefix+"/executions", handleApiExecutionsGet(service))
+	mux.HandleFunc("GET "+apiPrefix+"/executions/{id}", handleApiExecutionGet(service))
+	mux.HandleFunc("GET "+apiPrefix+"/executions/{id}/log", handleApiExecutionLogGet(service))
+	mux.HandleFunc("GET "+apiPrefix+"/executions/{id}/log/full", handleApiExecutionFullLogGet(service))
+	mux.HandleFunc("POST "+apiPrefix+"/executions/{id}/cancel", handleApiExecutionCancelPost(service))
+}
+
+func handleOpenApiGet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openApiDocument)
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type apiCommand struct {
+	Id          string                    `json:"id"`
+	Name        string                    `json:"name"`
+	Parameters  []entity.CommandParameter `json:"parameters"`
+	Timeout     *entity.Duration          `json:"timeout,omitempty"`
+	Concurrency entity.ConcurrencyPolicy  `json:"concurrency,omitempty"`
+	Schedule    string                    `json:"schedule,omitempty"`
+	NextRun     *time.Time                `json:"next_run,omitempty"`
+	LastRun     *time.Time                `json:"last_run,omitempty"`
+}
+
+type apiExecutionSummary struct {
+	ExecId      int                `json:"exec_id"`
+	Time        time.Time          `json:"time"`
+	CommandName string             `json:"command_name"`
+	User        string             `json:"user"`
+	Trigger     entity.TriggerType `json:"trigger"`
+	State       string             `json:"state"`
+	ExitCode    *int               `json:"exit_code"`
+	Duration    *entity.Duration   `json:"duration,omitempty"`
+	ParentId    *int               `json:"parent_id,omitempty"`
+}
+
+type apiParameterValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type apiExecution struct {
+	ExecId        int                `json:"exec_id"`
+	CommandId     string             `json:"command_id"`
+	CommandName   string             `json:"command_name"`
+	User          string             `json:"user"`
+	Trigger       entity.TriggerType `json:"trigger"`
+	TriggerSource string             `json:"trigger_source,omitempty"`
+	SourceIp      string             `json:"source_ip,omitempty"`
+	UserAgent     string             `json:"user_agent,omitempty"`
+	Time          time.Time          `json:"time"`
+	StartTime     *time.Time         `json:"start_time,omitempty"`
+	EndTime       *time.Time         `json:"end_time,omitempty"`
+	Duration      *entity.Duration   `json:"duration,omitempty"`
+	State         string             `json:"state"`
+	ExitCode      *int               `json:"exit_code"`
+	CancelledBy   *string            `json:"cancelled_by,omitempty"`
+	ParentId      *int               `json:"parent_id,omitempty"`
+	// PeakMemory is given in bytes
+	PeakMemory *int64              `json:"peak_memory,omitempty"`
+	CpuTime    *entity.Duration    `json:"cpu_time,omitempty"`
+	Approval   *apiApproval        `json:"approval,omitempty"`
+	Parameters []apiParameterValue `json:"parameters"`
+}
+
+type apiApproval struct {
+	Role         string                  `json:"role"`
+	Expires      time.Time               `json:"expires"`
+	Decision     entity.ApprovalDecision `json:"decision,omitempty"`
+	DecidedBy    string                  `json:"decided_by,omitempty"`
+	Comment      string                  `json:"comment,omitempty"`
+	DecisionTime *time.Time              `json:"decision_time,omitempty"`
+}
+
+type apiLogEntry struct {
+	Index  int        `json:"index"`
+	Stream string     `json:"stream"`
+	Data   string     `json:"data"`
+	Time   *time.Time `json:"time,omitempty"`
+}
+
+// optionalDuration converts a duration for the JSON output, keeping nil.
+func optionalDuration(d *time.Duration) *entity.Duration {
+	if d == nil {
+		return nil
+	}
+	duration := entity.Duration(*d)
+	return &duration
+}
+
+type apiExecuteRequest struct {
+	Parameters map[string]any `json:"parameters"`
+}
+
+type apiExecuteResponse struct {
+	ExecId int `json:"exec_id"`
+}
+
+func writeJson(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		slog.Error("Error while writing JSON response", "error", err)
+	}
+}
+
+func writeJsonError(w http.ResponseWriter, status int, msg string) {
+	writeJson(w, status, apiError{Error: msg})
+}
+
+// writeServiceError maps errors of the command service to HTTP status codes.
+func writeServiceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, command.CommandNotFoundError), errors.Is(err, storage.LogFileNotFoundError):
+		writeJsonError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, command.UnauthorizedError):
+		writeJsonError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, command.InvalidParameterError):
+		writeJsonError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, command.ExecutionNotRunningError), errors.Is(err, command.ConcurrencyLimitError):
+		writeJsonError(w, http.StatusConflict, err.Error())
+	default:
+		slog.Error("Error while handling API request", "error", err)
+		writeJsonError(w, http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+func handleApiCommandsGet(service *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := GetUser(r.Context())
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		commands, err := service.CommandService.GetCommands(r.Context(), user)
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		schedules := service.ScheduleService.GetSchedules(r.Context())
+		res := make([]apiCommand, 0, len(commands))
+		for _, command := range commands {
+			params := command.Parameters
+			if params == nil {
+				params = make([]entity.CommandParameter, 0)
+			}
+			schedule := schedules[command.Id]
+			res = append(res, apiCommand{
+				Id:          command.Id,
+				Name:        command.Name,
+				Parameters:  params,
+				Timeout:     command.Timeout,
+				Concurrency: command.Concurrency,
+				Schedule:    command.Schedule,
+				NextRun:     schedule.NextRun,
+				LastRun:     schedule.LastRun,
+			})
+		}
+		writeJson(w, http.StatusOK, res)
+	}
+}
+
+// parameterString converts a JSON parameter value to the string representation
+// used by the command service.
+func parameterString(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("unsupported parameter value %v", value)
+	}
+}
+
+func handleApiExecutePost(service *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := GetUser(r.Context())
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		req := apiExecuteRequest{}
+		if r.ContentLength != 0 {
+			err = json.NewDecoder(r.Body).Decode(&req)
+			if err != nil {
+				writeJsonError(w, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+		}
+		params := make(map[string]string, len(req.Parameters))
+		for name, value := range req.Parameters {
+			params[name], err = parameterString(value)
+			if err != nil {
+				writeJsonError(w, http.StatusBadRequest, fmt.Sprintf("Parameter %q: %s", name, err))
+				return
+			}
+		}
+
+		execId, err := service.CommandService.ExecuteCommand(r.Context(), user, r.PathValue("id"), params, requestTrigger(r, entity.TriggerTypeApi))
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("%s/executions/%d", apiPrefix, execId))
+		writeJson(w, http.StatusCreated, apiExecuteResponse{ExecId: execId})
+	}
+}
+
+// apiHistoryLimit is the number of executions returned if the client does not
+// set a limit, apiMaxHistoryLimit the largest limit it may set.
+const (
+	apiHistoryLimit    = 100
+	apiMaxHistoryLimit = 1000
+)
+
+func handleApiExecutionsGet(service *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := GetUser(r.Context())
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		filter := entity.ExecutionFilter{User: r.FormValue("user"), Limit: apiHistoryLimit}
+		if limit := r.FormValue("limit"); limit != "" {
+			filter.Limit, err = strconv.Atoi(limit)
+			if err != nil || filter.Limit < 1 || filter.Limit > apiMaxHistoryLimit {
+				writeJsonError(w, http.StatusBadRequest, "Invalid limit")
+				return
+			}
+		}
+		if offset := r.FormValue("offset"); offset != "" {
+			filter.Offset, err = strconv.Atoi(offset)
+			if err != nil || filter.Offset < 0 {
+				writeJsonError(w, http.StatusBadRequest, "Invalid offset")
+				return
+			}
+		}
+		if parent := r.FormValue("parent"); parent != "" {
+			parentId, err := strconv.Atoi(parent)
+			if err != nil {
+				writeJsonError(w, http.StatusBadRequest, "Invalid parent execution ID")
+				return
+			}
+			filter.ParentId = &parentId
+		}
+		history, err := service.CommandService.GetExecutionHistory(r.Context(), user, filter)
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		res := make([]apiExecutionSummary, 0, len(history))
+		for _, entry := range history {
+			res = append(res, apiExecutionSummary{
+				ExecId:      entry.ExecId,
+				Time:        entry.Time,
+				CommandName: entry.CommandName,
+				User:        entry.User,
+				Trigger:     entry.Trigger,
+				State:       entity.ExecutionState(entry.ExitCode, entry.Status),
+				ExitCode:    entry.ExitCode,
+				Duration:    optionalDuration(entry.Duration),
+				ParentId:    entry.ParentId,
+			})
+		}
+		writeJson(w, http.StatusOK, res)
+	}
+}
+
+// apiGetExecution loads the execution referenced by the id path value and
+// writes an error response if that fails.
+func apiGetExecution(service *service.Service, w http.ResponseWriter, r *http.Request) (*entity.CommandExecution, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, "Invalid execution ID")
+		return nil, false
+	}
+	user, err := GetUser(r.Context())
+	if err != nil {
+		writeServiceError(w, err)
+		return nil, false
+	}
+	execution, err := service.CommandService.GetExecution(r.Context(), user, id)
+	if err != nil {
+		writeServiceError(w, err)
+		return nil, false
+	}
+	return execution, true
+}
+
+func handleApiExecutionGet(service *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		execution, ok := apiGetExecution(service, w, r)
+		if !ok {
+			return
+		}
+		params := make([]apiParameterValue, 0, len(execution.Parameters))
+		for _, param := range execution.Parameters {
+			params = append(params, apiParameterValue{Name: param.Name, Value: param.Value})
+		}
+		var duration *time.Duration
+		if d, ok := execution.Duration(); ok {
+			duration = &d
+		}
+		var approval *apiApproval
+		if a := execution.Approval; a != nil {
+			approval = &apiApproval{
+				Role:         a.Role,
+				Expires:      a.Expires,
+				Decision:     a.Decision,
+				DecidedBy:    a.DecidedBy,
+				Comment:      a.Comment,
+				DecisionTime: a.DecisionTime,
+			}
+		}
+		writeJson(w, http.StatusOK, apiExecution{
+			ExecId:        execution.ExecId,
+			CommandId:     execution.CommandId,
+			CommandName:   execution.CommandName,
+			User:          execution.User,
+			Trigger:       execution.Trigger.Type,
+			TriggerSource: execution.Trigger.Source,
+			SourceIp:      execution.Trigger.SourceIp,
+			UserAgent:     execution.Trigger.UserAgent,
+			Time:          execution.ExecTime,
+			StartTime:     execution.StartTime,
+			EndTime:       execution.EndTime,
+			Duration:      optionalDuration(duration),
+			State:         entity.ExecutionState(execution.ExitCode, execution.Status),
+			ExitCode:      execution.ExitCode,
+			CancelledBy:   execution.CancelledBy,
+			ParentId:      execution.ParentId,
+			PeakMemory:    execution.Usage.PeakMemory,
+			CpuTime:       optionalDuration(execution.Usage.CpuTime),
+			Approval:      approval,
+			Parameters:    params,
+		})
+	}
+}
+
+func handleApiExecutionLogGet(service *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := 0
+		startStr := r.FormValue("start")
+		if startStr != "" {
+			var err error
+			start, err = strconv.Atoi(startStr)
+			if err != nil || start < 0 {
+				writeJsonError(w, http.StatusBadRequest, "Invalid start")
+				return
+			}
+		}
+		execution, ok := apiGetExecution(service, w, r)
+		if !ok {
+			return
+		}
+		res := make([]apiLogEntry, 0)
+		for i := start; i < len(execution.Log); i++ {
+			entry := apiLogEntry{
+				Index:  i,
+				Stream: execution.Log[i].Stream,
+				Data:   execution.Log[i].Data,
+			}
+			if !execution.Log[i].Time.IsZero() {
+				entry.Time = &execution.Log[i].Time
+			}
+			res = append(res, entry)
+		}
+		writeJson(w, http.StatusOK, res)
+	}
+}
+
+func handleApiExecutionFullLogGet(service *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			writeJsonError(w, http.StatusBadRequest, "Invalid execution ID")
+			return
+		}
+		user, err := GetUser(r.Context())
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		log, err := service.CommandService.OpenExecutionLog(r.Context(), user, id)
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		defer log.Close()
+		writeLogFile(w, id, log)
+	}
+}
+
+func handleApiExecutionCancelPost(service *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			writeJsonError(w, http.StatusBadRequest, "Invalid execution ID")
+			return
+		}
+		user, err := GetUser(r.Context())
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		err = service.CommandService.CancelExecution(r.Context(), user, id)
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -0,0 +1,69 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/jrammler/wheelhouse/internal/controller/web/templates"
+	"github.com/jrammler/wheelhouse/internal/service"
+	"github.com/jrammler/wheelhouse/internal/service/command"
+)
+
+func SetupApprovalMux(service *service.Service, mux *http.ServeMux) {
+	mux.HandleFunc("GET /approvals", handleApprovalsGet(service))
+	mux.HandleFunc("POST /approvals/{id}", handleApprovalPost(service))
+}
+
+func handleApprovalsGet(service *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := GetUser(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		pending, err := service.CommandService.GetPendingApprovals(r.Context(), user)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		templates.ApprovalList(pending).Render(r.Context(), w)
+	}
+}
+
+func handleApprovalPost(service *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		user, err := GetUser(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var approved bool
+		switch r.FormValue("decision") {
+		case "approve":
+			approved = true
+		case "reject":
+		default:
+			http.Error(w, "Invalid decision", http.StatusBadRequest)
+			return
+		}
+		err = service.CommandService.DecideApproval(r.Context(), user, id, approved, r.FormValue("comment"))
+		switch {
+		case errors.Is(err, command.UnauthorizedError), errors.Is(err, command.SelfApprovalError):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case errors.Is(err, command.ApprovalNotPendingError), errors.Is(err, command.ConcurrencyLimitError):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/approvals", http.StatusFound)
+	}
+}
@@ -0,0 +1,34 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jrammler/wheelhouse/internal/controller/web/templates"
+	"github.com/jrammler/wheelhouse/internal/service"
+	"github.com/jrammler/wheelhouse/internal/service/audit"
+)
+
+func SetupAuditMux(service *service.Service, mux *http.ServeMux) {
+	mux.HandleFunc("GET /audit", handleAuditGet(service))
+}
+
+func handleAuditGet(service *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := GetUser(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		events, err := service.AuditService.GetEvents(r.Context(), user)
+		if errors.Is(err, audit.UnauthorizedError) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		templates.AuditList(events).Render(r.Context(), w)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/jrammler/wheelhouse/internal/controller/web/templates"
 	"github.com/jrammler/wheelhouse/internal/entity"
@@ -30,8 +31,8 @@ func GetUser(ctx context.Context) (entity.User, error) {
 func SetupAuthentication(service *service.Service, mux *http.ServeMux) *http.ServeMux {
 	mux.HandleFunc("GET /login", handleLoginGet)
 	mux.HandleFunc("POST /login", handleLoginPost(service))
-	mux.HandleFunc("GET /logout", handleLogoutGet(service))
 	authenticatedMux := http.NewServeMux()
+	authenticatedMux.HandleFunc("POST /logout", handleLogoutPost(service))
 	mux.HandleFunc("/", authenticationMiddleware(service, authenticatedMux))
 	return authenticatedMux
 }
@@ -42,7 +43,14 @@ func handleLoginGet(w http.ResponseWriter, r *http.Request) {
 
 func handleLoginPost(service *service.Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := r.ParseForm()
+		// there is no session yet, so other sites can only be told apart by
+		// the headers of the browser
+		err := checkOrigin(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		err = r.ParseForm()
 		if err != nil {
 			http.Error(w, "Error parsing form", http.StatusBadRequest)
 			return
@@ -52,60 +60,121 @@ func handleLoginPost(service *service.Service) http.HandlerFunc {
 
 		sessionToken, expiration, err := service.AuthService.LoginUser(r.Context(), username, password)
 		if err != nil {
+			service.AuditService.Record(r.Context(), entity.AuditEvent{
+				Type:     entity.AuditEventLoginFailed,
+				SourceIp: sourceIp(r),
+				Details:  map[string]string{"username": username},
+			})
 			templates.Login(true).Render(r.Context(), w)
 			return
 		}
+		service.AuditService.Record(r.Context(), entity.AuditEvent{
+			Type:     entity.AuditEventLogin,
+			User:     username,
+			SourceIp: sourceIp(r),
+		})
 
-		cookie := &http.Cookie{
-			Name:     "session_token",
-			Value:    sessionToken,
-			HttpOnly: true,
-			Path:     "/", // important to set path to root, so it is valid for all paths
-			Expires:  *expiration,
-		}
+		cookie := sessionCookie(r, sessionToken)
+		cookie.Expires = *expiration
 		http.SetCookie(w, cookie)
 		http.Redirect(w, r, "/", http.StatusFound)
 	}
 }
 
-func handleLogoutGet(service *service.Service) http.HandlerFunc {
+func handleLogoutPost(service *service.Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		sessionCookie, err := r.Cookie("session_token")
+		cookie, err := r.Cookie("session_token")
 		if err != nil {
 			http.Redirect(w, r, "/login", http.StatusFound)
 			return
 		}
 
-		service.AuthService.LogoutUser(r.Context(), sessionCookie.Value)
+		user, err := GetUser(r.Context())
+		if err == nil {
+			service.AuditService.Record(r.Context(), entity.AuditEvent{
+				Type:     entity.AuditEventLogout,
+				User:     user.Username,
+				SourceIp: sourceIp(r),
+			})
+		}
+		service.AuthService.LogoutUser(r.Context(), cookie.Value)
 
 		// clear session cookie
-		cookie := &http.Cookie{
-			Name:     "session_token",
-			Value:    "",
-			HttpOnly: true,
-			Path:     "/",
-			MaxAge:   -1, // this tells the browser to delete the cookie
-		}
+		cookie = sessionCookie(r, "")
+		cookie.MaxAge = -1 // this tells the browser to delete the cookie
 		http.SetCookie(w, cookie)
 		http.Redirect(w, r, "/login", http.StatusFound)
 	}
 }
 
+// sessionCookie returns the cookie holding the session token. Other sites
+// can not send it with requests that change state, and it is only sent over
+// HTTPS if the browser uses it.
+func sessionCookie(r *http.Request, sessionToken string) *http.Cookie {
+	return &http.Cookie{
+		Name:     "session_token",
+		Value:    sessionToken,
+		HttpOnly: true,
+		Path:     "/", // important to set path to root, so it is valid for all paths
+		SameSite: http.SameSiteLaxMode,
+		Secure:   secureRequest(r),
+	}
+}
+
+// unauthenticated redirects browsers to the login page, while API clients get
+// a plain 401 response.
+func unauthenticated(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, apiPrefix+"/") {
+		writeJsonError(w, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+	w.Header().Add("Location", "/login")
+	w.WriteHeader(http.StatusFound)
+}
+
+// forbidden rejects a request, with a JSON error for API clients.
+func forbidden(w http.ResponseWriter, r *http.Request, err error) {
+	if strings.HasPrefix(r.URL.Path, apiPrefix+"/") {
+		writeJsonError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	http.Error(w, err.Error(), http.StatusForbidden)
+}
+
 func authenticationMiddleware(service *service.Service, next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+			user, err := service.AuthService.GetTokenUser(r.Context(), token)
+			if err != nil {
+				writeJsonError(w, http.StatusUnauthorized, "Invalid API token")
+				return
+			}
+			next.ServeHTTP(w, addUser(r, user))
+			return
+		}
+
 		sessionCookie, err := r.Cookie("session_token")
 		if err != nil {
-			w.Header().Add("Location", "/login")
-			w.WriteHeader(http.StatusFound)
+			unauthenticated(w, r)
 			return
 		}
 		sessionToken := sessionCookie.Value
-		user, err := service.AuthService.GetSessionUser(r.Context(), sessionToken)
+		user, csrfToken, err := service.AuthService.GetSessionUser(r.Context(), sessionToken)
 		if err != nil {
-			w.Header().Add("Location", "/login")
-			w.WriteHeader(http.StatusFound)
+			unauthenticated(w, r)
 			return
 		}
+		if !safeMethod(r.Method) {
+			err = checkOrigin(r)
+			if err == nil {
+				err = checkCsrfToken(r, csrfToken)
+			}
+			if err != nil {
+				forbidden(w, r, err)
+				return
+			}
+		}
+		r = r.WithContext(templates.WithCsrfToken(r.Context(), csrfToken))
 		next.ServeHTTP(w, addUser(r, user))
 	}
 }
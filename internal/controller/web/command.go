@@ -1,20 +1,33 @@
 package web
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/jrammler/wheelhouse/internal/controller/web/templates"
+	"github.com/jrammler/wheelhouse/internal/entity"
 	"github.com/jrammler/wheelhouse/internal/service"
+	"github.com/jrammler/wheelhouse/internal/service/command"
+	"github.com/jrammler/wheelhouse/internal/storage"
 )
 
 func SetupCommandMux(service *service.Service, mux *http.ServeMux) {
 	mux.HandleFunc("GET /commands", handleCommandsGet(service))
-	mux.HandleFunc("POST /execute/{id}", handleExecutePost(service))
+	mux.HandleFunc("POST /execute/{id}", handleExecutePost(service, newConfirmations()))
 	mux.HandleFunc("GET /executions", handleExecutionsGet(service))
 	mux.HandleFunc("GET /executions/{id}", handleExecutionDetailsGet(service))
 	mux.HandleFunc("GET /executions/{id}/log", handleExecutionLogGet(service))
+	mux.HandleFunc("GET /executions/{id}/log/full", handleExecutionFullLogGet(service))
+	mux.HandleFunc("GET /executions/{id}/stream", handleExecutionStreamGet(service))
+	mux.HandleFunc("POST /executions/{id}/cancel", handleExecutionCancelPost(service))
 }
 
 func handleCommandsGet(service *service.Service) http.HandlerFunc {
@@ -29,19 +42,47 @@ func handleCommandsGet(service *service.Service) http.HandlerFunc {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		templates.Commands(commands).Render(r.Context(), w)
+		schedules := service.ScheduleService.GetSchedules(r.Context())
+		templates.Commands(commands, schedules).Render(r.Context(), w)
 	}
 }
 
-func handleExecutePost(service *service.Service) http.HandlerFunc {
+func handleExecutePost(service *service.Service, confirmations *confirmations) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user, err := GetUser(r.Context())
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		err = r.ParseForm()
+		if err != nil {
+			http.Error(w, "Error parsing form", http.StatusBadRequest)
+			return
+		}
 		id := r.PathValue("id")
-		execId, err := service.CommandService.ExecuteCommand(r.Context(), user, id)
+		params := formParameters(r)
+		commands, err := service.CommandService.GetCommands(r.Context(), user)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		i := slices.IndexFunc(commands, func(c entity.Command) bool { return c.Id == id })
+		if i >= 0 && commands[i].Confirm != "" {
+			var confirmed bool
+			params, confirmed = confirmExecution(w, r, service, confirmations, commands, &commands[i], params)
+			if !confirmed {
+				return
+			}
+		}
+		execId, err := service.CommandService.ExecuteCommand(r.Context(), user, id, params, requestTrigger(r, entity.TriggerTypeWeb))
+		if errors.Is(err, command.InvalidParameterError) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, command.ConcurrencyLimitError) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
@@ -50,6 +91,75 @@ func handleExecutePost(service *service.Service) http.HandlerFunc {
 	}
 }
 
+// confirmExecution asks the user to confirm executing a command, until the
+// run form is sent with a valid confirmation token. It returns the
+// parameters of the confirmed execution and false if it wrote a response
+// instead.
+func confirmExecution(w http.ResponseWriter, r *http.Request, service *service.Service, confirmations *confirmations,
+	commands []entity.Command, command *entity.Command, params map[string]string) (map[string]string, bool) {
+	session := requestSession(r)
+	mismatch := false
+	if token := r.PostForm.Get("confirmation"); token != "" {
+		confirmed, ok := confirmations.take(token, session, command.Id)
+		if !ok {
+			http.Error(w, "The confirmation is invalid or expired", http.StatusBadRequest)
+			return nil, false
+		}
+		if command.Confirm != entity.ConfirmPolicyName || r.PostForm.Get("confirm_name") == command.Name {
+			return confirmed, true
+		}
+		// ask again for the parameters that were shown
+		params = confirmed
+		mismatch = true
+	}
+	token, err := confirmations.issue(session, command.Id, params)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	schedules := service.ScheduleService.GetSchedules(r.Context())
+	templates.ConfirmExecution(commands, schedules, *command, params, token, mismatch).Render(r.Context(), w)
+	return nil, false
+}
+
+// requestTrigger describes the request executing a command. The source IP is
+// the address of the direct peer, proxy headers are not trusted.
+func requestTrigger(r *http.Request, triggerType entity.TriggerType) entity.Trigger {
+	return entity.Trigger{
+		Type:      triggerType,
+		SourceIp:  sourceIp(r),
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// sourceIp returns the address of the client without the port.
+func sourceIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+const parameterFieldPrefix = "param."
+
+// formParameters collects the parameter values of the run form. If a field is
+// sent multiple times the last value wins, which lets checkboxes be preceded
+// by a hidden field holding the unchecked value.
+func formParameters(r *http.Request) map[string]string {
+	params := make(map[string]string)
+	for key, values := range r.PostForm {
+		name, found := strings.CutPrefix(key, parameterFieldPrefix)
+		if found && len(values) > 0 {
+			params[name] = values[len(values)-1]
+		}
+	}
+	return params
+}
+
+// historyPageSize is the number of executions shown on a page of the history.
+const historyPageSize = 100
+
 func handleExecutionsGet(service *service.Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user, err := GetUser(r.Context())
@@ -57,12 +167,20 @@ func handleExecutionsGet(service *service.Service) http.HandlerFunc {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		history, err := service.CommandService.GetExecutionHistory(r.Context(), user)
+		filter := entity.ExecutionFilter{User: r.FormValue("user"), Limit: historyPageSize}
+		if offset := r.FormValue("offset"); offset != "" {
+			filter.Offset, err = strconv.Atoi(offset)
+			if err != nil || filter.Offset < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		history, err := service.CommandService.GetExecutionHistory(r.Context(), user, filter)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		templates.ExecutionList(history).Render(r.Context(), w)
+		templates.ExecutionList(history, filter).Render(r.Context(), w)
 	}
 }
 
@@ -84,7 +202,12 @@ func handleExecutionDetailsGet(service *service.Service) http.HandlerFunc {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
-		templates.ExecutionDetails(execution).Render(r.Context(), w)
+		steps, err := service.CommandService.GetExecutionHistory(r.Context(), user, entity.ExecutionFilter{ParentId: &execution.ExecId})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		templates.ExecutionDetails(execution, steps).Render(r.Context(), w)
 	}
 }
 
@@ -115,3 +238,141 @@ func handleExecutionLogGet(service *service.Service) http.HandlerFunc {
 		templates.LogList(execution, &start).Render(r.Context(), w)
 	}
 }
+
+func handleExecutionFullLogGet(service *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		user, err := GetUser(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		log, err := service.CommandService.OpenExecutionLog(r.Context(), user, id)
+		if errors.Is(err, storage.LogFileNotFoundError) {
+			http.Error(w, "The full log of this execution is not available", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		defer log.Close()
+		writeLogFile(w, id, log)
+	}
+}
+
+// writeLogFile sends the full log of an execution as a file download.
+func writeLogFile(w http.ResponseWriter, execId int, log io.Reader) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"execution-%d.log\"", execId))
+	w.WriteHeader(http.StatusOK)
+	_, err := io.Copy(w, log)
+	if err != nil {
+		slog.Error("Error while sending log file", "exec_id", execId, "error", err)
+	}
+}
+
+// handleExecutionStreamGet streams the log of an execution as server-sent
+// events. Each log line is sent as "log" event with its index as event ID, so
+// browsers resume after reconnecting via the Last-Event-ID header. A final
+// "exit" event carries the state of the finished execution.
+func handleExecutionStreamGet(service *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		start := 0
+		startStr := r.FormValue("start")
+		if lastEventId := r.Header.Get("Last-Event-ID"); lastEventId != "" {
+			lastIdx, err := strconv.Atoi(lastEventId)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			start = lastIdx + 1
+		} else if startStr != "" {
+			start, err = strconv.Atoi(startStr)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		user, err := GetUser(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		events, err := service.CommandService.SubscribeExecution(r.Context(), user, id, start)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		rc := http.NewResponseController(w)
+		for event := range events {
+			buf := &bytes.Buffer{}
+			if event.Log != nil {
+				templates.LogLine(*event.Log, event.StartTime).Render(r.Context(), buf)
+				err = writeServerSentEvent(w, "log", strconv.Itoa(event.Index), buf.String())
+			} else {
+				templates.ExecutionState(event.Execution).Render(r.Context(), buf)
+				err = writeServerSentEvent(w, "exit", "", buf.String())
+			}
+			if err == nil {
+				err = rc.Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeServerSentEvent(w io.Writer, event string, id string, data string) error {
+	msg := &strings.Builder{}
+	fmt.Fprintf(msg, "event: %s\n", event)
+	if id != "" {
+		fmt.Fprintf(msg, "id: %s\n", id)
+	}
+	// carriage returns also end a line in the event stream format
+	data = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(data)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(msg, "data: %s\n", line)
+	}
+	msg.WriteString("\n")
+	_, err := io.WriteString(w, msg.String())
+	return err
+}
+
+func handleExecutionCancelPost(service *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		user, err := GetUser(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = service.CommandService.CancelExecution(r.Context(), user, id)
+		// an execution that finished in the meantime is shown with its final state
+		if err != nil && !errors.Is(err, command.ExecutionNotRunningError) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/executions/%d", id), http.StatusFound)
+	}
+}
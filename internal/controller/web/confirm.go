@@ -0,0 +1,87 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// confirmationLifetime is how long users have to confirm an execution.
+const confirmationLifetime = 5 * time.Minute
+
+// pendingConfirmation is an execution the user was asked to confirm. The
+// parameters are kept on the server, so the confirmed execution gets the
+// values that were shown.
+type pendingConfirmation struct {
+	session   string
+	commandId string
+	params    map[string]string
+	expires   time.Time
+}
+
+// confirmations holds the executions waiting for confirmation by their
+// single-use token.
+type confirmations struct {
+	mutex   sync.Mutex
+	pending map[string]pendingConfirmation
+}
+
+func newConfirmations() *confirmations {
+	return &confirmations{pending: make(map[string]pendingConfirmation)}
+}
+
+// issue returns a token that confirms executing the command with the
+// parameters from the given session.
+func (c *confirmations) issue(session string, commandId string, params map[string]string) (string, error) {
+	tokenBytes := make([]byte, 32)
+	_, err := rand.Read(tokenBytes)
+	if err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	for t, confirmation := range c.pending {
+		if now.After(confirmation.expires) {
+			delete(c.pending, t)
+		}
+	}
+	c.pending[token] = pendingConfirmation{
+		session:   session,
+		commandId: commandId,
+		params:    params,
+		expires:   now.Add(confirmationLifetime),
+	}
+	return token, nil
+}
+
+// take consumes a token and returns the parameters of the confirmed
+// execution. It fails if the token expired or was issued for another session
+// or command.
+func (c *confirmations) take(token string, session string, commandId string) (map[string]string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	confirmation, ok := c.pending[token]
+	if !ok {
+		return nil, false
+	}
+	delete(c.pending, token)
+	if confirmation.session != session || confirmation.commandId != commandId || time.Now().After(confirmation.expires) {
+		return nil, false
+	}
+	return confirmation.params, true
+}
+
+// requestSession identifies the session a request belongs to, the session
+// cookie for browsers and the API token otherwise.
+func requestSession(r *http.Request) string {
+	sessionCookie, err := r.Cookie("session_token")
+	if err == nil {
+		return sessionCookie.Value
+	}
+	return r.Header.Get("Authorization")
+}
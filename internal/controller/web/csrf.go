@@ -0,0 +1,68 @@
+package web
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var CrossOriginError = errors.New("Cross-origin request rejected")
+var CsrfTokenError = errors.New("Missing or invalid CSRF token")
+
+// csrfHeader carries the CSRF token of the session, htmx sends it with every
+// request of a page.
+const csrfHeader = "X-CSRF-Token"
+
+// safeMethod reports whether requests with the method do not change state.
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// checkOrigin rejects requests that browsers sent from another site. Clients
+// that send neither Sec-Fetch-Site nor Origin are let through, the CSRF token
+// protects them.
+func checkOrigin(r *http.Request) error {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return CrossOriginError
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return CrossOriginError
+	}
+	// only the host is compared, as a proxy terminating TLS changes the
+	// scheme. Browsers do not let other sites set X-Forwarded-Host.
+	if strings.EqualFold(u.Host, r.Host) || strings.EqualFold(u.Host, r.Header.Get("X-Forwarded-Host")) {
+		return nil
+	}
+	return CrossOriginError
+}
+
+// checkCsrfToken compares the CSRF token of the request with the one of the
+// session.
+func checkCsrfToken(r *http.Request, csrfToken string) error {
+	token := r.Header.Get(csrfHeader)
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(csrfToken)) != 1 {
+		return CsrfTokenError
+	}
+	return nil
+}
+
+// secureRequest reports whether the browser reached the server over HTTPS,
+// directly or through a proxy.
+func secureRequest(r *http.Request) bool {
+	return r.TLS != nil ||
+		r.Header.Get("X-Forwarded-Proto") == "https" ||
+		strings.HasPrefix(r.Header.Get("Origin"), "https://")
+}
@@ -0,0 +1,58 @@
+package web
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+	"github.com/jrammler/wheelhouse/internal/service"
+	"github.com/jrammler/wheelhouse/internal/service/hook"
+)
+
+// maxHookBodySize limits the size of the payload of webhook triggers.
+const maxHookBodySize = 1 << 20
+
+// SetupHookMux registers the webhook triggers, which authenticate requests by
+// their signature instead of a session or token.
+func SetupHookMux(service *service.Service, mux *http.ServeMux) {
+	mux.HandleFunc("POST /hooks/{name}", handleHookPost(service))
+}
+
+func handleHookPost(service *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxHookBodySize))
+		if err != nil {
+			writeJsonError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+		name := r.PathValue("name")
+		execId, err := service.WebhookService.TriggerWebhook(r.Context(), name, entity.WebhookRequest{
+			Body:         body,
+			HubSignature: r.Header.Get("X-Hub-Signature-256"),
+			Signature:    r.Header.Get("X-Wheelhouse-Signature-256"),
+			Timestamp:    r.Header.Get("X-Wheelhouse-Timestamp"),
+			Delivery:     r.Header.Get("X-GitHub-Delivery"),
+			SourceIp:     sourceIp(r),
+			UserAgent:    r.UserAgent(),
+		})
+		if err != nil {
+			slog.Warn("Rejected webhook request", "trigger", name, "source_ip", sourceIp(r), "error", err)
+		}
+		switch {
+		case err == nil:
+			writeJson(w, http.StatusAccepted, apiExecuteResponse{ExecId: execId})
+		case errors.Is(err, hook.TriggerNotFoundError):
+			writeJsonError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, hook.InvalidSignatureError), errors.Is(err, hook.ExpiredRequestError), errors.Is(err, hook.GithubSignatureDisabledError):
+			writeJsonError(w, http.StatusUnauthorized, err.Error())
+		case errors.Is(err, hook.ReplayError):
+			writeJsonError(w, http.StatusConflict, err.Error())
+		case errors.Is(err, hook.InvalidPayloadError):
+			writeJsonError(w, http.StatusBadRequest, err.Error())
+		default:
+			writeServiceError(w, err)
+		}
+	}
+}
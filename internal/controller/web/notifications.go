@@ -0,0 +1,34 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jrammler/wheelhouse/internal/controller/web/templates"
+	"github.com/jrammler/wheelhouse/internal/service"
+	"github.com/jrammler/wheelhouse/internal/service/notify"
+)
+
+func SetupNotificationMux(service *service.Service, mux *http.ServeMux) {
+	mux.HandleFunc("GET /notifications", handleNotificationsGet(service))
+}
+
+func handleNotificationsGet(service *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := GetUser(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		deliveries, err := service.NotificationService.GetDeliveries(r.Context(), user)
+		if errors.Is(err, notify.UnauthorizedError) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		templates.DeliveryList(deliveries).Render(r.Context(), w)
+	}
+}
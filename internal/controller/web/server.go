@@ -22,11 +22,17 @@ func NewServer(service *service.Service, addr string) *Server {
 
 	staticFs := http.FileServerFS(staticEmbed)
 	mux.Handle("/static/", staticFs)
+	mux.HandleFunc("GET "+apiPrefix+"/openapi.json", handleOpenApiGet)
+	SetupHookMux(service, mux)
 
 	authenticatedMux := SetupAuthentication(service, mux)
 	authenticatedMux.HandleFunc("GET /", handleIndexGet)
 
 	SetupCommandMux(service, authenticatedMux)
+	SetupApiMux(service, authenticatedMux)
+	SetupAuditMux(service, authenticatedMux)
+	SetupNotificationMux(service, authenticatedMux)
+	SetupApprovalMux(service, authenticatedMux)
 
 	return &Server{
 		service: service,
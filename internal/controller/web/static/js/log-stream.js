@@ -0,0 +1,48 @@
+// Streams the log of running executions from the server-sent events endpoint
+// referenced by the data-log-stream attribute. New log lines are inserted in
+// front of the element, which is removed once the execution finished.
+(function () {
+  const streams = new Map();
+
+  function connect(indicator) {
+    if (streams.has(indicator)) {
+      return;
+    }
+    const source = new EventSource(indicator.dataset.logStream);
+    streams.set(indicator, source);
+
+    source.addEventListener("log", (event) => {
+      indicator.insertAdjacentHTML("beforebegin", event.data);
+    });
+    source.addEventListener("exit", (event) => {
+      disconnect(indicator);
+      const state = document.getElementById("exitcode");
+      if (state) {
+        state.innerHTML = event.data;
+        htmx.process(state);
+      }
+      indicator.remove();
+    });
+  }
+
+  function disconnect(indicator) {
+    const source = streams.get(indicator);
+    if (source) {
+      source.close();
+      streams.delete(indicator);
+    }
+  }
+
+  htmx.onLoad((elt) => {
+    elt.querySelectorAll("[data-log-stream]").forEach(connect);
+  });
+
+  // boosted navigation replaces the page without unloading it
+  document.addEventListener("htmx:beforeSwap", (event) => {
+    for (const indicator of streams.keys()) {
+      if (event.detail.target.contains(indicator)) {
+        disconnect(indicator);
+      }
+    }
+  });
+})();
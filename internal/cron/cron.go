@@ -0,0 +1,229 @@
+// Package cron parses schedule expressions in the standard five field cron
+// format and computes when they are due next.
+package cron
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule computes the activation times of a schedule expression.
+type Schedule interface {
+	// Next returns the first activation time after t, in the location of t.
+	// The zero time is returned if there is none.
+	Next(t time.Time) time.Time
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a schedule expression. Supported are the five fields minute,
+// hour, day of month, month and day of week with lists, ranges, steps and
+// names, the descriptors like @daily, and intervals like "@every 1h30m".
+func Parse(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if interval, found := strings.CutPrefix(expr, "@every "); found {
+		d, err := time.ParseDuration(strings.TrimSpace(interval))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval: %w", err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("interval %s is shorter than a second", d)
+		}
+		return everySchedule(d), nil
+	}
+	if strings.HasPrefix(expr, "@") {
+		spec, ok := descriptors[expr]
+		if !ok {
+			return nil, fmt.Errorf("unknown descriptor %q", expr)
+		}
+		expr = spec
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+	var s cronSchedule
+	var err error
+	for i, f := range cronFields {
+		s.fields[i], err = f.parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s field %q: %w", f.name, fields[i], err)
+		}
+	}
+	// Sunday may be written as 0 or 7
+	if s.fields[dayOfWeek]&(1<<7) != 0 {
+		s.fields[dayOfWeek] |= 1
+	}
+	s.domStar = fields[dayOfMonth] == "*"
+	s.dowStar = fields[dayOfWeek] == "*"
+	return &s, nil
+}
+
+type everySchedule time.Duration
+
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Truncate(time.Second).Add(time.Duration(s))
+}
+
+const (
+	minute = iota
+	hour
+	dayOfMonth
+	month
+	dayOfWeek
+)
+
+type field struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var cronFields = [5]field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// parse returns the set of values matched by a field as a bit set.
+func (f field) parse(expr string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(expr, ",") {
+		rangeExpr, stepExpr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepExpr)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepExpr)
+			}
+		}
+
+		var lo, hi int
+		switch {
+		case rangeExpr == "*":
+			lo, hi = f.min, f.max
+			if f.name == "day of week" {
+				hi = 6
+			}
+		case strings.Contains(rangeExpr, "-"):
+			loExpr, hiExpr, _ := strings.Cut(rangeExpr, "-")
+			var err error
+			lo, err = f.value(loExpr)
+			if err != nil {
+				return 0, err
+			}
+			hi, err = f.value(hiExpr)
+			if err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q", rangeExpr)
+			}
+		default:
+			var err error
+			lo, err = f.value(rangeExpr)
+			if err != nil {
+				return 0, err
+			}
+			hi = lo
+			if hasStep {
+				hi = f.max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func (f field) value(expr string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(expr, name) {
+			return i + f.min, nil
+		}
+	}
+	v, err := strconv.Atoi(expr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", expr)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+type cronSchedule struct {
+	fields [5]uint64
+	// Like in cron, if both day fields are restricted a day matches if either
+	// of them matches.
+	domStar, dowStar bool
+}
+
+func (s *cronSchedule) has(f int, v int) bool {
+	return s.fields[f]&(1<<v) != 0
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dom := s.has(dayOfMonth, t.Day())
+	dow := s.has(dayOfWeek, int(t.Weekday()))
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// maxSearchYears bounds the search for expressions like "0 0 30 2 *" that
+// never match.
+const maxSearchYears = 5
+
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		if !s.has(month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.has(hour, t.Hour()) {
+			// adding the duration instead of using time.Date keeps working
+			// across DST transitions
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		if !s.has(minute, t.Minute()) {
+			// skip directly to the next matching minute of this hour
+			rest := s.fields[minute] >> (t.Minute() + 1)
+			if rest == 0 {
+				t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			} else {
+				t = t.Add(time.Duration(bits.TrailingZeros64(rest)+1) * time.Minute)
+			}
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
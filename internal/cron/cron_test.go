@@ -0,0 +1,78 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("LoadLocation failed: %q", err)
+	}
+	start := time.Date(2025, 1, 15, 10, 7, 30, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		expr     string
+		from     time.Time
+		expected time.Time
+	}{
+		{"Every minute", "* * * * *", start, time.Date(2025, 1, 15, 10, 8, 0, 0, time.UTC)},
+		{"Step", "*/15 * * * *", start, time.Date(2025, 1, 15, 10, 15, 0, 0, time.UTC)},
+		{"Next hour", "5 * * * *", start, time.Date(2025, 1, 15, 11, 5, 0, 0, time.UTC)},
+		{"Daily", "@daily", start, time.Date(2025, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{"List and range", "0 9-17/4 * * mon-fri", start, time.Date(2025, 1, 15, 13, 0, 0, 0, time.UTC)},
+		{"Weekend", "30 8 * * sat,sun", start, time.Date(2025, 1, 18, 8, 30, 0, 0, time.UTC)},
+		{"Sunday as 7", "0 0 * * 7", start, time.Date(2025, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{"Month name", "0 0 1 mar *", start, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"Day of month or week", "0 0 1 * fri", start, time.Date(2025, 1, 17, 0, 0, 0, 0, time.UTC)},
+		{"Leap day", "0 0 29 2 *", start, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"Every", "@every 90s", start, start.Add(90 * time.Second)},
+		{"Timezone", "0 3 * * *", start.In(berlin), time.Date(2025, 1, 16, 3, 0, 0, 0, berlin)},
+		{"DST gap", "30 2 * * *", time.Date(2025, 3, 29, 12, 0, 0, 0, berlin), time.Date(2025, 3, 31, 2, 30, 0, 0, berlin)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			schedule, err := Parse(tc.expr)
+			if err != nil {
+				t.Fatalf("Parse failed: %q", err)
+			}
+
+			// Act
+			next := schedule.Next(tc.from)
+
+			// Assert
+			if !next.Equal(tc.expected) {
+				t.Errorf("Expected %s, got %s", tc.expected, next)
+			}
+		})
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	// Arrange
+	schedule, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatalf("Parse failed: %q", err)
+	}
+
+	// Act
+	next := schedule.Next(time.Now())
+
+	// Assert
+	if !next.IsZero() {
+		t.Errorf("Expected no activation, got %s", next)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "* * * foo *", "@often", "@every 1ms"} {
+		_, err := Parse(expr)
+		if err == nil {
+			t.Errorf("Expected error for %q", expr)
+		}
+	}
+}
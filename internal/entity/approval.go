@@ -0,0 +1,35 @@
+package entity
+
+import "time"
+
+// Approval requires a second person to approve every execution of a command
+// before it starts.
+type Approval struct {
+	// Role is the role of the users who may approve executions.
+	Role string `json:"role"`
+	// Expiry is how long a request waits for a decision, it overrides the
+	// global approval_expiry.
+	Expiry *Duration `json:"expiry,omitempty"`
+}
+
+// ApprovalDecision is the outcome of an approval request.
+type ApprovalDecision string
+
+const (
+	ApprovalDecisionApproved ApprovalDecision = "approved"
+	ApprovalDecisionRejected ApprovalDecision = "rejected"
+	ApprovalDecisionExpired  ApprovalDecision = "expired"
+)
+
+// ExecutionApproval records the approval of an execution of a command that
+// requires one.
+type ExecutionApproval struct {
+	Role    string
+	Expires time.Time
+	// Decision is empty while the execution waits for a decision.
+	Decision ApprovalDecision
+	// DecidedBy is empty for expired requests.
+	DecidedBy    string
+	Comment      string
+	DecisionTime *time.Time
+}
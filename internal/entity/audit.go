@@ -0,0 +1,28 @@
+package entity
+
+import "time"
+
+type AuditEventType string
+
+const (
+	AuditEventLogin        AuditEventType = "login"
+	AuditEventLoginFailed  AuditEventType = "login_failed"
+	AuditEventLogout       AuditEventType = "logout"
+	AuditEventExecution    AuditEventType = "execution"
+	AuditEventCancellation AuditEventType = "cancellation"
+	AuditEventConfigReload AuditEventType = "config_reload"
+	AuditEventApproval     AuditEventType = "approval"
+)
+
+// AuditEvent is a record of the audit log. Each record contains the hash of
+// the previous one, so changing or removing records breaks the chain.
+type AuditEvent struct {
+	Seq      int               `json:"seq"`
+	Time     time.Time         `json:"time"`
+	Type     AuditEventType    `json:"type"`
+	User     string            `json:"user,omitempty"`
+	SourceIp string            `json:"source_ip,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+	PrevHash string            `json:"prev_hash"`
+	Hash     string            `json:"hash,omitempty"`
+}
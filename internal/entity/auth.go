@@ -1,7 +1,32 @@
 package entity
 
+import "time"
+
 type User struct {
 	Username     string   `json:"username"`
 	PasswordHash string   `json:"password_hash"`
 	Roles        []string `json:"roles"`
+	// Commands restricts the user to the commands with the given IDs. This is
+	// used for API tokens scoped to some commands, nil means no restriction.
+	Commands []string `json:"-"`
+	// System marks internal principals like the scheduler, which may execute
+	// every command.
+	System bool `json:"-"`
+}
+
+// ApiToken is a long-lived credential for non-interactive clients. Only the
+// hash of the secret part of the token is stored.
+type ApiToken struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Hash     string `json:"hash"`
+	// Roles limits the roles of the user the token grants, nil means all roles.
+	// An empty list is kept as one, so it grants no roles.
+	Roles []string `json:"roles"`
+	// Commands limits the commands the token grants access to, nil means all
+	// commands.
+	Commands []string   `json:"commands"`
+	Created  time.Time  `json:"created"`
+	Expires  *time.Time `json:"expires,omitempty"`
 }
@@ -2,29 +2,229 @@ package entity
 
 import "time"
 
+type ParameterType string
+
+const (
+	ParameterTypeString ParameterType = "string"
+	ParameterTypeInt    ParameterType = "int"
+	ParameterTypeChoice ParameterType = "choice"
+	ParameterTypeBool   ParameterType = "bool"
+	ParameterTypeSecret ParameterType = "secret"
+)
+
+type CommandParameter struct {
+	Name    string        `json:"name"`
+	Type    ParameterType `json:"type,omitempty"`
+	Default *string       `json:"default,omitempty"`
+	Pattern string        `json:"pattern,omitempty"`
+	Choices []string      `json:"choices,omitempty"`
+	Help    string        `json:"help,omitempty"`
+}
+
 type Command struct {
-	Name    string  `json:"name"`
-	Command string  `json:"command"`
-	Id      string  `json:"-"`
-	Role    *string `json:"role,omitempty"`
+	Name       string             `json:"name"`
+	Command    string             `json:"command"`
+	Id         string             `json:"id,omitempty"`
+	Role       *string            `json:"role,omitempty"`
+	Parameters []CommandParameter `json:"parameters,omitempty"`
+	Timeout    *Duration          `json:"timeout,omitempty"`
+	// Schedule is a cron expression the command is executed on automatically.
+	Schedule string `json:"schedule,omitempty"`
+	// Timezone is the IANA name of the timezone the schedule is evaluated in,
+	// the local timezone if empty.
+	Timezone    string            `json:"timezone,omitempty"`
+	Overlap     OverlapPolicy     `json:"overlap,omitempty"`
+	Concurrency ConcurrencyPolicy `json:"concurrency,omitempty"`
+	// Env is added to the environment of the command, overriding the global
+	// env.
+	Env map[string]string `json:"env,omitempty"`
+	// InheritEnv lists the variables of the server environment the command
+	// gets. Nil falls back to the global setting.
+	InheritEnv []string `json:"inherit_env,omitempty"`
+	Workdir    string   `json:"workdir,omitempty"`
+	RunAs      *RunAs   `json:"run_as,omitempty"`
+	Limits     *Limits  `json:"limits,omitempty"`
+	// Tty runs the command with a pseudo-terminal as output.
+	Tty bool `json:"tty,omitempty"`
+	// Confirm makes the web interface ask before executing the command.
+	Confirm ConfirmPolicy `json:"confirm,omitempty"`
+	// RequiresApproval makes executions wait until another user approved
+	// them.
+	RequiresApproval *Approval `json:"requires_approval,omitempty"`
+	// Steps make the command a workflow, which executes other commands
+	// instead of Command.
+	Steps []WorkflowStep `json:"steps,omitempty"`
 }
 
+// IsWorkflow reports whether the command executes other commands as steps.
+func (c *Command) IsWorkflow() bool {
+	return len(c.Steps) > 0
+}
+
+// ConfirmPolicy decides how users confirm an execution in the web interface.
+type ConfirmPolicy string
+
+const (
+	// ConfirmPolicyDialog asks the user to confirm the execution.
+	ConfirmPolicyDialog ConfirmPolicy = "dialog"
+	// ConfirmPolicyName requires the user to type the name of the command.
+	ConfirmPolicyName ConfirmPolicy = "name"
+)
+
+// RunAs is the user and groups a command is executed as, given as names or
+// numeric IDs.
+type RunAs struct {
+	User string `json:"user"`
+	// Group is the primary group, the one of the user if empty.
+	Group string `json:"group,omitempty"`
+	// Groups are the supplementary groups, the ones of the user if nil.
+	Groups []string `json:"groups,omitempty"`
+}
+
+// ConcurrencyPolicy decides what happens when a command is executed while
+// another execution of it is running.
+type ConcurrencyPolicy string
+
+const (
+	ConcurrencyPolicyAllow  ConcurrencyPolicy = "allow"
+	ConcurrencyPolicyReject ConcurrencyPolicy = "reject"
+	ConcurrencyPolicyQueue  ConcurrencyPolicy = "queue"
+)
+
 type LogEntry struct {
 	Stream string
 	Data   string
+	// Time is when the line was read, it is zero for entries recorded by
+	// older versions.
+	Time time.Time
+}
+
+type ExecutionStatus string
+
+const (
+	// ExecutionStatusQueued marks executions waiting for a concurrency limit.
+	ExecutionStatusQueued    ExecutionStatus = "queued"
+	ExecutionStatusCancelled ExecutionStatus = "cancelled"
+	ExecutionStatusTimedOut  ExecutionStatus = "timed out"
+	// ExecutionStatusInterrupted marks executions that were running when the
+	// server stopped.
+	ExecutionStatusInterrupted ExecutionStatus = "interrupted"
+	// ExecutionStatusPendingApproval marks executions waiting for an
+	// approver, rejected and expired ones did not start.
+	ExecutionStatusPendingApproval ExecutionStatus = "pending approval"
+	ExecutionStatusRejected        ExecutionStatus = "rejected"
+	ExecutionStatusExpired         ExecutionStatus = "expired"
+)
+
+// ExecutionState describes the state of an execution for display.
+func ExecutionState(exitCode *int, status ExecutionStatus) string {
+	if status != "" {
+		return string(status)
+	}
+	if exitCode == nil {
+		return "running"
+	}
+	if *exitCode == 0 {
+		return "finished"
+	}
+	return "error"
+}
+
+type ParameterValue struct {
+	Name  string
+	Value string
+}
+
+// TriggerType tells how an execution was requested.
+type TriggerType string
+
+const (
+	TriggerTypeWeb      TriggerType = "web"
+	TriggerTypeApi      TriggerType = "api"
+	TriggerTypeSchedule TriggerType = "schedule"
+	TriggerTypeWebhook  TriggerType = "webhook"
+)
+
+// Trigger describes the request that started an execution.
+type Trigger struct {
+	Type TriggerType
+	// Source is the name of the webhook trigger for webhook executions.
+	Source    string
+	SourceIp  string
+	UserAgent string
 }
 
 type CommandExecution struct {
 	ExecId    int
 	CommandId string
-	ExecTime  time.Time
-	ExitCode  *int
-	Log       []LogEntry
+	// CommandName, CommandText and CommandRole record the command as it was
+	// when it was executed, as it might be changed or removed later.
+	CommandName string
+	CommandText string
+	CommandRole *string
+	User        string
+	Trigger     Trigger
+	ExecTime    time.Time
+	// StartTime is when the process was started, which is later than
+	// ExecTime for queued executions. EndTime is when the execution
+	// finished.
+	StartTime   *time.Time
+	EndTime     *time.Time
+	ExitCode    *int
+	Status      ExecutionStatus
+	CancelledBy *string
+	Usage       ResourceUsage
+	Parameters  []ParameterValue
+	Log         []LogEntry
+	// ParentId is the workflow execution the execution is a step of.
+	ParentId *int
+	// Approval is set for executions of commands that require approval.
+	Approval *ExecutionApproval
+}
+
+// Duration returns how long the process of the execution ran, false if it did
+// not start or has not finished yet.
+func (e *CommandExecution) Duration() (time.Duration, bool) {
+	if e.StartTime == nil || e.EndTime == nil {
+		return 0, false
+	}
+	return e.EndTime.Sub(*e.StartTime), true
+}
+
+// ExecutionEvent is sent to subscribers of an execution for every log entry
+// and once more when the execution finished.
+type ExecutionEvent struct {
+	// Index is the position of Log in the execution log
+	Index int
+	Log   *LogEntry
+	// StartTime is when the process of the execution started, nil on events
+	// sent before it did
+	StartTime *time.Time
+	// Execution is only set on the final event
+	Execution *CommandExecution
 }
 
 type ExecutionHistoryEntry struct {
 	ExecId      int
 	Time        time.Time
 	CommandName string
+	User        string
+	Trigger     TriggerType
 	ExitCode    *int
+	Status      ExecutionStatus
+	// Duration is nil if the execution did not start or has not finished.
+	Duration *time.Duration
+	ParentId *int
+}
+
+// ExecutionFilter restricts the execution history, empty fields match all
+// executions.
+type ExecutionFilter struct {
+	User string
+	// ParentId restricts the history to the steps of a workflow execution.
+	ParentId *int
+	// Offset skips the most recent matching executions and Limit returns at
+	// most that many of the following ones, zero means no limit.
+	Offset int
+	Limit  int
 }
@@ -0,0 +1,80 @@
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ByteSize is a number of bytes that is written as a string like "512M" in
+// the config file. The suffixes K, M, G and T are powers of 1024.
+type ByteSize int64
+
+var byteSizeUnits = []string{"K", "M", "G", "T"}
+
+func ParseByteSize(str string) (ByteSize, error) {
+	num := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(str)), "B")
+	num = strings.TrimSuffix(num, "I")
+	multiplier := int64(1)
+	for i, unit := range byteSizeUnits {
+		if rest, found := strings.CutSuffix(num, unit); found {
+			num = rest
+			multiplier = int64(1) << (10 * (i + 1))
+			break
+		}
+	}
+	value, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", str)
+	}
+	return ByteSize(value * multiplier), nil
+}
+
+func (b ByteSize) String() string {
+	value := int64(b)
+	for i := len(byteSizeUnits); i > 0; i-- {
+		size := int64(1) << (10 * i)
+		if value >= size && value%size == 0 {
+			return fmt.Sprintf("%d%s", value/size, byteSizeUnits[i-1])
+		}
+	}
+	return strconv.FormatInt(value, 10)
+}
+
+func (b ByteSize) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.String())
+}
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var str string
+	err := json.Unmarshal(data, &str)
+	if err != nil {
+		return err
+	}
+	size, err := ParseByteSize(str)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+// Limits restricts the resources an execution may use, zero values mean no
+// limit.
+type Limits struct {
+	Memory ByteSize `json:"memory,omitempty"`
+	// Cpu is the number of CPUs the execution may use, e.g. 0.5 for half of
+	// one CPU.
+	Cpu    float64 `json:"cpu,omitempty"`
+	Pids   int     `json:"pids,omitempty"`
+	NoFile int     `json:"nofile,omitempty"`
+}
+
+// ResourceUsage describes the resources an execution used, fields are nil if
+// they could not be measured.
+type ResourceUsage struct {
+	PeakMemory *int64
+	CpuTime    *time.Duration
+}
@@ -0,0 +1,86 @@
+package entity
+
+import "time"
+
+// NotificationEvent is a change of an execution that webhooks are sent for.
+type NotificationEvent string
+
+const (
+	NotificationEventStarted   NotificationEvent = "started"
+	NotificationEventSucceeded NotificationEvent = "succeeded"
+	NotificationEventFailed    NotificationEvent = "failed"
+	NotificationEventTimedOut  NotificationEvent = "timed_out"
+	NotificationEventCancelled NotificationEvent = "cancelled"
+)
+
+// Webhook is a URL that is notified about executions with a POST request.
+type Webhook struct {
+	Name string `json:"name"`
+	Url  string `json:"url"`
+	// Events, Commands and Roles restrict the notifications to the given
+	// events, command IDs and command roles, empty lists match everything.
+	Events   []NotificationEvent `json:"events"`
+	Commands []string            `json:"commands"`
+	Roles    []string            `json:"roles"`
+	Headers  map[string]string   `json:"headers"`
+	// Secret is the key the body is signed with using HMAC-SHA256.
+	Secret string `json:"secret"`
+	// LogLines is the number of lines at the end of the log included in the
+	// notification.
+	LogLines *int `json:"log_lines"`
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryStatusPending   DeliveryStatus = "pending"
+	DeliveryStatusDelivered DeliveryStatus = "delivered"
+	DeliveryStatusFailed    DeliveryStatus = "failed"
+)
+
+// WebhookDelivery records the attempts to send a notification to a webhook.
+type WebhookDelivery struct {
+	Id      int
+	Webhook string
+	Event   NotificationEvent
+	ExecId  int
+	Time    time.Time
+	Status  DeliveryStatus
+	// Attempts is the number of requests sent so far.
+	Attempts int
+	// ResponseCode is the HTTP status of the last response, zero if there was
+	// none.
+	ResponseCode int
+	Error        string
+}
+
+// WebhookTrigger is an endpoint at /hooks/<name> that executes a command when
+// it receives a signed request.
+type WebhookTrigger struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+	// Secret is the key requests are signed with using HMAC-SHA256.
+	Secret string `json:"secret"`
+	// Parameters maps parameter names to the fields of the JSON payload their
+	// values are taken from. Nested fields and array elements are separated
+	// by dots like "commits.0.id".
+	Parameters map[string]string `json:"parameters"`
+	// GithubSignature accepts GitHub style signatures, which only cover the
+	// body. Without a signed timestamp, replays of such requests can not be
+	// rejected reliably.
+	GithubSignature bool `json:"github_signature"`
+}
+
+// WebhookRequest is a request received by a webhook trigger.
+type WebhookRequest struct {
+	Body []byte
+	// HubSignature is the GitHub style signature of the body.
+	HubSignature string
+	// Signature is the signature of the timestamp and the body.
+	Signature string
+	Timestamp string
+	// Delivery is the ID the sender gave the request, it is not signed.
+	Delivery  string
+	SourceIp  string
+	UserAgent string
+}
@@ -0,0 +1,21 @@
+package entity
+
+import "time"
+
+// OverlapPolicy decides what happens when a scheduled run is due while the
+// previous one is still running.
+type OverlapPolicy string
+
+const (
+	OverlapPolicySkip  OverlapPolicy = "skip"
+	OverlapPolicyQueue OverlapPolicy = "queue"
+)
+
+type CommandSchedule struct {
+	CommandId string
+	NextRun   *time.Time
+	LastRun   *time.Time
+	// LastExecId is the execution started by the last run, if it could be
+	// started.
+	LastExecId *int
+}
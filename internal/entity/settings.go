@@ -0,0 +1,78 @@
+package entity
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// Duration is a time.Duration that is written as a string like "1m30s" in the
+// config file.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var str string
+	err := json.Unmarshal(data, &str)
+	if err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+type Settings struct {
+	CancelGracePeriod Duration `json:"cancel_grace_period"`
+	DefaultTimeout    Duration `json:"default_timeout"`
+	HistoryDatabase   string   `json:"history_database"`
+	TokensFile        string   `json:"tokens_file"`
+	AuditLog          string   `json:"audit_log"`
+	// MaxParallelExecutions limits the number of executions running at the
+	// same time, zero means no limit.
+	MaxParallelExecutions int `json:"max_parallel_executions"`
+	// AdminRole is the role that grants access to administrative pages like
+	// the audit log.
+	AdminRole string `json:"admin_role"`
+	// Env, InheritEnv and Workdir are the defaults for commands that do not
+	// set their own. If InheritEnv is nil, the whole server environment is
+	// inherited.
+	Env        map[string]string `json:"env"`
+	InheritEnv []string          `json:"inherit_env"`
+	Workdir    string            `json:"workdir"`
+	// CgroupParent is the cgroup v2 directory the cgroups of executions are
+	// created in. Without it, limits are applied with setrlimit.
+	CgroupParent string `json:"cgroup_parent"`
+	// LogDir is the directory the complete output of executions is written
+	// to. LogMaxSize limits the size of a single log file and LogRetention
+	// how long the files are kept, zero means no limit.
+	LogDir       string   `json:"log_dir"`
+	LogMaxSize   ByteSize `json:"log_max_size"`
+	LogRetention Duration `json:"log_retention"`
+	// LogCompress compresses the log files of finished executions with gzip.
+	LogCompress bool `json:"log_compress"`
+	// Webhooks are notified about executions.
+	Webhooks []Webhook `json:"webhooks"`
+	// Triggers are the webhooks that execute commands.
+	Triggers []WebhookTrigger `json:"triggers"`
+	// ApprovalExpiry is how long requests for approval wait for a decision
+	// by default.
+	ApprovalExpiry Duration `json:"approval_expiry"`
+}
+
+const DefaultAdminRole = "admin"
+
+// IsAdmin reports whether the user has the admin role.
+func (s Settings) IsAdmin(user User) bool {
+	role := s.AdminRole
+	if role == "" {
+		role = DefaultAdminRole
+	}
+	return slices.Contains(user.Roles, role)
+}
@@ -0,0 +1,35 @@
+package entity
+
+// FailurePolicy decides how a workflow continues after one of its steps
+// failed.
+type FailurePolicy string
+
+const (
+	FailurePolicyStop     FailurePolicy = "stop"
+	FailurePolicyContinue FailurePolicy = "continue"
+	// FailurePolicyCleanup executes the cleanup command of the step and stops
+	// the workflow afterwards.
+	FailurePolicyCleanup FailurePolicy = "cleanup"
+)
+
+// WorkflowStep executes a command, or several commands at the same time, as
+// part of a workflow.
+type WorkflowStep struct {
+	// Command is the ID of the command executed by the step.
+	Command string `json:"command,omitempty"`
+	// Parallel holds the IDs of the commands executed at the same time
+	// instead of Command. The step fails if one of them fails.
+	Parallel  []string      `json:"parallel,omitempty"`
+	OnFailure FailurePolicy `json:"on_failure,omitempty"`
+	// Cleanup is the ID of the command executed if the step failed and
+	// OnFailure is FailurePolicyCleanup.
+	Cleanup string `json:"cleanup,omitempty"`
+}
+
+// Commands returns the IDs of the commands executed by the step.
+func (s WorkflowStep) Commands() []string {
+	if s.Command != "" {
+		return []string{s.Command}
+	}
+	return s.Parallel
+}
@@ -0,0 +1,48 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+	"github.com/jrammler/wheelhouse/internal/storage"
+)
+
+var UnauthorizedError = errors.New("User is not authorized to view the audit log")
+
+type AuditService struct {
+	storage storage.Storage
+	log     storage.AuditLog
+}
+
+func NewAuditService(sto storage.Storage, log storage.AuditLog) *AuditService {
+	return &AuditService{
+		storage: sto,
+		log:     log,
+	}
+}
+
+// Record appends an event to the audit log. Failures are logged, but do not
+// stop the audited action.
+func (s *AuditService) Record(ctx context.Context, event entity.AuditEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	err := s.log.Append(ctx, &event)
+	if err != nil {
+		slog.Error("Failed to write audit event", "type", event.Type, "error", err)
+	}
+}
+
+func (s *AuditService) GetEvents(ctx context.Context, user entity.User) ([]entity.AuditEvent, error) {
+	settings, err := s.storage.GetSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !settings.IsAdmin(user) {
+		return nil, UnauthorizedError
+	}
+	return s.log.GetEvents(ctx)
+}
@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+)
+
+type mockStorage struct {
+	settings entity.Settings
+}
+
+func (m *mockStorage) GetCommands(ctx context.Context) ([]entity.Command, error) {
+	return nil, errors.New("not supported")
+}
+
+func (m *mockStorage) GetCommandById(ctx context.Context, id string) (*entity.Command, error) {
+	return nil, errors.New("not supported")
+}
+
+func (m *mockStorage) GetUser(ctx context.Context, username string) (entity.User, error) {
+	return entity.User{}, errors.New("not supported")
+}
+
+func (m *mockStorage) GetSettings(ctx context.Context) (entity.Settings, error) {
+	return m.settings, nil
+}
+
+func (m *mockStorage) LoadConfig() error {
+	return nil
+}
+
+type mockAuditLog struct {
+	events []entity.AuditEvent
+}
+
+func (m *mockAuditLog) Append(ctx context.Context, event *entity.AuditEvent) error {
+	event.Seq = len(m.events) + 1
+	m.events = append(m.events, *event)
+	return nil
+}
+
+func (m *mockAuditLog) GetEvents(ctx context.Context) ([]entity.AuditEvent, error) {
+	return m.events, nil
+}
+
+func (m *mockAuditLog) Close() error {
+	return nil
+}
+
+func TestRecord(t *testing.T) {
+	// Arrange
+	log := &mockAuditLog{}
+	auditService := NewAuditService(&mockStorage{}, log)
+
+	// Act
+	auditService.Record(context.Background(), entity.AuditEvent{Type: entity.AuditEventLogin, User: "alice"})
+
+	// Assert
+	if len(log.events) != 1 || log.events[0].User != "alice" {
+		t.Fatalf("Expected event to be appended, got %v", log.events)
+	}
+	if log.events[0].Time.IsZero() {
+		t.Errorf("Expected time to be set")
+	}
+}
+
+func TestGetEvents(t *testing.T) {
+	testCases := []struct {
+		name      string
+		adminRole string
+		user      entity.User
+		expected  error
+	}{
+		{"admin", "", entity.User{Username: "alice", Roles: []string{"admin"}}, nil},
+		{"custom admin role", "ops", entity.User{Username: "alice", Roles: []string{"ops"}}, nil},
+		{"not admin", "ops", entity.User{Username: "bob", Roles: []string{"admin"}}, UnauthorizedError},
+		{"no roles", "", entity.User{Username: "bob"}, UnauthorizedError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			log := &mockAuditLog{events: []entity.AuditEvent{{Seq: 1, Type: entity.AuditEventLogin}}}
+			auditService := NewAuditService(&mockStorage{settings: entity.Settings{AdminRole: tc.adminRole}}, log)
+
+			// Act
+			events, err := auditService.GetEvents(context.Background(), tc.user)
+
+			// Assert
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("Expected error %v, got %v", tc.expected, err)
+			}
+			if err == nil && len(events) != 1 {
+				t.Errorf("Expected events to be returned, got %v", events)
+			}
+		})
+	}
+}
@@ -18,12 +18,14 @@ var NoValidSessionError = errors.New("No valid session with provided Token")
 
 type AuthService struct {
 	storage  storage.Storage
+	tokens   storage.TokenStore
 	sessions map[string]session
 }
 
-func NewAuthService(storage storage.Storage) *AuthService {
+func NewAuthService(storage storage.Storage, tokens storage.TokenStore) *AuthService {
 	return &AuthService{
 		storage:  storage,
+		tokens:   tokens,
 		sessions: make(map[string]session),
 	}
 }
@@ -31,6 +33,8 @@ func NewAuthService(storage storage.Storage) *AuthService {
 type session struct {
 	user       entity.User
 	expiration time.Time
+	// csrfToken has to be sent with state-changing requests of the session.
+	csrfToken string
 }
 
 func (s session) isExpired() bool {
@@ -69,10 +73,15 @@ func (s *AuthService) LoginUser(ctx context.Context, username, password string)
 	if err != nil {
 		return "", nil, TokenGenerationError
 	}
+	csrfToken, err := generateSessionToken()
+	if err != nil {
+		return "", nil, TokenGenerationError
+	}
 	expiration := time.Now().Add(24 * time.Hour)
 	s.sessions[sessionToken] = session{
 		user:       user,
 		expiration: expiration,
+		csrfToken:  csrfToken,
 	}
 	return sessionToken, &expiration, nil
 }
@@ -81,14 +90,24 @@ func (s *AuthService) LogoutUser(ctx context.Context, sessionToken string) {
 	delete(s.sessions, sessionToken)
 }
 
-func (s *AuthService) GetSessionUser(ctx context.Context, sessionToken string) (user entity.User, err error) {
+// GetSessionUser returns the user of a session and the token that protects
+// the session against cross-site request forgery.
+func (s *AuthService) GetSessionUser(ctx context.Context, sessionToken string) (user entity.User, csrfToken string, err error) {
+	session, err := s.getSession(sessionToken)
+	if err != nil {
+		return entity.User{}, "", err
+	}
+	return session.user, session.csrfToken, nil
+}
+
+func (s *AuthService) getSession(sessionToken string) (session, error) {
 	session, exists := s.sessions[sessionToken]
 	if !exists {
-		return entity.User{}, NoValidSessionError
+		return session, NoValidSessionError
 	}
 	if session.isExpired() {
 		delete(s.sessions, sessionToken)
-		return entity.User{}, NoValidSessionError
+		return session, NoValidSessionError
 	}
-	return session.user, nil
+	return session, nil
 }
@@ -29,6 +29,10 @@ func (m *mockStorage) GetUser(ctx context.Context, username string) (entity.User
 	return m.user, nil
 }
 
+func (m *mockStorage) GetSettings(ctx context.Context) (entity.Settings, error) {
+	return entity.Settings{}, nil
+}
+
 func (m *mockStorage) LoadConfig() error {
 	return nil
 }
@@ -78,7 +82,7 @@ func TestLoginUser(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			authService := NewAuthService(&tc.storage)
+			authService := NewAuthService(&tc.storage, nil)
 			_, _, err := authService.LoginUser(context.Background(), tc.username, tc.password)
 
 			if tc.expectedError != nil {
@@ -95,7 +99,7 @@ func TestLoginUser(t *testing.T) {
 }
 
 func TestLogoutUser(t *testing.T) {
-	authService := NewAuthService(&mockStorage{})
+	authService := NewAuthService(&mockStorage{}, nil)
 	token, err := generateSessionToken()
 	if err != nil {
 		t.Errorf("Unexpected error %q", err)
@@ -155,9 +159,9 @@ func TestGetSessionUser(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			authService := NewAuthService(&mockStorage{})
+			authService := NewAuthService(&mockStorage{}, nil)
 			tc.setup(authService, tc.sessionToken)
-			user, err := authService.GetSessionUser(context.Background(), tc.sessionToken)
+			user, _, err := authService.GetSessionUser(context.Background(), tc.sessionToken)
 
 			if user.Username != tc.expectedUser.Username {
 				t.Errorf("Expected username %q but got %q", tc.expectedUser.Username, user.Username)
@@ -168,3 +172,41 @@ func TestGetSessionUser(t *testing.T) {
 		})
 	}
 }
+
+func TestGetSessionCsrfToken(t *testing.T) {
+	// Arrange
+	authService := NewAuthService(&mockStorage{user: entity.User{
+		Username:     "testuser",
+		PasswordHash: "$2a$04$dKD7Ty3vN6sYhWyRxDKepOOsjbJ2HtU/Q0Dw7wt.5Q2cqCXJEi/Wa", // "password"
+	}}, nil)
+	first, _, err := authService.LoginUser(context.Background(), "testuser", "password")
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	second, _, err := authService.LoginUser(context.Background(), "testuser", "password")
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+
+	// Act
+	_, firstToken, err := authService.GetSessionUser(context.Background(), first)
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	_, secondToken, err := authService.GetSessionUser(context.Background(), second)
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	_, _, invalidErr := authService.GetSessionUser(context.Background(), "invalid_token")
+
+	// Assert
+	if firstToken == "" || firstToken == secondToken {
+		t.Errorf("Expected a distinct token per session, got %q and %q", firstToken, secondToken)
+	}
+	if firstToken == first {
+		t.Errorf("Expected the token to differ from the session token")
+	}
+	if !errors.Is(invalidErr, NoValidSessionError) {
+		t.Errorf("Expected %q, got %q", NoValidSessionError, invalidErr)
+	}
+}
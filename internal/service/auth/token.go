@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+)
+
+var InvalidTokenError = errors.New("Invalid or expired API token")
+var TokensNotConfiguredError = errors.New("API tokens are not configured")
+
+// API tokens have the form wh_<id>_<secret>. The ID identifies the token for
+// listing and revoking it, only the hash of the secret is stored.
+const tokenPrefix = "wh_"
+
+func hashTokenSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func parseToken(token string) (id string, secret string, ok bool) {
+	rest, found := strings.CutPrefix(token, tokenPrefix)
+	if !found {
+		return "", "", false
+	}
+	return strings.Cut(rest, "_")
+}
+
+// CreateToken creates an API token for the user. If roles or commands are not
+// nil, the token only grants the given subset of the user's roles or access to
+// the given commands. A validity of zero creates a token that never expires.
+// The returned token string can not be retrieved again.
+func (s *AuthService) CreateToken(ctx context.Context, username string, name string, roles []string, commands []string, validity time.Duration) (string, entity.ApiToken, error) {
+	if s.tokens == nil {
+		return "", entity.ApiToken{}, TokensNotConfiguredError
+	}
+	user, err := s.storage.GetUser(ctx, username)
+	if err != nil {
+		return "", entity.ApiToken{}, err
+	}
+	for _, role := range roles {
+		if !slices.Contains(user.Roles, role) {
+			return "", entity.ApiToken{}, fmt.Errorf("user %q does not have role %q", username, role)
+		}
+	}
+	for _, commandId := range commands {
+		command, err := s.storage.GetCommandById(ctx, commandId)
+		if err != nil {
+			return "", entity.ApiToken{}, err
+		}
+		if command == nil {
+			return "", entity.ApiToken{}, fmt.Errorf("command %q not found", commandId)
+		}
+	}
+
+	idBytes := make([]byte, 8)
+	_, err = rand.Read(idBytes)
+	if err != nil {
+		return "", entity.ApiToken{}, TokenGenerationError
+	}
+	secretBytes := make([]byte, 32)
+	_, err = rand.Read(secretBytes)
+	if err != nil {
+		return "", entity.ApiToken{}, TokenGenerationError
+	}
+	id := hex.EncodeToString(idBytes)
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	token := entity.ApiToken{
+		Id:       id,
+		Name:     name,
+		Username: username,
+		Hash:     hashTokenSecret(secret),
+		Roles:    roles,
+		Commands: commands,
+		Created:  time.Now(),
+	}
+	if validity > 0 {
+		expires := token.Created.Add(validity)
+		token.Expires = &expires
+	}
+	err = s.tokens.AddToken(ctx, token)
+	if err != nil {
+		return "", entity.ApiToken{}, err
+	}
+	return tokenPrefix + id + "_" + secret, token, nil
+}
+
+func (s *AuthService) ListTokens(ctx context.Context) ([]entity.ApiToken, error) {
+	if s.tokens == nil {
+		return nil, TokensNotConfiguredError
+	}
+	return s.tokens.GetTokens(ctx)
+}
+
+func (s *AuthService) RevokeToken(ctx context.Context, id string) error {
+	if s.tokens == nil {
+		return TokensNotConfiguredError
+	}
+	return s.tokens.RemoveToken(ctx, id)
+}
+
+// GetTokenUser returns the user an API token belongs to, restricted to the
+// scope of the token.
+func (s *AuthService) GetTokenUser(ctx context.Context, tokenString string) (entity.User, error) {
+	if s.tokens == nil {
+		return entity.User{}, InvalidTokenError
+	}
+	id, secret, ok := parseToken(tokenString)
+	if !ok {
+		return entity.User{}, InvalidTokenError
+	}
+	tokens, err := s.tokens.GetTokens(ctx)
+	if err != nil {
+		return entity.User{}, err
+	}
+	idx := slices.IndexFunc(tokens, func(t entity.ApiToken) bool { return t.Id == id })
+	if idx < 0 {
+		return entity.User{}, InvalidTokenError
+	}
+	token := tokens[idx]
+	if subtle.ConstantTimeCompare([]byte(hashTokenSecret(secret)), []byte(token.Hash)) != 1 {
+		return entity.User{}, InvalidTokenError
+	}
+	if token.Expires != nil && token.Expires.Before(time.Now()) {
+		return entity.User{}, InvalidTokenError
+	}
+
+	user, err := s.storage.GetUser(ctx, token.Username)
+	if err != nil {
+		return entity.User{}, InvalidTokenError
+	}
+	if token.Roles != nil {
+		user.Roles = slices.DeleteFunc(slices.Clone(user.Roles), func(role string) bool {
+			return !slices.Contains(token.Roles, role)
+		})
+	}
+	user.Commands = token.Commands
+	return user, nil
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+	"github.com/jrammler/wheelhouse/internal/storage"
+)
+
+type mockTokenStore struct {
+	tokens []entity.ApiToken
+}
+
+func (m *mockTokenStore) GetTokens(ctx context.Context) ([]entity.ApiToken, error) {
+	return m.tokens, nil
+}
+
+func (m *mockTokenStore) AddToken(ctx context.Context, token entity.ApiToken) error {
+	m.tokens = append(m.tokens, token)
+	return nil
+}
+
+func (m *mockTokenStore) RemoveToken(ctx context.Context, id string) error {
+	idx := slices.IndexFunc(m.tokens, func(t entity.ApiToken) bool { return t.Id == id })
+	if idx < 0 {
+		return storage.TokenNotFoundError
+	}
+	m.tokens = slices.Delete(m.tokens, idx, idx+1)
+	return nil
+}
+
+var tokenUser = entity.User{Username: "ci", Roles: []string{"deploy", "admin"}}
+
+func TestCreateToken(t *testing.T) {
+	// Arrange
+	tokens := &mockTokenStore{}
+	authService := NewAuthService(&mockStorage{user: tokenUser}, tokens)
+
+	// Act
+	token, apiToken, err := authService.CreateToken(context.Background(), "ci", "pipeline", []string{"deploy"}, nil, time.Hour)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	if len(tokens.tokens) != 1 || tokens.tokens[0].Id != apiToken.Id {
+		t.Fatalf("Expected token to be stored, got %v", tokens.tokens)
+	}
+	if apiToken.Hash == "" || apiToken.Hash == token {
+		t.Errorf("Expected only the hash of the token to be stored")
+	}
+	if apiToken.Expires == nil {
+		t.Errorf("Expected token to expire")
+	}
+
+	user, err := authService.GetTokenUser(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	if user.Username != "ci" || !slices.Equal(user.Roles, []string{"deploy"}) {
+		t.Errorf("Expected user ci limited to role deploy, got %v", user)
+	}
+
+	_, _, err = authService.CreateToken(context.Background(), "ci", "", []string{"root"}, nil, 0)
+	if err == nil {
+		t.Errorf("Expected error for role the user does not have")
+	}
+}
+
+func TestCreateTokenEmptyScopes(t *testing.T) {
+	// Arrange
+	tokens := storage.NewJsonTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	authService := NewAuthService(&mockStorage{user: tokenUser}, tokens)
+
+	// Act
+	token, _, err := authService.CreateToken(context.Background(), "ci", "", []string{}, []string{}, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	user, err := authService.GetTokenUser(context.Background(), token)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	if len(user.Roles) != 0 || user.Commands == nil || len(user.Commands) != 0 {
+		t.Errorf("Expected the stored token to grant no roles and commands, got %v", user)
+	}
+}
+
+func TestGetTokenUser(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	secret := "secret"
+	testCases := []struct {
+		name          string
+		token         string
+		stored        entity.ApiToken
+		expectedUser  entity.User
+		expectedError error
+	}{
+		{
+			name:         "Valid token",
+			token:        "wh_1_secret",
+			stored:       entity.ApiToken{Id: "1", Username: "ci", Hash: hashTokenSecret(secret)},
+			expectedUser: tokenUser,
+		},
+		{
+			name:  "Scoped token",
+			token: "wh_1_secret",
+			stored: entity.ApiToken{
+				Id: "1", Username: "ci", Hash: hashTokenSecret(secret),
+				Roles: []string{"admin"}, Commands: []string{"abc"},
+			},
+			expectedUser: entity.User{Username: "ci", Roles: []string{"admin"}, Commands: []string{"abc"}},
+		},
+		{
+			name:          "Wrong secret",
+			token:         "wh_1_other",
+			stored:        entity.ApiToken{Id: "1", Username: "ci", Hash: hashTokenSecret(secret)},
+			expectedError: InvalidTokenError,
+		},
+		{
+			name:          "Unknown token",
+			token:         "wh_2_secret",
+			stored:        entity.ApiToken{Id: "1", Username: "ci", Hash: hashTokenSecret(secret)},
+			expectedError: InvalidTokenError,
+		},
+		{
+			name:          "Malformed token",
+			token:         "secret",
+			stored:        entity.ApiToken{Id: "1", Username: "ci", Hash: hashTokenSecret(secret)},
+			expectedError: InvalidTokenError,
+		},
+		{
+			name:          "Expired token",
+			token:         "wh_1_secret",
+			stored:        entity.ApiToken{Id: "1", Username: "ci", Hash: hashTokenSecret(secret), Expires: &past},
+			expectedError: InvalidTokenError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens := &mockTokenStore{tokens: []entity.ApiToken{tc.stored}}
+			authService := NewAuthService(&mockStorage{user: tokenUser}, tokens)
+
+			user, err := authService.GetTokenUser(context.Background(), tc.token)
+
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("Unexpected error %q, expected %q", err, tc.expectedError)
+			}
+			if user.Username != tc.expectedUser.Username ||
+				!slices.Equal(user.Roles, tc.expectedUser.Roles) ||
+				!slices.Equal(user.Commands, tc.expectedUser.Commands) {
+				t.Errorf("Expected user %v, got %v", tc.expectedUser, user)
+			}
+		})
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	tokens := &mockTokenStore{}
+	authService := NewAuthService(&mockStorage{user: tokenUser}, tokens)
+	token, apiToken, err := authService.CreateToken(context.Background(), "ci", "", nil, nil, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+
+	err = authService.RevokeToken(context.Background(), apiToken.Id)
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+
+	_, err = authService.GetTokenUser(context.Background(), token)
+	if !errors.Is(err, InvalidTokenError) {
+		t.Errorf("Expected revoked token to be invalid, got %q", err)
+	}
+}
@@ -0,0 +1,240 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+)
+
+// defaultApprovalExpiry is how long requests for approval wait for a decision
+// if neither the command nor the settings configure it.
+const defaultApprovalExpiry = time.Hour
+
+// pendingApproval holds what is needed to start an execution once it was
+// approved.
+type pendingApproval struct {
+	execution *entity.CommandExecution
+	command   entity.Command
+	params    []entity.ParameterValue
+	// timer expires the request
+	timer *time.Timer
+}
+
+// requestApproval creates an execution that waits for the decision of an
+// approver instead of starting it. Pending executions are not part of
+// execWaitGroup, so they do not delay a shutdown.
+func (s *CommandService) requestApproval(ctx context.Context, command *entity.Command, execution *entity.CommandExecution, paramValues []entity.ParameterValue) (int, error) {
+	expiry := s.approvalExpiry(ctx, command)
+	execution.Status = entity.ExecutionStatusPendingApproval
+	execution.Approval = &entity.ExecutionApproval{
+		Role:    command.RequiresApproval.Role,
+		Expires: time.Now().Add(expiry),
+	}
+	err := s.createExecution(ctx, execution)
+	if err != nil {
+		return 0, err
+	}
+	s.recordAudit(ctx, executionAuditEvent(execution))
+
+	run := &runningExecution{
+		done:    make(chan any),
+		changed: make(chan any),
+		approval: &pendingApproval{
+			execution: execution,
+			command:   *command,
+			params:    paramValues,
+		},
+	}
+	execId := execution.ExecId
+	s.runningMutex.Lock()
+	s.running[execId] = run
+	run.approval.timer = time.AfterFunc(expiry, func() { s.expireApproval(execId) })
+	s.runningMutex.Unlock()
+
+	slog.Info("Execution waiting for approval", "exec_id", execId, "command_id", command.Id)
+	s.appendLog(execId, entity.LogEntry{
+		Stream: "system",
+		Data:   fmt.Sprintf("waiting for approval by a user with role %s until %s", command.RequiresApproval.Role, execution.Approval.Expires.Format(time.DateTime)),
+	})
+	return execId, nil
+}
+
+func (s *CommandService) approvalExpiry(ctx context.Context, command *entity.Command) time.Duration {
+	if command.RequiresApproval.Expiry != nil {
+		return time.Duration(*command.RequiresApproval.Expiry)
+	}
+	settings, err := s.storage.GetSettings(ctx)
+	if err != nil || settings.ApprovalExpiry <= 0 {
+		return defaultApprovalExpiry
+	}
+	return time.Duration(settings.ApprovalExpiry)
+}
+
+// GetPendingApprovals returns the executions waiting for approval the user
+// may decide about, oldest first.
+func (s *CommandService) GetPendingApprovals(ctx context.Context, user entity.User) ([]entity.CommandExecution, error) {
+	s.runningMutex.Lock()
+	pending := make([]entity.CommandExecution, 0)
+	for _, run := range s.running {
+		if run.approval != nil && mayDecide(user, run.approval.execution) == nil {
+			pending = append(pending, *run.approval.execution)
+		}
+	}
+	s.runningMutex.Unlock()
+
+	slices.SortFunc(pending, func(a, b entity.CommandExecution) int {
+		return a.ExecId - b.ExecId
+	})
+	return pending, nil
+}
+
+// mayDecide checks whether the user may approve or reject the execution.
+func mayDecide(user entity.User, execution *entity.CommandExecution) error {
+	if !userHasRole(user, execution.Approval.Role) {
+		return UnauthorizedError
+	}
+	if execution.User == user.Username {
+		return SelfApprovalError
+	}
+	return nil
+}
+
+// DecideApproval approves or rejects an execution waiting for approval.
+// Approved executions start like any other execution, rejected ones finish
+// without starting.
+func (s *CommandService) DecideApproval(ctx context.Context, user entity.User, execId int, approved bool, comment string) error {
+	// approved executions are queued like newly admitted ones
+	s.admitMutex.Lock()
+	defer s.admitMutex.Unlock()
+
+	limit := s.maxParallelExecutions(ctx)
+	s.runningMutex.Lock()
+	run, ok := s.running[execId]
+	if !ok || run.approval == nil {
+		s.runningMutex.Unlock()
+		return ApprovalNotPendingError
+	}
+	pending := run.approval
+	err := mayDecide(user, pending.execution)
+	if err != nil {
+		s.runningMutex.Unlock()
+		return err
+	}
+	command := &pending.command
+	if approved && command.Concurrency == entity.ConcurrencyPolicyReject && s.commandBusy(command.Id) {
+		// the execution keeps waiting, so it can be approved later
+		s.runningMutex.Unlock()
+		return ConcurrencyLimitError
+	}
+	run.approval = nil
+	pending.timer.Stop()
+	execution := pending.execution
+	execution.Status = ""
+	if approved && !command.IsWorkflow() && (len(s.queue) > 0 || !s.canStart(command, limit)) {
+		execution.Status = entity.ExecutionStatusQueued
+	}
+	s.runningMutex.Unlock()
+
+	decision := entity.ApprovalDecisionRejected
+	if approved {
+		decision = entity.ApprovalDecisionApproved
+	}
+	recordDecision(execution, decision, user.Username, comment)
+	details := map[string]string{
+		"exec_id":    strconv.Itoa(execId),
+		"command_id": execution.CommandId,
+		"decision":   string(decision),
+	}
+	if comment != "" {
+		details["comment"] = comment
+	}
+	s.recordAudit(ctx, entity.AuditEvent{
+		Type:    entity.AuditEventApproval,
+		User:    user.Username,
+		Details: details,
+	})
+	slog.Info("Execution approval decided", "exec_id", execId, "decision", decision, "user", user.Username)
+	message := fmt.Sprintf("%s by %s", decision, user.Username)
+	if comment != "" {
+		message += ": " + comment
+	}
+	s.appendLog(execId, entity.LogEntry{Stream: "system", Data: message})
+
+	if !approved {
+		execution.Status = entity.ExecutionStatusRejected
+		s.finishPending(execution, run)
+		return nil
+	}
+	if command.IsWorkflow() {
+		startTime := time.Now()
+		execution.StartTime = &startTime
+		s.updateExecution(execution)
+		s.runningMutex.Lock()
+		run.workflow = true
+		s.runningMutex.Unlock()
+		s.beginWorkflow(command, execution, run, pending.params)
+		return nil
+	}
+	s.updateExecution(execution)
+	s.execWaitGroup.Add(1)
+	s.runningMutex.Lock()
+	run.queued = true
+	s.queue = append(s.queue, &queuedExecution{
+		execution: execution,
+		command:   *command,
+		params:    pending.params,
+		run:       run,
+	})
+	s.runningMutex.Unlock()
+	s.dispatch()
+	return nil
+}
+
+// expireApproval finishes an execution that is still waiting for approval
+// when its request expired.
+func (s *CommandService) expireApproval(execId int) {
+	s.runningMutex.Lock()
+	run, ok := s.running[execId]
+	if !ok || run.approval == nil {
+		s.runningMutex.Unlock()
+		return
+	}
+	pending := run.approval
+	run.approval = nil
+	s.runningMutex.Unlock()
+
+	slog.Info("Execution approval expired", "exec_id", execId)
+	execution := pending.execution
+	recordDecision(execution, entity.ApprovalDecisionExpired, "", "")
+	s.appendLog(execId, entity.LogEntry{Stream: "system", Data: "approval request expired"})
+	execution.Status = entity.ExecutionStatusExpired
+	s.finishPending(execution, run)
+}
+
+// recordDecision stores the decision in a copy of the approval, as the
+// previous one may still be read by others.
+func recordDecision(execution *entity.CommandExecution, decision entity.ApprovalDecision, username string, comment string) {
+	approval := *execution.Approval
+	now := time.Now()
+	approval.Decision = decision
+	approval.DecidedBy = username
+	approval.Comment = comment
+	approval.DecisionTime = &now
+	execution.Approval = &approval
+}
+
+// finishPending finishes an execution that never started because it was not
+// approved.
+func (s *CommandService) finishPending(execution *entity.CommandExecution, run *runningExecution) {
+	exitCode := -1
+	execution.ExitCode = &exitCode
+	// finishExecution releases execWaitGroup, which pending executions were
+	// not added to
+	s.execWaitGroup.Add(1)
+	s.finishExecution(execution, run)
+}
@@ -0,0 +1,175 @@
+//go:build linux
+
+package command
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+)
+
+// cpuPeriod is the period of cpu.max in microseconds.
+const cpuPeriod = 100000
+
+// cgroup is a transient cgroup v2 an execution runs in, so the resources of
+// all its processes can be limited and measured.
+type cgroup struct {
+	path string
+	dir  *os.File
+}
+
+// newCgroup creates a cgroup for an execution below parent.
+func newCgroup(parent string, execId int, limits *entity.Limits) (*cgroup, error) {
+	err := enableControllers(parent, limits)
+	if err != nil {
+		return nil, err
+	}
+	path, err := os.MkdirTemp(parent, fmt.Sprintf("exec-%d-", execId))
+	if err != nil {
+		return nil, err
+	}
+	c := &cgroup{path: path}
+	if limits != nil {
+		err = c.setLimits(limits)
+		if err != nil {
+			c.remove()
+			return nil, err
+		}
+	}
+	c.dir, err = os.Open(path)
+	if err != nil {
+		c.remove()
+		return nil, err
+	}
+	return c, nil
+}
+
+// enableControllers makes the controllers needed for the limits available in
+// the children of parent. The memory controller is enabled if possible even
+// without a memory limit, as it measures the peak memory.
+func enableControllers(parent string, limits *entity.Limits) error {
+	available, err := os.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+	subtreeControl := filepath.Join(parent, "cgroup.subtree_control")
+	enabled, err := os.ReadFile(subtreeControl)
+	if err != nil {
+		return err
+	}
+
+	wanted := make([]string, 0)
+	if slices.Contains(strings.Fields(string(available)), "memory") {
+		wanted = append(wanted, "memory")
+	}
+	if limits != nil {
+		if limits.Memory > 0 && !slices.Contains(wanted, "memory") {
+			wanted = append(wanted, "memory")
+		}
+		if limits.Cpu > 0 {
+			wanted = append(wanted, "cpu")
+		}
+		if limits.Pids > 0 {
+			wanted = append(wanted, "pids")
+		}
+	}
+	missing := make([]string, 0)
+	for _, controller := range wanted {
+		if !slices.Contains(strings.Fields(string(enabled)), controller) {
+			missing = append(missing, "+"+controller)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	err = os.WriteFile(subtreeControl, []byte(strings.Join(missing, " ")), 0)
+	if err != nil {
+		return fmt.Errorf("failed to enable controllers %s in %s: %w", strings.Join(missing, " "), parent, err)
+	}
+	return nil
+}
+
+func (c *cgroup) setLimits(limits *entity.Limits) error {
+	if limits.Memory > 0 {
+		err := c.write("memory.max", strconv.FormatInt(int64(limits.Memory), 10))
+		if err != nil {
+			return err
+		}
+	}
+	if limits.Cpu > 0 {
+		quota := max(int(limits.Cpu*cpuPeriod), 1000)
+		err := c.write("cpu.max", fmt.Sprintf("%d %d", quota, cpuPeriod))
+		if err != nil {
+			return err
+		}
+	}
+	if limits.Pids > 0 {
+		err := c.write("pids.max", strconv.Itoa(limits.Pids))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *cgroup) write(file string, value string) error {
+	return os.WriteFile(filepath.Join(c.path, file), []byte(value), 0)
+}
+
+// fd returns the file descriptor processes are started in the cgroup with.
+func (c *cgroup) fd() int {
+	return int(c.dir.Fd())
+}
+
+// usage reads the resources used by all processes of the cgroup. Values that
+// are not available, like memory.peak before Linux 5.19, are left unchanged.
+func (c *cgroup) usage(usage *entity.ResourceUsage) {
+	peak, err := os.ReadFile(filepath.Join(c.path, "memory.peak"))
+	if err == nil {
+		value, err := strconv.ParseInt(strings.TrimSpace(string(peak)), 10, 64)
+		if err == nil {
+			usage.PeakMemory = &value
+		}
+	}
+	stat, err := os.ReadFile(filepath.Join(c.path, "cpu.stat"))
+	if err == nil {
+		for _, line := range strings.Split(string(stat), "\n") {
+			if value, found := strings.CutPrefix(line, "usage_usec "); found {
+				usec, err := strconv.ParseInt(value, 10, 64)
+				if err == nil {
+					cpuTime := time.Duration(usec) * time.Microsecond
+					usage.CpuTime = &cpuTime
+				}
+			}
+		}
+	}
+}
+
+// remove deletes the cgroup. It fails if processes of the execution are still
+// running, e.g. because they were started in the background.
+func (c *cgroup) remove() {
+	if c.dir != nil {
+		c.dir.Close()
+	}
+	var err error
+	// the kernel might need a moment to release exited processes
+	for i := 0; i < 10; i++ {
+		err = os.Remove(c.path)
+		if !errors.Is(err, syscall.EBUSY) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		slog.Warn("Failed to remove cgroup of execution", "path", c.path, "error", err)
+	}
+}
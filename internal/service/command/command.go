@@ -1,44 +1,139 @@
 package command
 
 import (
-	"bufio"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"math"
+	"os"
 	"os/exec"
 	"slices"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/jrammler/wheelhouse/internal/entity"
+	"github.com/jrammler/wheelhouse/internal/service"
 	"github.com/jrammler/wheelhouse/internal/storage"
 )
 
 var CommandNotFoundError = errors.New("Command with given ID not found")
 var UnauthorizedError = errors.New("User is not authorized to execute this command")
+var ExecutionNotRunningError = errors.New("Execution is not running")
+var ConcurrencyLimitError = errors.New("Command is already running")
+var ApprovalNotPendingError = errors.New("Execution is not waiting for approval")
+var SelfApprovalError = errors.New("Users can not approve their own executions")
 
 type Command interface {
-	Run() error
+	Start() error
+	Wait() error
 	StdoutPipe() (io.ReadCloser, error)
 	StderrPipe() (io.ReadCloser, error)
 	ExitCode() int
+	// Terminate asks the command and all its child processes to stop.
+	Terminate() error
+	// Kill forcefully stops the command and all its child processes.
+	Kill() error
+	// Usage returns the resources used by the command once Wait returned.
+	Usage() entity.ResourceUsage
+	// Release frees the resources of a command that is not going to be
+	// started. Start releases them itself if it fails.
+	Release()
 }
 
+// ExecOptions holds the values passed to a command in addition to the command
+// string itself.
+type ExecOptions struct {
+	Args []string
+	// Env is added to the inherited environment, later values take precedence.
+	Env []string
+	// InheritEnv lists the variables of the server environment passed to the
+	// command, nil means all of them.
+	InheritEnv []string
+	// Dir is the working directory, the one of the server if empty.
+	Dir string
+	// RunAs is the user the command is run as, the user of the server if nil.
+	RunAs  *entity.RunAs
+	Limits *entity.Limits
+	// CgroupParent is the cgroup the cgroup of the execution is created in,
+	// see entity.Settings.
+	CgroupParent string
+	// ExecId identifies the execution in the names of its resources.
+	ExecId int
+	// Tty runs the command with a pseudo-terminal as stdout and stderr.
+	Tty bool
+}
+
+// outputWaitDelay is how long the output of a command is read after it exited,
+// for child processes that keep stdout or stderr open.
+const outputWaitDelay = 2 * time.Second
+
 type execCommand struct {
 	cmd *exec.Cmd
+	// pipes are closed once the output was copied to them, see pipe.
+	pipes []*io.PipeWriter
+	// finish releases the resources of the command after it exited and
+	// measures what it used. The state is nil if the command did not start.
+	finish func(state *os.ProcessState) entity.ResourceUsage
+	usage  entity.ResourceUsage
+}
+
+func (e *execCommand) Start() error {
+	err := e.cmd.Start()
+	if err != nil {
+		e.Release()
+	}
+	return err
+}
+
+func (e *execCommand) Release() {
+	e.closePipes()
+	if e.finish != nil {
+		e.finish(nil)
+	}
+}
+
+func (e *execCommand) Wait() error {
+	err := e.cmd.Wait()
+	e.closePipes()
+	if e.finish != nil {
+		e.usage = e.finish(e.cmd.ProcessState)
+	}
+	return err
 }
 
-func (e *execCommand) Run() error {
-	return e.cmd.Run()
+func (e *execCommand) Usage() entity.ResourceUsage {
+	return e.usage
 }
 
 func (e *execCommand) StdoutPipe() (io.ReadCloser, error) {
-	return e.cmd.StdoutPipe()
+	return e.pipe(&e.cmd.Stdout)
 }
 
 func (e *execCommand) StderrPipe() (io.ReadCloser, error) {
-	return e.cmd.StderrPipe()
+	return e.pipe(&e.cmd.Stderr)
+}
+
+// pipe connects an output of the command to a pipe. Unlike the pipes of
+// exec.Cmd, which Wait closes as soon as the process exited, it is only closed
+// after all output was read from it, so the end of the output is not lost.
+func (e *execCommand) pipe(output *io.Writer) (io.ReadCloser, error) {
+	if *output != nil {
+		return nil, errors.New("output already set")
+	}
+	r, w := io.Pipe()
+	*output = w
+	e.pipes = append(e.pipes, w)
+	e.cmd.WaitDelay = outputWaitDelay
+	return r, nil
+}
+
+func (e *execCommand) closePipes() {
+	for _, w := range e.pipes {
+		w.Close()
+	}
 }
 
 func (e *execCommand) ExitCode() int {
@@ -50,22 +145,68 @@ func (e *execCommand) ExitCode() int {
 
 type CommandService struct {
 	storage       storage.Storage
+	executions    storage.ExecutionStore
 	execWaitGroup *sync.WaitGroup
-	history       []*entity.CommandExecution
-	historyOffset int
-	historyMutex  sync.RWMutex
-	commander     Commander
+	running       map[int]*runningExecution
+	// queue holds the executions waiting to be started in admission order
+	queue []*queuedExecution
+	// active counts the started executions per command ID
+	active       map[string]int
+	activeCount  int
+	runningMutex sync.Mutex
+	admitMutex   sync.Mutex
+	commander    Commander
+	audit        service.AuditService
+	logFiles     storage.LogFileStore
+	notify       service.NotificationService
 }
 
-func NewCommandService(storage storage.Storage, commander Commander) *CommandService {
+// runningExecution holds the state needed to stop an execution whose process
+// has not exited yet.
+type runningExecution struct {
+	// cmd is nil until the process was started
+	cmd  Command
+	done chan any
+	// changed is closed and replaced whenever the execution changes
+	changed     chan any
+	queued      bool
+	exited      bool
+	cancelledBy *string
+	timedOut    bool
+	// workflow executions run the executions in children instead of a
+	// process
+	workflow bool
+	children []int
+	// approval is set while the execution waits for approval
+	approval *pendingApproval
+}
+
+// queuedExecution holds what is needed to start an execution once the
+// concurrency limits allow it.
+type queuedExecution struct {
+	execution *entity.CommandExecution
+	command   entity.Command
+	params    []entity.ParameterValue
+	run       *runningExecution
+}
+
+func NewCommandService(sto storage.Storage, executions storage.ExecutionStore, commander Commander, audit service.AuditService, logFiles storage.LogFileStore, notify service.NotificationService) *CommandService {
+	if executions == nil {
+		executions = storage.NewMemoryExecutionStore(storage.DefaultMemoryHistoryLength)
+	}
 	if commander == nil {
 		commander = &execCommander{}
 	}
 	s := CommandService{
-		storage:       storage,
+		storage:       sto,
+		executions:    executions,
 		execWaitGroup: &sync.WaitGroup{},
-		history:       make([]*entity.CommandExecution, 0),
+		running:       make(map[int]*runningExecution),
+		active:        make(map[string]int),
 		commander:     commander,
+		audit:         audit,
+		logFiles:      logFiles,
+		notify:        notify,
 	}
 	return &s
 }
@@ -78,7 +219,7 @@ func (s *CommandService) GetCommands(ctx context.Context, user entity.User) ([]e
 
 	filteredCommands := make([]entity.Command, 0)
 	for _, command := range commands {
-		if command.Role == nil || userHasRole(user, *command.Role) {
+		if userMayAccess(user, &command) {
 			filteredCommands = append(filteredCommands, command)
 		}
 	}
@@ -88,21 +229,27 @@ func (s *CommandService) GetCommands(ctx context.Context, user entity.User) ([]e
 
 func pipeStreamToLog(stream string, pipe io.Reader, logChan chan<- entity.LogEntry, doneChan chan<- int) {
 	go func() {
-		scanner := bufio.NewScanner(pipe)
-		for scanner.Scan() {
+		err := readLog(pipe, func(data string) {
 			logChan <- entity.LogEntry{
 				Stream: stream,
-				Data:   scanner.Text(),
+				Data:   data,
+				Time:   time.Now(),
+			}
+		})
+		if err != nil {
+			logChan <- entity.LogEntry{
+				Stream: "system",
+				Data:   fmt.Sprintf("error reading %s: %s", stream, err),
 			}
 		}
 		doneChan <- 0
 	}()
 }
 
-const maxHistLen int = 100
 const maxLogLen int = 1000
+const defaultCancelGracePeriod = 10 * time.Second
 
-func (s *CommandService) ExecuteCommand(ctx context.Context, user entity.User, id string) (int, error) {
+func (s *CommandService) ExecuteCommand(ctx context.Context, user entity.User, id string, params map[string]string, trigger entity.Trigger) (int, error) {
 	command, err := s.storage.GetCommandById(ctx, id)
 	if err != nil {
 		return 0, err
@@ -111,134 +258,671 @@ func (s *CommandService) ExecuteCommand(ctx context.Context, user entity.User, i
 		return 0, CommandNotFoundError
 	}
 
-	if command.Role != nil && !userHasRole(user, *command.Role) {
+	if !userMayAccess(user, command) {
 		return 0, UnauthorizedError
 	}
 
+	paramValues, err := resolveParameters(command, params)
+	if err != nil {
+		return 0, err
+	}
+
 	execution := entity.CommandExecution{
-		CommandId: id,
-		ExecTime:  time.Now(),
+		CommandId:   id,
+		CommandName: command.Name,
+		CommandText: command.Command,
+		CommandRole: command.Role,
+		User:        user.Username,
+		Trigger:     trigger,
+		ExecTime:    time.Now(),
+		Parameters:  maskParameters(command, paramValues),
+	}
+	if command.IsWorkflow() {
+		execution.CommandText = workflowText(command)
+	}
+	switch {
+	case command.RequiresApproval != nil:
+		return s.requestApproval(ctx, command, &execution, paramValues)
+	case command.IsWorkflow():
+		return s.startWorkflow(ctx, command, &execution, paramValues)
+	default:
+		return s.admit(ctx, command, &execution, paramValues)
+	}
+}
+
+// admit queues an execution of a command and starts it if the concurrency
+// limits allow it.
+func (s *CommandService) admit(ctx context.Context, command *entity.Command, execution *entity.CommandExecution, paramValues []entity.ParameterValue) (int, error) {
+	// executions are admitted one at a time, so the queue is in the order of
+	// the execution IDs
+	s.admitMutex.Lock()
+	defer s.admitMutex.Unlock()
+
+	limit := s.maxParallelExecutions(ctx)
+	s.runningMutex.Lock()
+	if command.Concurrency == entity.ConcurrencyPolicyReject && s.commandBusy(command.Id) {
+		s.runningMutex.Unlock()
+		return 0, ConcurrencyLimitError
+	}
+	if len(s.queue) > 0 || !s.canStart(command, limit) {
+		execution.Status = entity.ExecutionStatusQueued
 	}
+	s.runningMutex.Unlock()
 
-	s.historyMutex.Lock()
-	histLen := len(s.history)
-	execution.ExecId = histLen + s.historyOffset
-	if histLen >= maxHistLen {
-		removeCnt := histLen - maxHistLen + 1
-		for i := 0; i < removeCnt; i++ {
-			s.history[i] = nil
+	err := s.createExecution(ctx, execution)
+	if err != nil {
+		return 0, err
+	}
+	s.recordAudit(ctx, executionAuditEvent(execution))
+
+	run := &runningExecution{
+		done:    make(chan any),
+		changed: make(chan any),
+		queued:  true,
+	}
+	s.execWaitGroup.Add(1)
+	s.runningMutex.Lock()
+	s.running[execution.ExecId] = run
+	s.queue = append(s.queue, &queuedExecution{
+		execution: execution,
+		command:   *command,
+		params:    paramValues,
+		run:       run,
+	})
+	s.runningMutex.Unlock()
+
+	if execution.Status == entity.ExecutionStatusQueued {
+		slog.Info("Execution queued", "exec_id", execution.ExecId, "command_id", command.Id)
+	}
+	s.dispatch()
+	return execution.ExecId, nil
+}
+
+// maxParallelExecutions returns the global limit of running executions, zero
+// means no limit.
+func (s *CommandService) maxParallelExecutions(ctx context.Context) int {
+	settings, err := s.storage.GetSettings(ctx)
+	if err != nil {
+		slog.Error("Failed to determine execution limit", "error", err)
+		return 0
+	}
+	return settings.MaxParallelExecutions
+}
+
+// commandBusy reports whether an execution of the command is running or
+// queued. The caller must hold runningMutex.
+func (s *CommandService) commandBusy(commandId string) bool {
+	if s.active[commandId] > 0 {
+		return true
+	}
+	return slices.ContainsFunc(s.queue, func(q *queuedExecution) bool {
+		return q.command.Id == commandId
+	})
+}
+
+// canStart reports whether an execution of the command may start now. The
+// caller must hold runningMutex.
+func (s *CommandService) canStart(command *entity.Command, limit int) bool {
+	if limit > 0 && s.activeCount >= limit {
+		return false
+	}
+	return command.Concurrency != entity.ConcurrencyPolicyQueue || s.active[command.Id] == 0
+}
+
+// dispatch starts queued executions in order as long as the limits allow it.
+// Executions waiting for another execution of the same command do not block
+// executions of other commands.
+func (s *CommandService) dispatch() {
+	limit := s.maxParallelExecutions(context.Background())
+
+	s.runningMutex.Lock()
+	startable := make([]*queuedExecution, 0)
+	remaining := make([]*queuedExecution, 0, len(s.queue))
+	for _, q := range s.queue {
+		if s.canStart(&q.command, limit) {
+			s.active[q.command.Id] += 1
+			s.activeCount += 1
+			q.run.queued = false
+			startable = append(startable, q)
+		} else {
+			remaining = append(remaining, q)
 		}
-		s.history = s.history[removeCnt:]
-		s.historyOffset += removeCnt
 	}
-	s.history = append(s.history, &execution)
-	s.historyMutex.Unlock()
+	s.queue = remaining
+	s.runningMutex.Unlock()
 
-	slog.Info("Executing command", "command_id", id, "command_name", command.Name, "command", command.Command)
-	cmd := s.commander.Command(command.Command)
+	for _, q := range startable {
+		s.start(q)
+	}
+}
+
+// release frees the slot of a finished execution and starts the next queued
+// executions.
+func (s *CommandService) release(commandId string) {
+	s.runningMutex.Lock()
+	s.active[commandId] -= 1
+	if s.active[commandId] <= 0 {
+		delete(s.active, commandId)
+	}
+	s.activeCount -= 1
+	s.runningMutex.Unlock()
+	s.dispatch()
+}
+
+// start runs the process of an execution that was taken from the queue.
+func (s *CommandService) start(q *queuedExecution) {
+	execution := q.execution
+	command := &q.command
+	run := q.run
+	if execution.Status == entity.ExecutionStatusQueued {
+		execution.Status = ""
+		s.updateExecution(execution)
+	}
+
+	slog.Info("Executing command", "command_id", command.Id, "command_name", command.Name, "command", command.Command)
+	settings, err := s.storage.GetSettings(context.Background())
+	if err != nil {
+		s.failStart(q, err)
+		return
+	}
+	opts := commandExecOptions(command, settings, q.params)
+	opts.ExecId = execution.ExecId
+	cmd, err := s.commander.Command(command.Command, opts)
+	if err != nil {
+		s.failStart(q, err)
+		return
+	}
+
+	// the pipes and the cgroup of the command are released by Start and
+	// Wait, or here if setting it up fails before
+	starting := false
+	defer func() {
+		if !starting {
+			cmd.Release()
+		}
+	}()
 
-	logChan := make(chan entity.LogEntry)
-	doneChan := make(chan int)
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return 0, err
+		s.failStart(q, err)
+		return
 	}
-	pipeStreamToLog("stdout", stdout, logChan, doneChan)
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		return 0, err
+		s.failStart(q, err)
+		return
 	}
+	logChan := make(chan entity.LogEntry)
+	doneChan := make(chan int)
+	pipeStreamToLog("stdout", stdout, logChan, doneChan)
 	pipeStreamToLog("stderr", stderr, logChan, doneChan)
 
+	fullLog := s.createFullLog(execution.ExecId, settings)
 	allDone := make(chan any)
 	go func() {
 		doneCnt := 0
-		// read from log channel until both stdout and stderr are closed
-	loop:
+		logLen := 0
+		tail := make([]entity.LogEntry, 0, logTailLen)
+		// read from log channel until both stdout and stderr are closed. The
+		// history only keeps the beginning and the end of long logs, but the
+		// output is read completely so the command does not block on a full
+		// pipe.
 		for doneCnt < 2 {
 			select {
 			case log := <-logChan:
-				execution.Log = append(execution.Log, log)
-				if len(execution.Log) > maxLogLen {
-					execution.Log = append(execution.Log, entity.LogEntry{
+				fullLog.write(log)
+				logLen += 1
+				if logLen <= maxLogLen {
+					s.appendLog(execution.ExecId, log)
+					continue
+				}
+				if logLen == maxLogLen+1 {
+					s.appendLog(execution.ExecId, entity.LogEntry{
 						Stream: "system",
-						Data:   "log truncated ...",
+						Data:   "log truncated, the end of the output is shown once the execution finished",
 					})
-					break loop
+				}
+				if len(tail) == logTailLen {
+					copy(tail, tail[1:])
+					tail[len(tail)-1] = log
+				} else {
+					tail = append(tail, log)
 				}
 			case <-doneChan:
 				doneCnt += 1
 			}
 		}
+		fullLog.close()
+		if omitted := logLen - maxLogLen - len(tail); omitted > 0 {
+			s.appendLog(execution.ExecId, entity.LogEntry{
+				Stream: "system",
+				Data:   fullLog.omittedMessage(omitted),
+			})
+		}
+		if len(tail) > 0 {
+			s.appendLog(execution.ExecId, tail...)
+		}
 		close(allDone)
 	}()
 
-	s.execWaitGroup.Add(1)
+	startTime := time.Now()
+	starting = true
+	err = cmd.Start()
+	if err != nil {
+		<-allDone
+		s.failStart(q, err)
+		return
+	}
+	execution.StartTime = &startTime
+	s.updateExecution(execution)
+	s.sendNotification(entity.NotificationEventStarted, execution)
+
+	s.runningMutex.Lock()
+	run.cmd = cmd
+	cancelled := run.cancelledBy != nil
+	s.runningMutex.Unlock()
+	if cancelled {
+		// cancelled while starting
+		stopCommand(run, s.cancelGracePeriod(context.Background()))
+	}
+
+	timeout, err := s.commandTimeout(context.Background(), command)
+	if err != nil {
+		slog.Error("Failed to determine command timeout", "error", err)
+	}
+	if timeout > 0 {
+		go s.enforceTimeout(execution.ExecId, run, timeout)
+	}
+
 	go func() {
-		err = cmd.Run()
+		err := cmd.Wait()
 		if err != nil {
 			slog.Info("Command returned error", "error", err)
 		}
 		// only set exit code once log is fully written
 		<-allDone
+		s.runningMutex.Lock()
+		run.exited = true
+		cancelledBy, timedOut := run.cancelledBy, run.timedOut
+		s.runningMutex.Unlock()
+
+		if cancelledBy != nil {
+			s.appendLog(execution.ExecId, entity.LogEntry{
+				Stream: "system",
+				Data:   fmt.Sprintf("execution cancelled by %s", *cancelledBy),
+			})
+			execution.Status = entity.ExecutionStatusCancelled
+			execution.CancelledBy = cancelledBy
+		} else if timedOut {
+			s.appendLog(execution.ExecId, entity.LogEntry{
+				Stream: "system",
+				Data:   fmt.Sprintf("execution timed out after %s", timeout),
+			})
+			execution.Status = entity.ExecutionStatusTimedOut
+		}
 		exitCode := cmd.ExitCode()
 		execution.ExitCode = &exitCode
+		execution.Usage = cmd.Usage()
+		if settings.LogCompress && fullLog.created {
+			s.compressLog(execution.ExecId)
+		}
+		s.finishExecution(execution, run)
 		slog.Info("Executing command completed")
-		s.execWaitGroup.Done()
+		s.release(command.Id)
 	}()
-	return execution.ExecId, nil
 }
 
-func (s *CommandService) GetExecutionHistory(ctx context.Context, user entity.User) ([]entity.ExecutionHistoryEntry, error) {
-	s.historyMutex.RLock()
-	defer s.historyMutex.RUnlock()
+// failStart finishes an execution whose process could not be started.
+func (s *CommandService) failStart(q *queuedExecution, err error) {
+	slog.Info("Command could not be started", "error", err)
+	s.appendLog(q.execution.ExecId, entity.LogEntry{
+		Stream: "system",
+		Data:   err.Error(),
+	})
+	exitCode := -1
+	q.execution.ExitCode = &exitCode
+	s.finishExecution(q.execution, q.run)
+	s.release(q.command.Id)
+}
 
-	history := make([]entity.ExecutionHistoryEntry, 0)
-	for _, execution := range s.history {
-		command, err := s.storage.GetCommandById(ctx, execution.CommandId)
-		if err != nil || command == nil {
-			slog.Error("command not found", "command_id", execution.CommandId)
-			continue
-		}
+// finishExecution stores the final state of an execution and notifies its
+// subscribers.
+func (s *CommandService) finishExecution(execution *entity.CommandExecution, run *runningExecution) {
+	endTime := time.Now()
+	execution.EndTime = &endTime
+	s.updateExecution(execution)
+	s.sendNotification(finalNotificationEvent(execution), execution)
+
+	// subscribers treat executions that are no longer running as finished,
+	// so the execution must only be removed once it is fully stored
+	s.runningMutex.Lock()
+	delete(s.running, execution.ExecId)
+	close(run.changed)
+	s.runningMutex.Unlock()
+	close(run.done)
+	s.execWaitGroup.Done()
+}
 
-		if command.Role != nil && !userHasRole(user, *command.Role) {
-			continue
+func (s *CommandService) recordAudit(ctx context.Context, event entity.AuditEvent) {
+	if s.audit != nil {
+		s.audit.Record(ctx, event)
+	}
+}
+
+func (s *CommandService) sendNotification(event entity.NotificationEvent, execution *entity.CommandExecution) {
+	if s.notify != nil {
+		s.notify.Notify(context.Background(), event, *execution)
+	}
+}
+
+// finalNotificationEvent returns the event describing how an execution ended.
+func finalNotificationEvent(execution *entity.CommandExecution) entity.NotificationEvent {
+	switch {
+	case execution.Status == entity.ExecutionStatusCancelled, execution.Status == entity.ExecutionStatusRejected, execution.Status == entity.ExecutionStatusExpired:
+		return entity.NotificationEventCancelled
+	case execution.Status == entity.ExecutionStatusTimedOut:
+		return entity.NotificationEventTimedOut
+	case execution.ExitCode != nil && *execution.ExitCode == 0:
+		return entity.NotificationEventSucceeded
+	default:
+		return entity.NotificationEventFailed
+	}
+}
+
+func executionAuditEvent(execution *entity.CommandExecution) entity.AuditEvent {
+	details := map[string]string{
+		"exec_id":      strconv.Itoa(execution.ExecId),
+		"command_id":   execution.CommandId,
+		"command_name": execution.CommandName,
+		"trigger":      string(execution.Trigger.Type),
+	}
+	if execution.Trigger.Source != "" {
+		details["trigger_source"] = execution.Trigger.Source
+	}
+	// secret values are already masked
+	for _, param := range execution.Parameters {
+		details["param."+param.Name] = param.Value
+	}
+	return entity.AuditEvent{
+		Type:     entity.AuditEventExecution,
+		User:     execution.User,
+		SourceIp: execution.Trigger.SourceIp,
+		Details:  details,
+	}
+}
+
+// appendLog stores log entries of a running execution, entries without a time
+// get the current one. Failures are only logged, as the execution continues
+// regardless.
+func (s *CommandService) appendLog(execId int, entries ...entity.LogEntry) {
+	now := time.Now()
+	for i := range entries {
+		if entries[i].Time.IsZero() {
+			entries[i].Time = now
 		}
+	}
+	err := s.executions.AppendLog(context.Background(), execId, entries...)
+	if err != nil {
+		slog.Error("Failed to store log entries", "exec_id", execId, "error", err)
+	}
+	s.notifySubscribers(execId)
+}
+
+// createExecution stores a new execution and assigns its ID.
+func (s *CommandService) createExecution(ctx context.Context, execution *entity.CommandExecution) error {
+	err := s.executions.CreateExecution(ctx, execution)
+	if err != nil {
+		return err
+	}
+	s.removeStaleLog(execution.ExecId)
+	return nil
+}
 
-		history = append(history, entity.ExecutionHistoryEntry{
-			ExecId:      execution.ExecId,
-			Time:        execution.ExecTime,
-			CommandName: command.Name,
-			ExitCode:    execution.ExitCode,
+func (s *CommandService) updateExecution(execution *entity.CommandExecution) {
+	err := s.executions.UpdateExecution(context.Background(), execution)
+	if err != nil {
+		slog.Error("Failed to store execution", "exec_id", execution.ExecId, "error", err)
+	}
+}
+
+func (s *CommandService) CancelExecution(ctx context.Context, user entity.User, execId int) error {
+	execution, err := s.GetExecution(ctx, user, execId)
+	if err != nil {
+		return err
+	}
+
+	s.runningMutex.Lock()
+	run, ok := s.running[execId]
+	if !ok || run.exited || run.cancelledBy != nil || run.timedOut {
+		s.runningMutex.Unlock()
+		return ExecutionNotRunningError
+	}
+	username := user.Username
+	run.cancelledBy = &username
+	pending := run.approval
+	if pending != nil {
+		run.approval = nil
+		pending.timer.Stop()
+	}
+	var queued *queuedExecution
+	if run.queued {
+		idx := slices.IndexFunc(s.queue, func(q *queuedExecution) bool { return q.run == run })
+		queued = s.queue[idx]
+		s.queue = slices.Delete(s.queue, idx, idx+1)
+	}
+	starting := run.cmd == nil
+	children := slices.Clone(run.children)
+	s.runningMutex.Unlock()
+
+	slog.Info("Cancelling execution", "exec_id", execId, "user", username)
+	s.recordAudit(ctx, entity.AuditEvent{
+		Type: entity.AuditEventCancellation,
+		User: username,
+		Details: map[string]string{
+			"exec_id":    strconv.Itoa(execId),
+			"command_id": execution.CommandId,
+		},
+	})
+	if pending != nil {
+		s.appendLog(execId, entity.LogEntry{
+			Stream: "system",
+			Data:   fmt.Sprintf("execution cancelled by %s before it was approved", username),
 		})
+		pending.execution.Status = entity.ExecutionStatusCancelled
+		pending.execution.CancelledBy = &username
+		s.finishPending(pending.execution, run)
+		return nil
+	}
+	if queued != nil {
+		s.appendLog(execId, entity.LogEntry{
+			Stream: "system",
+			Data:   fmt.Sprintf("execution cancelled by %s before it started", username),
+		})
+		exitCode := -1
+		queued.execution.ExitCode = &exitCode
+		queued.execution.Status = entity.ExecutionStatusCancelled
+		queued.execution.CancelledBy = &username
+		s.finishExecution(queued.execution, run)
+		return nil
+	}
+	if run.workflow {
+		// the workflow stops once its running steps are finished
+		for _, childId := range children {
+			s.cancelStep(childId, username)
+		}
+		return nil
+	}
+	if !starting {
+		// otherwise the command is stopped once it was started
+		stopCommand(run, s.cancelGracePeriod(ctx))
+	}
+	return nil
+}
+
+func (s *CommandService) cancelGracePeriod(ctx context.Context) time.Duration {
+	settings, err := s.storage.GetSettings(ctx)
+	if err != nil || settings.CancelGracePeriod <= 0 {
+		return defaultCancelGracePeriod
+	}
+	return time.Duration(settings.CancelGracePeriod)
+}
+
+// commandTimeout returns the timeout of the command, falling back to the
+// global default. A timeout of zero means the command may run forever.
+func (s *CommandService) commandTimeout(ctx context.Context, command *entity.Command) (time.Duration, error) {
+	if command.Timeout != nil {
+		return time.Duration(*command.Timeout), nil
+	}
+	settings, err := s.storage.GetSettings(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(settings.DefaultTimeout), nil
+}
+
+func (s *CommandService) enforceTimeout(execId int, run *runningExecution, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-run.done:
+		return
+	case <-timer.C:
 	}
 
+	s.runningMutex.Lock()
+	if run.exited || run.cancelledBy != nil {
+		s.runningMutex.Unlock()
+		return
+	}
+	run.timedOut = true
+	s.runningMutex.Unlock()
+
+	slog.Info("Execution timed out", "exec_id", execId, "timeout", timeout)
+	stopCommand(run, s.cancelGracePeriod(context.Background()))
+}
+
+// stopCommand sends a termination request to the process group of the
+// running execution and kills it if it did not exit within the grace period.
+func stopCommand(run *runningExecution, gracePeriod time.Duration) {
+	err := run.cmd.Terminate()
+	if err != nil {
+		slog.Error("Failed to terminate command", "error", err)
+	}
+	go func() {
+		select {
+		case <-run.done:
+		case <-time.After(gracePeriod):
+			slog.Info("Command did not exit within grace period, killing it")
+			err := run.cmd.Kill()
+			if err != nil {
+				slog.Error("Failed to kill command", "error", err)
+			}
+		}
+	}()
+}
+
+// historyBatchSize is the number of executions read from the store at once
+// while collecting the history a user may access.
+const historyBatchSize = 100
+
+// GetExecutionHistory returns the executions matching the filter the user may
+// access, in the order they were created. Offset and Limit of the filter
+// count only these executions.
+func (s *CommandService) GetExecutionHistory(ctx context.Context, user entity.User, filter entity.ExecutionFilter) ([]entity.ExecutionHistoryEntry, error) {
+	query := entity.ExecutionFilter{User: filter.User, ParentId: filter.ParentId, Limit: historyBatchSize}
+	// the history is collected newest first, oldest is the ExecId of the
+	// last execution read, so executions created in the meantime are not
+	// read twice
+	history := make([]entity.ExecutionHistoryEntry, 0)
+	skipped := 0
+	oldest := math.MaxInt
+	for filter.Limit == 0 || len(history) < filter.Limit {
+		executions, err := s.executions.GetExecutions(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, execution := range slices.Backward(executions) {
+			if execution.ExecId >= oldest || (filter.Limit > 0 && len(history) == filter.Limit) {
+				continue
+			}
+			oldest = execution.ExecId
+
+			command, err := s.executionCommand(ctx, &execution)
+			if err != nil {
+				return nil, err
+			}
+			if !userMayAccess(user, command) {
+				continue
+			}
+			if skipped < filter.Offset {
+				skipped++
+				continue
+			}
+
+			entry := entity.ExecutionHistoryEntry{
+				ExecId:      execution.ExecId,
+				Time:        execution.ExecTime,
+				CommandName: execution.CommandName,
+				User:        execution.User,
+				Trigger:     execution.Trigger.Type,
+				ExitCode:    execution.ExitCode,
+				Status:      execution.Status,
+				ParentId:    execution.ParentId,
+			}
+			if duration, ok := execution.Duration(); ok {
+				entry.Duration = &duration
+			}
+			history = append(history, entry)
+		}
+		if len(executions) < query.Limit {
+			break
+		}
+		query.Offset += len(executions)
+	}
+	slices.Reverse(history)
 	return history, nil
 }
 
 func (s *CommandService) GetExecution(ctx context.Context, user entity.User, execId int) (*entity.CommandExecution, error) {
-	s.historyMutex.RLock()
-	defer s.historyMutex.RUnlock()
-
-	idx := execId - s.historyOffset
-	if idx < 0 || idx >= len(s.history) {
+	execution, err := s.executions.GetExecution(ctx, execId)
+	if errors.Is(err, storage.ExecutionNotFoundError) {
 		return nil, CommandNotFoundError
 	}
-	execution := s.history[idx]
+	if err != nil {
+		return nil, err
+	}
 
-	command, err := s.storage.GetCommandById(ctx, execution.CommandId)
-	if err != nil || command == nil {
-		slog.Error("command not found", "command_id", execution.CommandId)
-		return nil, CommandNotFoundError
+	command, err := s.executionCommand(ctx, execution)
+	if err != nil {
+		return nil, err
 	}
 
-	if command.Role != nil && !userHasRole(user, *command.Role) {
+	if !userMayAccess(user, command) {
 		return nil, UnauthorizedError
 	}
 
 	return execution, nil
 }
 
+// executionCommand returns the command used for access checks on an
+// execution. That is the current command if it still exists, or the command
+// as it was when it was executed otherwise.
+func (s *CommandService) executionCommand(ctx context.Context, execution *entity.CommandExecution) (*entity.Command, error) {
+	command, err := s.storage.GetCommandById(ctx, execution.CommandId)
+	if err != nil {
+		return nil, err
+	}
+	if command != nil {
+		return command, nil
+	}
+	return &entity.Command{
+		Id:      execution.CommandId,
+		Name:    execution.CommandName,
+		Command: execution.CommandText,
+		Role:    execution.CommandRole,
+	}, nil
+}
+
 func (s *CommandService) WaitExecutions(ctx context.Context) {
 	done := make(chan any)
 	go func() {
@@ -254,3 +938,16 @@ func (s *CommandService) WaitExecutions(ctx context.Context) {
 func userHasRole(user entity.User, role string) bool {
 	return slices.Contains(user.Roles, role)
 }
+
+// userMayAccess checks whether the user has the role required by the command
+// and, if the user is restricted to some commands, whether it is one of them.
+// System users may access every command.
+func userMayAccess(user entity.User, command *entity.Command) bool {
+	if user.System {
+		return true
+	}
+	if command.Role != nil && !userHasRole(user, *command.Role) {
+		return false
+	}
+	return user.Commands == nil || slices.Contains(user.Commands, command.Id)
+}
@@ -3,23 +3,301 @@
 package command
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
 	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
 	"syscall"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
 )
 
 type Commander interface {
-	Command(command string) Command
+	Command(command string, opts ExecOptions) (Command, error)
 }
 
 type execCommander struct{}
 
-func (rc *execCommander) Command(command string) Command {
-	cmd := exec.Command("/bin/sh", "-c", command)
-	cmd.SysProcAttr = &syscall.SysProcAttr{
+func (rc *execCommander) Command(command string, opts ExecOptions) (Command, error) {
+	sysProcAttr := &syscall.SysProcAttr{
 		Setpgid: true,
 	}
+	environ := os.Environ()
+	if opts.RunAs != nil {
+		credential, u, err := resolveRunAs(opts.RunAs)
+		if err != nil {
+			return nil, err
+		}
+		sysProcAttr.Credential = credential
+		environ = userEnviron(environ, u)
+	}
+
+	var cg *cgroup
+	if opts.CgroupParent != "" {
+		var err error
+		cg, err = newCgroup(opts.CgroupParent, opts.ExecId, opts.Limits)
+		if err != nil {
+			slog.Warn("Failed to create cgroup, falling back to setrlimit", "error", err)
+		} else {
+			// start the process directly in the cgroup, so all its children
+			// are in it as well
+			sysProcAttr.UseCgroupFD = true
+			sysProcAttr.CgroupFD = cg.fd()
+		}
+	}
+	if opts.Limits != nil {
+		command = rlimitScript(opts.Limits, cg == nil) + command
+	}
 
-	return &execCommand{
+	// arguments are passed as positional parameters ($1, $2, ...) of the shell
+	args := append([]string{"-c", command, "sh"}, opts.Args...)
+	cmd := exec.Command("/bin/sh", args...)
+	cmd.SysProcAttr = sysProcAttr
+	cmd.Dir = opts.Dir
+	e := &execCommand{
 		cmd: cmd,
+		finish: func(state *os.ProcessState) entity.ResourceUsage {
+			usage := processUsage(state)
+			if cg != nil {
+				cg.usage(&usage)
+				cg.remove()
+			}
+			return usage
+		},
+	}
+	if !opts.Tty {
+		cmd.Env = commandEnv(environ, opts)
+		return e, nil
+	}
+
+	master, slave, err := openPty()
+	if err != nil {
+		if cg != nil {
+			cg.remove()
+		}
+		return nil, err
+	}
+	// the terminal becomes the controlling terminal of a new session, which
+	// is also a new process group. Stdin stays /dev/null, so prompts fail
+	// instead of waiting forever.
+	sysProcAttr.Setpgid = false
+	sysProcAttr.Setsid = true
+	sysProcAttr.Setctty = true
+	sysProcAttr.Ctty = 1
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	// TERM may still be overridden by the configured environment
+	opts.Env = append([]string{"TERM=xterm-256color"}, opts.Env...)
+	cmd.Env = commandEnv(environ, opts)
+	return &ptyCommand{execCommand: e, master: master, slave: slave}, nil
+}
+
+// rlimitScript returns shell commands that apply the limits with ulimit. Only
+// the number of open files is always limited this way, the other limits only
+// if there is no cgroup.
+func rlimitScript(limits *entity.Limits, withoutCgroup bool) string {
+	ulimits := make([]string, 0)
+	if limits.NoFile > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -n %d", limits.NoFile))
+	}
+	if withoutCgroup {
+		if limits.Memory > 0 {
+			// limits the address space, which is larger than the memory
+			// actually used
+			ulimits = append(ulimits, fmt.Sprintf("ulimit -v %d", max(int64(limits.Memory)/1024, 1)))
+		}
+		if limits.Cpu > 0 || limits.Pids > 0 {
+			slog.Warn("CPU and process limits are not enforced without cgroups")
+		}
+	}
+	if len(ulimits) == 0 {
+		return ""
+	}
+	return strings.Join(ulimits, " && ") + " || exit 126\n"
+}
+
+// processUsage returns the resources used by the process and the children it
+// waited for.
+func processUsage(state *os.ProcessState) entity.ResourceUsage {
+	usage := entity.ResourceUsage{}
+	if state == nil {
+		return usage
+	}
+	cpuTime := state.UserTime() + state.SystemTime()
+	usage.CpuTime = &cpuTime
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		// ru_maxrss is given in kilobytes
+		peak := rusage.Maxrss * 1024
+		usage.PeakMemory = &peak
+	}
+	return usage
+}
+
+// resolveRunAs looks up the user and groups a command is run as. The
+// credential is nil if they are the ones of the server, so no privileges are
+// needed.
+func resolveRunAs(runAs *entity.RunAs) (*syscall.Credential, *user.User, error) {
+	u, err := lookupUser(runAs.User)
+	if err != nil {
+		return nil, nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("user %q has non-numeric uid %q", runAs.User, u.Uid)
+	}
+
+	gidStr := u.Gid
+	if runAs.Group != "" {
+		gidStr, err = lookupGroup(runAs.Group)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	gid, err := strconv.ParseUint(gidStr, 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("group of user %q has non-numeric gid %q", runAs.User, gidStr)
+	}
+
+	// like login, the supplementary groups default to the ones of the user
+	groupIds := make([]string, 0, len(runAs.Groups))
+	if runAs.Groups == nil {
+		groupIds, err = u.GroupIds()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to look up groups of user %q: %w", runAs.User, err)
+		}
+	}
+	for _, group := range runAs.Groups {
+		id, err := lookupGroup(group)
+		if err != nil {
+			return nil, nil, err
+		}
+		groupIds = append(groupIds, id)
+	}
+	groups := make([]uint32, 0, len(groupIds))
+	for _, id := range groupIds {
+		g, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf("non-numeric gid %q", id)
+		}
+		groups = append(groups, uint32(g))
+	}
+
+	if uid == uint64(os.Getuid()) && gid == uint64(os.Getgid()) && runAs.Groups == nil {
+		return nil, u, nil
+	}
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups}, u, nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		u, err := user.LookupId(name)
+		if err == nil {
+			return u, nil
+		}
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user %q: %w", name, err)
+	}
+	return u, nil
+}
+
+func lookupGroup(name string) (string, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return name, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return "", fmt.Errorf("unknown group %q: %w", name, err)
+	}
+	return g.Gid, nil
+}
+
+// userEnviron replaces the variables describing the user of the server by the
+// ones of u.
+func userEnviron(environ []string, u *user.User) []string {
+	env := make([]string, 0, len(environ))
+	for _, v := range environ {
+		name, _, _ := strings.Cut(v, "=")
+		if name != "HOME" && name != "USER" && name != "LOGNAME" {
+			env = append(env, v)
+		}
+	}
+	return append(env, "HOME="+u.HomeDir, "USER="+u.Username, "LOGNAME="+u.Username)
+}
+
+// capability numbers from linux/capability.h
+const (
+	capSetgid = 6
+	capSetuid = 7
+)
+
+// checkCommand verifies the parts of a command that depend on the system.
+func checkCommand(command entity.Command) error {
+	if command.RunAs != nil {
+		return checkRunAs(command.RunAs)
+	}
+	return nil
+}
+
+// checkRunAs verifies that the user of a command exists and the server is
+// allowed to switch to it.
+func checkRunAs(runAs *entity.RunAs) error {
+	credential, _, err := resolveRunAs(runAs)
+	if err != nil {
+		return err
+	}
+	if credential == nil {
+		return nil
+	}
+	caps, err := effectiveCapabilities()
+	if err != nil {
+		return fmt.Errorf("failed to determine privileges of the server: %w", err)
+	}
+	if caps&(1<<capSetuid) == 0 || caps&(1<<capSetgid) == 0 {
+		return fmt.Errorf("running commands as user %q requires the server to run as root or with the capabilities CAP_SETUID and CAP_SETGID", runAs.User)
+	}
+	return nil
+}
+
+// effectiveCapabilities reads the effective capability set of the process.
+func effectiveCapabilities() (uint64, error) {
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, found := strings.CutPrefix(scanner.Text(), "CapEff:"); found {
+			return strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("CapEff missing in /proc/self/status")
+}
+
+// Terminate and Kill signal the process group, which is also the session of
+// commands running in tty mode.
+func (e *execCommand) Terminate() error {
+	return e.signalGroup(syscall.SIGTERM)
+}
+
+func (e *execCommand) Kill() error {
+	return e.signalGroup(syscall.SIGKILL)
+}
+
+// signalGroup sends the signal to the process group the command was started in.
+func (e *execCommand) signalGroup(sig syscall.Signal) error {
+	if e.cmd.Process == nil {
+		return errors.New("command not started")
 	}
+	return syscall.Kill(-e.cmd.Process.Pid, sig)
 }
@@ -4,19 +4,38 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/jrammler/wheelhouse/internal/entity"
 	"github.com/jrammler/wheelhouse/internal/storage"
 )
 
 type mockCommand struct {
-	exitCode int
+	exitCode   int
+	stdout     string
+	terminated chan any
+	signals    []string
+	usage      entity.ResourceUsage
+	stderrErr  error
+	released   bool
 }
 
-func (m *mockCommand) Run() error {
+func (m *mockCommand) Start() error {
+	return nil
+}
+
+func (m *mockCommand) Wait() error {
+	if m.terminated != nil {
+		<-m.terminated
+	}
 	if m.exitCode == 0 {
 		return nil
 	}
@@ -24,10 +43,16 @@ func (m *mockCommand) Run() error {
 }
 
 func (m *mockCommand) StdoutPipe() (io.ReadCloser, error) {
+	if m.stdout != "" {
+		return io.NopCloser(bytes.NewBufferString(m.stdout)), nil
+	}
 	return io.NopCloser(bytes.NewBufferString("stdout message")), nil
 }
 
 func (m *mockCommand) StderrPipe() (io.ReadCloser, error) {
+	if m.stderrErr != nil {
+		return nil, m.stderrErr
+	}
 	return io.NopCloser(bytes.NewBufferString("stderr message")), nil
 }
 
@@ -35,21 +60,77 @@ func (m *mockCommand) ExitCode() int {
 	return m.exitCode
 }
 
-type mockCommander struct{}
+func (m *mockCommand) Usage() entity.ResourceUsage {
+	return m.usage
+}
+
+func (m *mockCommand) Release() {
+	m.released = true
+}
+
+func (m *mockCommand) Terminate() error {
+	m.signals = append(m.signals, "TERM")
+	return nil
+}
+
+func (m *mockCommand) Kill() error {
+	m.signals = append(m.signals, "KILL")
+	m.exitCode = -1
+	close(m.terminated)
+	return nil
+}
+
+type mockCommander struct {
+	lastOpts    ExecOptions
+	lastCommand *mockCommand
+}
 
-func (m *mockCommander) Command(command string) Command {
+func (m *mockCommander) Command(command string, opts ExecOptions) (Command, error) {
+	m.lastOpts = opts
+	if opts.RunAs != nil && opts.RunAs.User == "nobody" {
+		return nil, errors.New("unknown user")
+	}
+	if command == "block" {
+		// blocks until killed
+		m.lastCommand = &mockCommand{
+			terminated: make(chan any),
+		}
+		return m.lastCommand, nil
+	}
 	if command == "fail" {
 		return &mockCommand{
 			exitCode: 1,
+		}, nil
+	}
+	if command == "long" {
+		lines := make([]string, 0, 1500)
+		for i := 1; i <= 1500; i++ {
+			lines = append(lines, fmt.Sprintf("line %d", i))
+		}
+		return &mockCommand{
+			stdout: strings.Join(lines, "\n"),
+		}, nil
+	}
+	if command == "nopipe" {
+		m.lastCommand = &mockCommand{
+			stderrErr: errors.New("no pipe"),
 		}
+		return m.lastCommand, nil
+	}
+	if command == "measure" {
+		peakMemory, cpuTime := int64(4096), time.Second
+		return &mockCommand{
+			usage: entity.ResourceUsage{PeakMemory: &peakMemory, CpuTime: &cpuTime},
+		}, nil
 	}
 	return &mockCommand{
 		exitCode: 0,
-	}
+	}, nil
 }
 
 type mockStorage struct {
 	commands []entity.Command
+	settings entity.Settings
 }
 
 func (m *mockStorage) GetCommands(ctx context.Context) ([]entity.Command, error) {
@@ -58,11 +139,8 @@ func (m *mockStorage) GetCommands(ctx context.Context) ([]entity.Command, error)
 
 func (m *mockStorage) GetCommandById(ctx context.Context, id string) (*entity.Command, error) {
 	num, err := strconv.Atoi(id)
-	if err != nil {
-		return nil, CommandNotFoundError
-	}
-	if num < 0 || num >= len(m.commands) {
-		return nil, CommandNotFoundError
+	if err != nil || num < 0 || num >= len(m.commands) {
+		return nil, nil
 	}
 	return &m.commands[num], nil
 }
@@ -71,11 +149,18 @@ func (m *mockStorage) GetUser(ctx context.Context, username string) (entity.User
 	return entity.User{}, storage.UserNotFoundError
 }
 
+func (m *mockStorage) GetSettings(ctx context.Context) (entity.Settings, error) {
+	return m.settings, nil
+}
+
 func (m *mockStorage) LoadConfig() error {
 	return nil
 }
 
 var (
+	defaultReplicas = "2"
+	defaultVersion  = "v1.0"
+
 	role1    = "developer"
 	role2    = "admin"
 	mockCmds = []entity.Command{
@@ -83,6 +168,13 @@ var (
 		{Name: "Hello", Command: "echo Hello", Role: &role1},
 		{Name: "Echo secret", Command: "echo $SECRET", Role: &role2},
 		{Name: "Failing", Command: "fail"},
+		{Name: "Deploy", Command: "deploy", Parameters: []entity.CommandParameter{
+			{Name: "target", Type: entity.ParameterTypeChoice, Choices: []string{"staging", "prod"}},
+			{Name: "replicas", Type: entity.ParameterTypeInt, Default: &defaultReplicas},
+			{Name: "version", Type: entity.ParameterTypeString, Pattern: `v[0-9.]+`, Default: &defaultVersion},
+			{Name: "token", Type: entity.ParameterTypeSecret},
+		}},
+		{Name: "Blocking", Command: "block"},
 	}
 	mockSt = &mockStorage{
 		commands: mockCmds,
@@ -91,6 +183,7 @@ var (
 	user2     = entity.User{Roles: []string{"developer"}}
 	user3     = entity.User{Roles: []string{"developer", "admin"}}
 	commander = &mockCommander{}
+	trigger   = entity.Trigger{Type: entity.TriggerTypeWeb, SourceIp: "192.0.2.1", UserAgent: "test"}
 )
 
 func TestGetCommands(t *testing.T) {
@@ -99,9 +192,11 @@ func TestGetCommands(t *testing.T) {
 		mockCmds[0],
 		mockCmds[1],
 		mockCmds[3],
+		mockCmds[4],
+		mockCmds[5],
 	}
 
-	cs := NewCommandService(mockSt, commander)
+	cs := NewCommandService(mockSt, nil, commander, nil, nil, nil)
 
 	// Act
 	cmds, err := cs.GetCommands(context.Background(), user2)
@@ -125,10 +220,10 @@ func TestGetCommands(t *testing.T) {
 func TestExecuteCommand(t *testing.T) {
 	t.Run("Valid ID", func(t *testing.T) {
 		// Arrange
-		cs := NewCommandService(mockSt, commander)
+		cs := NewCommandService(mockSt, nil, commander, nil, nil, nil)
 
 		// Act
-		execID, err := cs.ExecuteCommand(context.Background(), user1, "0")
+		execID, err := cs.ExecuteCommand(context.Background(), user1, "0", nil, trigger)
 
 		// Assert
 		if err != nil {
@@ -167,10 +262,10 @@ func TestExecuteCommand(t *testing.T) {
 
 	t.Run("Invalid ID", func(t *testing.T) {
 		// Arrange
-		cs := NewCommandService(mockSt, commander)
+		cs := NewCommandService(mockSt, nil, commander, nil, nil, nil)
 
 		// Act
-		_, err := cs.ExecuteCommand(context.Background(), user1, "5")
+		_, err := cs.ExecuteCommand(context.Background(), user1, "9", nil, trigger)
 
 		// Assert
 		if err == nil {
@@ -184,10 +279,10 @@ func TestExecuteCommand(t *testing.T) {
 
 	t.Run("Unauthorized", func(t *testing.T) {
 		// Arrange
-		cs := NewCommandService(mockSt, commander)
+		cs := NewCommandService(mockSt, nil, commander, nil, nil, nil)
 
 		// Act
-		_, err := cs.ExecuteCommand(context.Background(), user2, "2")
+		_, err := cs.ExecuteCommand(context.Background(), user2, "2", nil, trigger)
 
 		// Assert
 		if err == nil {
@@ -201,10 +296,10 @@ func TestExecuteCommand(t *testing.T) {
 
 	t.Run("Command Failure", func(t *testing.T) {
 		// Arrange
-		cs := NewCommandService(mockSt, commander)
+		cs := NewCommandService(mockSt, nil, commander, nil, nil, nil)
 
 		// Act
-		execID, err := cs.ExecuteCommand(context.Background(), user1, "3")
+		execID, err := cs.ExecuteCommand(context.Background(), user1, "3", nil, trigger)
 
 		// Assert
 		if err != nil {
@@ -230,9 +325,9 @@ func TestExecuteCommand(t *testing.T) {
 func TestGetExecutionHistory(t *testing.T) {
 	// Arrange
 	expectedCommand := mockCmds[0]
-	cs := NewCommandService(mockSt, commander)
+	cs := NewCommandService(mockSt, nil, commander, nil, nil, nil)
 
-	_, err := cs.ExecuteCommand(context.Background(), user1, "0")
+	_, err := cs.ExecuteCommand(context.Background(), user1, "0", nil, trigger)
 	if err != nil {
 		t.Fatalf("ExecuteCommand failed: %q", err)
 	}
@@ -240,7 +335,7 @@ func TestGetExecutionHistory(t *testing.T) {
 	cs.WaitExecutions(context.Background())
 
 	// Act
-	history, err := cs.GetExecutionHistory(context.Background(), user1)
+	history, err := cs.GetExecutionHistory(context.Background(), user1, entity.ExecutionFilter{})
 
 	// Assert
 	if err != nil {
@@ -256,11 +351,47 @@ func TestGetExecutionHistory(t *testing.T) {
 	}
 }
 
+func TestGetExecutionRemovedCommand(t *testing.T) {
+	// Arrange
+	st := &mockStorage{commands: slices.Clone(mockCmds)}
+	cs := NewCommandService(st, nil, commander, nil, nil, nil)
+
+	execID, err := cs.ExecuteCommand(context.Background(), user2, "1", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+	cs.WaitExecutions(context.Background())
+	st.commands = st.commands[:1]
+
+	// Act
+	exec, err := cs.GetExecution(context.Background(), user2, execID)
+	history, historyErr := cs.GetExecutionHistory(context.Background(), user2, entity.ExecutionFilter{})
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Got error %q when getting execution", err)
+	}
+	if exec.CommandName != mockCmds[1].Name || exec.CommandText != mockCmds[1].Command {
+		t.Errorf("Expected snapshot of %q, got %q (%q)", mockCmds[1].Name, exec.CommandName, exec.CommandText)
+	}
+	if historyErr != nil {
+		t.Fatalf("GetExecutionHistory failed: %q", historyErr)
+	}
+	if len(history) != 1 || history[0].CommandName != mockCmds[1].Name {
+		t.Errorf("Expected removed command in history, got %v", history)
+	}
+
+	_, err = cs.GetExecution(context.Background(), user1, execID)
+	if !errors.Is(err, UnauthorizedError) {
+		t.Errorf("Expected UnauthorizedError for snapshot role, got %q", err)
+	}
+}
+
 func TestGetExecution(t *testing.T) {
 	// Arrange
-	cs := NewCommandService(mockSt, commander)
+	cs := NewCommandService(mockSt, nil, commander, nil, nil, nil)
 
-	execID, err := cs.ExecuteCommand(context.Background(), user1, "0")
+	execID, err := cs.ExecuteCommand(context.Background(), user1, "0", nil, trigger)
 	if err != nil {
 		t.Fatalf("ExecuteCommand failed: %q", err)
 	}
@@ -290,7 +421,1311 @@ func TestGetExecution(t *testing.T) {
 		t.Errorf("Expected exit code 0, got %v", exec.ExitCode)
 	}
 
+	if exec.Trigger != trigger {
+		t.Errorf("Expected trigger %v, got %v", trigger, exec.Trigger)
+	}
+
 	if len(exec.Log) == 0 {
 		t.Errorf("Expected log entries, got none")
 	}
 }
+
+func TestGetExecutionHistoryFilter(t *testing.T) {
+	// Arrange
+	cs := NewCommandService(mockSt, nil, commander, nil, nil, nil)
+	alice := entity.User{Username: "alice"}
+	bob := entity.User{Username: "bob"}
+	for _, user := range []entity.User{alice, bob, alice} {
+		_, err := cs.ExecuteCommand(context.Background(), user, "0", nil, trigger)
+		if err != nil {
+			t.Fatalf("ExecuteCommand failed: %q", err)
+		}
+	}
+	cs.WaitExecutions(context.Background())
+
+	// Act
+	history, err := cs.GetExecutionHistory(context.Background(), bob, entity.ExecutionFilter{User: "alice"})
+
+	// Assert
+	if err != nil {
+		t.Fatalf("GetExecutionHistory failed: %q", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 executions of alice, got %v", history)
+	}
+	for _, entry := range history {
+		if entry.User != "alice" || entry.Trigger != entity.TriggerTypeWeb {
+			t.Errorf("Expected web execution of alice, got %v", entry)
+		}
+	}
+}
+
+func TestGetExecutionHistoryPage(t *testing.T) {
+	// Arrange
+	cs := NewCommandService(mockSt, storage.NewMemoryExecutionStore(300), commander, nil, nil, nil)
+	for i := 0; i < 250; i++ {
+		// every second execution is of a command user1 may not access
+		id := "0"
+		if i%2 == 1 {
+			id = "2"
+		}
+		_, err := cs.ExecuteCommand(context.Background(), user3, id, nil, trigger)
+		if err != nil {
+			t.Fatalf("ExecuteCommand failed: %q", err)
+		}
+	}
+	cs.WaitExecutions(context.Background())
+
+	// Act
+	history, err := cs.GetExecutionHistory(context.Background(), user1, entity.ExecutionFilter{Offset: 10, Limit: 100})
+
+	// Assert
+	if err != nil {
+		t.Fatalf("GetExecutionHistory failed: %q", err)
+	}
+	if len(history) != 100 {
+		t.Fatalf("Expected 100 executions, got %d", len(history))
+	}
+	if history[0].ExecId != 30 || history[99].ExecId != 228 {
+		t.Errorf("Expected executions 30 to 228, got %d to %d", history[0].ExecId, history[99].ExecId)
+	}
+	for _, entry := range history {
+		if entry.CommandName != mockCmds[0].Name {
+			t.Errorf("Expected only accessible executions, got %v", entry)
+		}
+	}
+}
+
+func TestExecuteCommandParameters(t *testing.T) {
+	testCases := []struct {
+		name          string
+		params        map[string]string
+		expectedError error
+		expectedArgs  []string
+	}{
+		{
+			name:         "Defaults applied",
+			params:       map[string]string{"target": "staging", "token": "s3cret"},
+			expectedArgs: []string{"staging", "2", "v1.0", "s3cret"},
+		},
+		{
+			name:         "All values provided",
+			params:       map[string]string{"target": "prod", "replicas": "05", "version": "v2.1", "token": "s3cret"},
+			expectedArgs: []string{"prod", "5", "v2.1", "s3cret"},
+		},
+		{
+			name:          "Missing required value",
+			params:        map[string]string{"target": "prod"},
+			expectedError: InvalidParameterError,
+		},
+		{
+			name:          "Invalid choice",
+			params:        map[string]string{"target": "dev", "token": "s3cret"},
+			expectedError: InvalidParameterError,
+		},
+		{
+			name:          "Invalid integer",
+			params:        map[string]string{"target": "prod", "replicas": "two", "token": "s3cret"},
+			expectedError: InvalidParameterError,
+		},
+		{
+			name:          "Pattern mismatch",
+			params:        map[string]string{"target": "prod", "version": "v1; rm -rf /", "token": "s3cret"},
+			expectedError: InvalidParameterError,
+		},
+		{
+			name:          "Unknown parameter",
+			params:        map[string]string{"target": "prod", "token": "s3cret", "other": "x"},
+			expectedError: InvalidParameterError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			commander := &mockCommander{}
+			cs := NewCommandService(mockSt, nil, commander, nil, nil, nil)
+
+			// Act
+			execID, err := cs.ExecuteCommand(context.Background(), user1, "4", tc.params, trigger)
+
+			// Assert
+			if tc.expectedError != nil {
+				if !errors.Is(err, tc.expectedError) {
+					t.Fatalf("Expected %q, got %q", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExecuteCommand failed: %q", err)
+			}
+			cs.WaitExecutions(context.Background())
+
+			if !slices.Equal(commander.lastOpts.Args, tc.expectedArgs) {
+				t.Errorf("Expected args %v, got %v", tc.expectedArgs, commander.lastOpts.Args)
+			}
+			if !slices.Contains(commander.lastOpts.Env, "PARAM_TARGET="+tc.expectedArgs[0]) {
+				t.Errorf("Expected PARAM_TARGET in env, got %v", commander.lastOpts.Env)
+			}
+
+			exec, err := cs.GetExecution(context.Background(), user1, execID)
+			if err != nil {
+				t.Fatalf("Got error %q when getting execution", err)
+			}
+			if len(exec.Parameters) != 4 {
+				t.Fatalf("Expected 4 recorded parameters, got %v", exec.Parameters)
+			}
+			if exec.Parameters[3].Value != maskedValue {
+				t.Errorf("Expected secret to be masked, got %q", exec.Parameters[3].Value)
+			}
+		})
+	}
+}
+
+type mockAuditService struct {
+	mu     sync.Mutex
+	events []entity.AuditEvent
+}
+
+func (m *mockAuditService) Record(ctx context.Context, event entity.AuditEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, event)
+}
+
+func (m *mockAuditService) GetEvents(ctx context.Context, user entity.User) ([]entity.AuditEvent, error) {
+	return m.events, nil
+}
+
+func TestCancelExecution(t *testing.T) {
+	// Arrange
+	commander := &mockCommander{}
+	st := &mockStorage{
+		commands: mockCmds,
+		settings: entity.Settings{CancelGracePeriod: entity.Duration(10 * time.Millisecond)},
+	}
+	audit := &mockAuditService{}
+	cs := NewCommandService(st, nil, commander, audit, nil, nil)
+	user := entity.User{Username: "alice"}
+
+	execID, err := cs.ExecuteCommand(context.Background(), user, "5", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+
+	// Act
+	err = cs.CancelExecution(context.Background(), user, execID)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("CancelExecution failed: %q", err)
+	}
+	cs.WaitExecutions(context.Background())
+
+	if !slices.Equal(commander.lastCommand.signals, []string{"TERM", "KILL"}) {
+		t.Errorf("Expected TERM followed by KILL, got %v", commander.lastCommand.signals)
+	}
+
+	exec, err := cs.GetExecution(context.Background(), user, execID)
+	if err != nil {
+		t.Fatalf("Got error %q when getting execution", err)
+	}
+	if exec.Status != entity.ExecutionStatusCancelled {
+		t.Errorf("Expected status %q, got %q", entity.ExecutionStatusCancelled, exec.Status)
+	}
+	if exec.CancelledBy == nil || *exec.CancelledBy != "alice" {
+		t.Errorf("Expected execution to be cancelled by alice, got %v", exec.CancelledBy)
+	}
+
+	err = cs.CancelExecution(context.Background(), user, execID)
+	if !errors.Is(err, ExecutionNotRunningError) {
+		t.Errorf("Expected ExecutionNotRunningError, got %q", err)
+	}
+
+	if len(audit.events) != 2 || audit.events[0].Type != entity.AuditEventExecution || audit.events[1].Type != entity.AuditEventCancellation {
+		t.Fatalf("Expected execution and cancellation to be audited, got %v", audit.events)
+	}
+	if audit.events[0].User != "alice" || audit.events[0].Details["exec_id"] != strconv.Itoa(execID) {
+		t.Errorf("Expected execution event of alice, got %v", audit.events[0])
+	}
+}
+
+func TestExecuteCommandTimeout(t *testing.T) {
+	// Arrange
+	timeout := entity.Duration(10 * time.Millisecond)
+	commander := &mockCommander{}
+	st := &mockStorage{
+		commands: []entity.Command{
+			{Name: "Blocking", Command: "block", Timeout: &timeout},
+		},
+		settings: entity.Settings{CancelGracePeriod: entity.Duration(10 * time.Millisecond)},
+	}
+	cs := NewCommandService(st, nil, commander, nil, nil, nil)
+
+	// Act
+	execID, err := cs.ExecuteCommand(context.Background(), user1, "0", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+	cs.WaitExecutions(context.Background())
+
+	// Assert
+	exec, err := cs.GetExecution(context.Background(), user1, execID)
+	if err != nil {
+		t.Fatalf("Got error %q when getting execution", err)
+	}
+	if exec.Status != entity.ExecutionStatusTimedOut {
+		t.Errorf("Expected status %q, got %q", entity.ExecutionStatusTimedOut, exec.Status)
+	}
+	if len(exec.Log) == 0 || exec.Log[len(exec.Log)-1].Stream != "system" {
+		t.Errorf("Expected system log entry explaining the timeout, got %v", exec.Log)
+	}
+	if !slices.Equal(commander.lastCommand.signals, []string{"TERM", "KILL"}) {
+		t.Errorf("Expected TERM followed by KILL, got %v", commander.lastCommand.signals)
+	}
+}
+
+func TestSubscribeExecution(t *testing.T) {
+	// Arrange
+	commander := &mockCommander{}
+	st := &mockStorage{
+		commands: mockCmds,
+		settings: entity.Settings{CancelGracePeriod: entity.Duration(10 * time.Millisecond)},
+	}
+	cs := NewCommandService(st, nil, commander, nil, nil, nil)
+	user := entity.User{Username: "alice"}
+
+	execID, err := cs.ExecuteCommand(context.Background(), user, "5", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+
+	// Act
+	events, err := cs.SubscribeExecution(context.Background(), user, execID, 0)
+	if err != nil {
+		t.Fatalf("SubscribeExecution failed: %q", err)
+	}
+	err = cs.CancelExecution(context.Background(), user, execID)
+	if err != nil {
+		t.Fatalf("CancelExecution failed: %q", err)
+	}
+
+	// Assert
+	received := make([]entity.ExecutionEvent, 0)
+	for event := range events {
+		received = append(received, event)
+	}
+	if len(received) < 2 {
+		t.Fatalf("Expected log events and the final event, got %v", received)
+	}
+	logEvents := received[:len(received)-1]
+	for i, event := range logEvents {
+		if event.Log == nil || event.Index != i {
+			t.Errorf("Expected log entry with index %d, got %v", i, event)
+		}
+	}
+	if last := logEvents[len(logEvents)-1].Log; last == nil || last.Stream != "system" {
+		t.Errorf("Expected last log entry to be the system entry, got %v", last)
+	}
+	final := received[len(received)-1]
+	if final.Execution == nil || final.Execution.Status != entity.ExecutionStatusCancelled {
+		t.Errorf("Expected final event with cancelled execution, got %v", final)
+	}
+
+	// resuming after the last log entry only yields the final event
+	events, err = cs.SubscribeExecution(context.Background(), user, execID, len(logEvents))
+	if err != nil {
+		t.Fatalf("SubscribeExecution failed: %q", err)
+	}
+	received = received[:0]
+	for event := range events {
+		received = append(received, event)
+	}
+	if len(received) != 1 || received[0].Execution == nil {
+		t.Errorf("Expected only the final event, got %v", received)
+	}
+}
+
+// countingExecutionStore counts how often executions are read.
+type countingExecutionStore struct {
+	storage.ExecutionStore
+	mutex sync.Mutex
+	reads int
+}
+
+func (c *countingExecutionStore) GetExecution(ctx context.Context, execId int) (*entity.CommandExecution, error) {
+	c.mutex.Lock()
+	c.reads += 1
+	c.mutex.Unlock()
+	return c.ExecutionStore.GetExecution(ctx, execId)
+}
+
+func TestSubscribeExecutionStartTime(t *testing.T) {
+	// Arrange
+	st := &mockStorage{
+		commands: []entity.Command{
+			{Id: "0", Name: "Blocking", Command: "block"},
+			{Id: "1", Name: "Long", Command: "long"},
+		},
+		settings: entity.Settings{
+			CancelGracePeriod:     entity.Duration(time.Millisecond),
+			MaxParallelExecutions: 1,
+		},
+	}
+	executions := &countingExecutionStore{ExecutionStore: storage.NewMemoryExecutionStore(storage.DefaultMemoryHistoryLength)}
+	cs := NewCommandService(st, executions, &mockCommander{}, nil, nil, nil)
+	blocking, err := cs.ExecuteCommand(context.Background(), user1, "0", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+	queued, err := cs.ExecuteCommand(context.Background(), user1, "1", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+	events, err := cs.SubscribeExecution(context.Background(), user1, queued, 0)
+	if err != nil {
+		t.Fatalf("SubscribeExecution failed: %q", err)
+	}
+	executions.mutex.Lock()
+	executions.reads = 0
+	executions.mutex.Unlock()
+
+	// Act
+	err = cs.CancelExecution(context.Background(), user1, blocking)
+	if err != nil {
+		t.Fatalf("CancelExecution failed: %q", err)
+	}
+	received := make([]entity.ExecutionEvent, 0)
+	for event := range events {
+		received = append(received, event)
+	}
+
+	// Assert
+	final := received[len(received)-1]
+	if final.Execution == nil || final.Execution.StartTime == nil {
+		t.Fatalf("Expected final event with started execution, got %v", final)
+	}
+	stdout := 0
+	for _, event := range received {
+		if event.Log == nil || event.Log.Stream != "stdout" {
+			continue
+		}
+		stdout += 1
+		if event.StartTime == nil || !event.StartTime.Equal(*final.Execution.StartTime) {
+			t.Fatalf("Expected start time %v on output, got %v", final.Execution.StartTime, event.StartTime)
+		}
+	}
+	if stdout == 0 {
+		t.Errorf("Expected output events, got %v", received)
+	}
+	executions.mutex.Lock()
+	defer executions.mutex.Unlock()
+	if executions.reads > 10 {
+		t.Errorf("Expected the execution to be read a few times, got %d reads for %d events", executions.reads, len(received))
+	}
+}
+
+func TestGetCommandsRestricted(t *testing.T) {
+	// Arrange
+	cmds := []entity.Command{
+		{Id: "a", Name: "A", Command: "a"},
+		{Id: "b", Name: "B", Command: "b"},
+	}
+	cs := NewCommandService(&mockStorage{commands: cmds}, nil, commander, nil, nil, nil)
+	user := entity.User{Commands: []string{"b"}}
+
+	// Act
+	result, err := cs.GetCommands(context.Background(), user)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected no error, got %q", err)
+	}
+	if len(result) != 1 || result[0].Id != "b" {
+		t.Errorf("Expected only command b, got %v", result)
+	}
+}
+
+// waitForStatus polls the execution until it has the given status, as queued
+// executions are started in the background.
+func waitForStatus(t *testing.T, cs *CommandService, execID int, status entity.ExecutionStatus) *entity.CommandExecution {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		exec, err := cs.GetExecution(context.Background(), user1, execID)
+		if err != nil {
+			t.Fatalf("Got error %q when getting execution", err)
+		}
+		if exec.Status == status {
+			return exec
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected status %q, got %q", status, exec.Status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestExecuteCommandConcurrency(t *testing.T) {
+	testCases := []struct {
+		name        string
+		concurrency entity.ConcurrencyPolicy
+		limit       int
+	}{
+		{"Queue", entity.ConcurrencyPolicyQueue, 0},
+		{"Global limit", entity.ConcurrencyPolicyAllow, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			st := &mockStorage{
+				commands: []entity.Command{{Id: "0", Name: "Blocking", Command: "block", Concurrency: tc.concurrency}},
+				settings: entity.Settings{
+					CancelGracePeriod:     entity.Duration(time.Millisecond),
+					MaxParallelExecutions: tc.limit,
+				},
+			}
+			cs := NewCommandService(st, nil, &mockCommander{}, nil, nil, nil)
+			first, err := cs.ExecuteCommand(context.Background(), user1, "0", nil, trigger)
+			if err != nil {
+				t.Fatalf("ExecuteCommand failed: %q", err)
+			}
+
+			// Act
+			second, err := cs.ExecuteCommand(context.Background(), user1, "0", nil, trigger)
+
+			// Assert
+			if err != nil {
+				t.Fatalf("ExecuteCommand failed: %q", err)
+			}
+			waitForStatus(t, cs, second, entity.ExecutionStatusQueued)
+
+			err = cs.CancelExecution(context.Background(), user1, first)
+			if err != nil {
+				t.Fatalf("CancelExecution failed: %q", err)
+			}
+			waitForStatus(t, cs, second, "")
+
+			err = cs.CancelExecution(context.Background(), user1, second)
+			if err != nil {
+				t.Fatalf("CancelExecution failed: %q", err)
+			}
+			cs.WaitExecutions(context.Background())
+		})
+	}
+}
+
+func TestExecuteCommandReject(t *testing.T) {
+	// Arrange
+	st := &mockStorage{
+		commands: []entity.Command{{Id: "0", Name: "Blocking", Command: "block", Concurrency: entity.ConcurrencyPolicyReject}},
+		settings: entity.Settings{CancelGracePeriod: entity.Duration(time.Millisecond)},
+	}
+	cs := NewCommandService(st, nil, &mockCommander{}, nil, nil, nil)
+	first, err := cs.ExecuteCommand(context.Background(), user1, "0", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+
+	// Act
+	_, err = cs.ExecuteCommand(context.Background(), user1, "0", nil, trigger)
+
+	// Assert
+	if !errors.Is(err, ConcurrencyLimitError) {
+		t.Errorf("Expected ConcurrencyLimitError, got %q", err)
+	}
+	cs.CancelExecution(context.Background(), user1, first)
+	cs.WaitExecutions(context.Background())
+}
+
+func TestExecuteCommandQueueOrder(t *testing.T) {
+	// Arrange
+	st := &mockStorage{
+		commands: []entity.Command{
+			{Id: "0", Name: "Blocking", Command: "block"},
+			{Id: "1", Name: "Other", Command: "block"},
+		},
+		settings: entity.Settings{
+			CancelGracePeriod:     entity.Duration(time.Millisecond),
+			MaxParallelExecutions: 1,
+		},
+	}
+	cs := NewCommandService(st, nil, &mockCommander{}, nil, nil, nil)
+	execIDs := make([]int, 0)
+	for _, id := range []string{"0", "1", "0"} {
+		execID, err := cs.ExecuteCommand(context.Background(), user1, id, nil, trigger)
+		if err != nil {
+			t.Fatalf("ExecuteCommand failed: %q", err)
+		}
+		execIDs = append(execIDs, execID)
+	}
+
+	// Act
+	err := cs.CancelExecution(context.Background(), user1, execIDs[1])
+	if err != nil {
+		t.Fatalf("CancelExecution failed: %q", err)
+	}
+	err = cs.CancelExecution(context.Background(), user1, execIDs[0])
+	if err != nil {
+		t.Fatalf("CancelExecution failed: %q", err)
+	}
+
+	// Assert
+	cancelled := waitForStatus(t, cs, execIDs[1], entity.ExecutionStatusCancelled)
+	if cancelled.ExitCode == nil || *cancelled.ExitCode != -1 {
+		t.Errorf("Expected queued execution to finish with exit code -1, got %v", cancelled.ExitCode)
+	}
+	waitForStatus(t, cs, execIDs[2], "")
+
+	cs.CancelExecution(context.Background(), user1, execIDs[2])
+	cs.WaitExecutions(context.Background())
+}
+
+func TestExecuteCommandEnvironment(t *testing.T) {
+	// Arrange
+	commander := &mockCommander{}
+	param := "x"
+	st := &mockStorage{
+		commands: []entity.Command{
+			{
+				Name:       "Default",
+				Command:    "true",
+				Parameters: []entity.CommandParameter{{Name: "target", Default: &param}},
+			},
+			{
+				Name:       "Override",
+				Command:    "true",
+				Env:        map[string]string{"STAGE": "prod", "PARAM_TARGET": "ignored"},
+				InheritEnv: []string{},
+				Workdir:    "/srv/app",
+				Parameters: []entity.CommandParameter{{Name: "target", Default: &param}},
+			},
+		},
+		settings: entity.Settings{
+			Env:        map[string]string{"STAGE": "dev", "REGION": "eu"},
+			InheritEnv: []string{"PATH"},
+			Workdir:    "/tmp",
+		},
+	}
+	cs := NewCommandService(st, nil, commander, nil, nil, nil)
+
+	// Act
+	_, err := cs.ExecuteCommand(context.Background(), user1, "0", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+	cs.WaitExecutions(context.Background())
+	defaultOpts := commander.lastOpts
+	_, err = cs.ExecuteCommand(context.Background(), user1, "1", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+	cs.WaitExecutions(context.Background())
+	overrideOpts := commander.lastOpts
+
+	// Assert
+	expected := []string{"REGION=eu", "STAGE=dev", "PARAM_TARGET=x"}
+	if !slices.Equal(defaultOpts.Env, expected) || !slices.Equal(defaultOpts.InheritEnv, []string{"PATH"}) || defaultOpts.Dir != "/tmp" {
+		t.Errorf("Expected global defaults, got %+v", defaultOpts)
+	}
+	// parameters are appended last, so they take precedence
+	expected = []string{"PARAM_TARGET=ignored", "REGION=eu", "STAGE=prod", "PARAM_TARGET=x"}
+	if !slices.Equal(overrideOpts.Env, expected) || overrideOpts.InheritEnv == nil || len(overrideOpts.InheritEnv) != 0 || overrideOpts.Dir != "/srv/app" {
+		t.Errorf("Expected command settings, got %+v", overrideOpts)
+	}
+}
+
+func TestCommandEnv(t *testing.T) {
+	environ := []string{"PATH=/bin", "HOME=/root", "LC_ALL=C", "LC_TIME=C", "SECRET=x"}
+	testCases := []struct {
+		name     string
+		inherit  []string
+		expected []string
+	}{
+		{"inherit all", nil, append(slices.Clone(environ), "A=1")},
+		{"inherit none", []string{}, []string{"A=1"}},
+		{"allow-list", []string{"PATH", "LC_*"}, []string{"PATH=/bin", "LC_ALL=C", "LC_TIME=C", "A=1"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			env := commandEnv(environ, ExecOptions{Env: []string{"A=1"}, InheritEnv: tc.inherit})
+
+			// Assert
+			if !slices.Equal(env, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, env)
+			}
+		})
+	}
+}
+
+func TestExecuteCommandRunAsFailure(t *testing.T) {
+	// Arrange
+	commander := &mockCommander{}
+	st := &mockStorage{
+		commands: []entity.Command{
+			{Name: "Backup", Command: "backup", RunAs: &entity.RunAs{User: "nobody"}},
+		},
+	}
+	cs := NewCommandService(st, nil, commander, nil, nil, nil)
+
+	// Act
+	execID, err := cs.ExecuteCommand(context.Background(), user1, "0", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+	cs.WaitExecutions(context.Background())
+
+	// Assert
+	if commander.lastOpts.RunAs == nil || commander.lastOpts.RunAs.User != "nobody" {
+		t.Errorf("Expected run_as to be passed to the commander, got %+v", commander.lastOpts)
+	}
+	exec, err := cs.GetExecution(context.Background(), user1, execID)
+	if err != nil {
+		t.Fatalf("GetExecution failed: %q", err)
+	}
+	if exec.ExitCode == nil || *exec.ExitCode != -1 {
+		t.Errorf("Expected exit code -1, got %v", exec.ExitCode)
+	}
+	if len(exec.Log) != 1 || exec.Log[0].Stream != "system" || exec.Log[0].Data != "unknown user" {
+		t.Errorf("Expected the error in the log, got %v", exec.Log)
+	}
+}
+
+func TestExecuteCommandSetupFailure(t *testing.T) {
+	// Arrange
+	commander := &mockCommander{}
+	st := &mockStorage{
+		commands: []entity.Command{
+			{Name: "Broken", Command: "nopipe"},
+		},
+	}
+	cs := NewCommandService(st, nil, commander, nil, nil, nil)
+
+	// Act
+	execID, err := cs.ExecuteCommand(context.Background(), user1, "0", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+	cs.WaitExecutions(context.Background())
+
+	// Assert
+	if !commander.lastCommand.released {
+		t.Errorf("Expected the resources of the command to be released")
+	}
+	exec, err := cs.GetExecution(context.Background(), user1, execID)
+	if err != nil {
+		t.Fatalf("GetExecution failed: %q", err)
+	}
+	if exec.ExitCode == nil || *exec.ExitCode != -1 {
+		t.Errorf("Expected exit code -1, got %v", exec.ExitCode)
+	}
+	if len(exec.Log) != 1 || exec.Log[0].Stream != "system" || exec.Log[0].Data != "no pipe" {
+		t.Errorf("Expected the error in the log, got %v", exec.Log)
+	}
+}
+
+func TestExecuteCommandUsage(t *testing.T) {
+	// Arrange
+	commander := &mockCommander{}
+	memory := entity.ByteSize(1 << 20)
+	st := &mockStorage{
+		commands: []entity.Command{
+			{Name: "Measure", Command: "measure", Limits: &entity.Limits{Memory: memory, NoFile: 64}},
+		},
+		settings: entity.Settings{CgroupParent: "/sys/fs/cgroup/wheelhouse"},
+	}
+	cs := NewCommandService(st, nil, commander, nil, nil, nil)
+
+	// Act
+	execID, err := cs.ExecuteCommand(context.Background(), user1, "0", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+	cs.WaitExecutions(context.Background())
+
+	// Assert
+	opts := commander.lastOpts
+	if opts.Limits == nil || opts.Limits.Memory != memory || opts.CgroupParent != "/sys/fs/cgroup/wheelhouse" || opts.ExecId != execID {
+		t.Errorf("Expected limits and cgroup to be passed to the commander, got %+v", opts)
+	}
+	exec, err := cs.GetExecution(context.Background(), user1, execID)
+	if err != nil {
+		t.Fatalf("GetExecution failed: %q", err)
+	}
+	if exec.Usage.PeakMemory == nil || *exec.Usage.PeakMemory != 4096 || exec.Usage.CpuTime == nil || *exec.Usage.CpuTime != time.Second {
+		t.Errorf("Expected resource usage to be recorded, got %+v", exec.Usage)
+	}
+}
+
+func TestReadLog(t *testing.T) {
+	long := strings.Repeat("a", maxLogLineLen-1) + "ü" + "b"
+	tests := []struct {
+		name   string
+		output string
+		want   []string
+	}{
+		{"lines", "one\ntwo\n", []string{"one", "two"}},
+		{"empty lines", "one\n\n\ntwo\n", []string{"one", "", "", "two"}},
+		{"partial last line", "one\ntwo", []string{"one", "two"}},
+		{"crlf", "one\r\ntwo\r\n", []string{"one", "two"}},
+		{"empty crlf lines", "a\r\n\r\nb", []string{"a", "", "b"}},
+		{"bare crlf", "\r\n", []string{""}},
+		{"progress", "10%\r50%\r100%\ndone\n", []string{"10%", "50%", "100%", "done"}},
+		{"binary", "a\xff\xfeb\n", []string{`a\xff\xfeb`}},
+		{"long line", long + "\n", []string{strings.Repeat("a", maxLogLineLen-1), "üb"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Arrange
+			// reading byte by byte splits \r\n and multi-byte characters
+			r := iotest.OneByteReader(strings.NewReader(test.output))
+			got := make([]string, 0)
+
+			// Act
+			err := readLog(r, func(data string) {
+				got = append(got, data)
+			})
+
+			// Assert
+			if err != nil {
+				t.Fatalf("readLog failed: %q", err)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("Expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestReadLogError(t *testing.T) {
+	// Arrange
+	readErr := errors.New("read failed")
+	r := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(readErr))
+	got := make([]string, 0)
+
+	// Act
+	err := readLog(r, func(data string) {
+		got = append(got, data)
+	})
+
+	// Assert
+	if !errors.Is(err, readErr) {
+		t.Errorf("Expected read error, got %v", err)
+	}
+	if !slices.Equal(got, []string{"partial"}) {
+		t.Errorf("Expected output before the error to be kept, got %q", got)
+	}
+}
+
+func TestExecuteCommandFullLog(t *testing.T) {
+	// Arrange
+	logFiles, err := storage.NewDirLogFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDirLogFileStore failed: %q", err)
+	}
+	st := &mockStorage{
+		commands: []entity.Command{{Name: "Long", Command: "long"}},
+		settings: entity.Settings{LogCompress: true},
+	}
+	cs := NewCommandService(st, nil, commander, nil, logFiles, nil)
+
+	// Act
+	execID, err := cs.ExecuteCommand(context.Background(), user1, "0", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+	cs.WaitExecutions(context.Background())
+
+	// Assert
+	exec, err := cs.GetExecution(context.Background(), user1, execID)
+	if err != nil {
+		t.Fatalf("GetExecution failed: %q", err)
+	}
+	// 1500 lines of stdout and one of stderr
+	log := exec.Log
+	if len(log) != maxLogLen+2+logTailLen {
+		t.Fatalf("Expected %d log entries, got %d", maxLogLen+2+logTailLen, len(log))
+	}
+	if log[maxLogLen].Stream != "system" || log[maxLogLen+1].Data != "301 lines omitted, they are contained in the full log" {
+		t.Errorf("Expected truncation notes, got %q and %q", log[maxLogLen].Data, log[maxLogLen+1].Data)
+	}
+	if log[len(log)-1].Data != "line 1500" {
+		t.Errorf("Expected log to end with the last line, got %q", log[len(log)-1].Data)
+	}
+
+	full, err := cs.OpenExecutionLog(context.Background(), user1, execID)
+	if err != nil {
+		t.Fatalf("OpenExecutionLog failed: %q", err)
+	}
+	defer full.Close()
+	data, err := io.ReadAll(full)
+	if err != nil {
+		t.Fatalf("Reading full log failed: %q", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 1501 || !slices.Contains(lines, "line 1200") {
+		t.Errorf("Expected full log to contain all 1501 lines, got %d", len(lines))
+	}
+}
+
+func TestExecuteCommandFullLogLimit(t *testing.T) {
+	// Arrange
+	logFiles, err := storage.NewDirLogFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDirLogFileStore failed: %q", err)
+	}
+	st := &mockStorage{
+		commands: []entity.Command{{Name: "Long", Command: "long"}},
+		settings: entity.Settings{LogMaxSize: 1024},
+	}
+	cs := NewCommandService(st, nil, commander, nil, logFiles, nil)
+
+	// Act
+	execID, err := cs.ExecuteCommand(context.Background(), user1, "0", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+	cs.WaitExecutions(context.Background())
+
+	// Assert
+	full, err := cs.OpenExecutionLog(context.Background(), user1, execID)
+	if err != nil {
+		t.Fatalf("OpenExecutionLog failed: %q", err)
+	}
+	defer full.Close()
+	data, err := io.ReadAll(full)
+	if err != nil {
+		t.Fatalf("Reading full log failed: %q", err)
+	}
+	if len(data) > 1024+100 || !strings.HasSuffix(string(data), "log file size limit reached, further output is discarded\n") {
+		t.Errorf("Expected log file to be limited, got %d bytes ending in %q", len(data), data[max(len(data)-80, 0):])
+	}
+}
+
+func TestOpenExecutionLogStale(t *testing.T) {
+	// Arrange
+	logFiles, err := storage.NewDirLogFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDirLogFileStore failed: %q", err)
+	}
+	// log files of the executions of an earlier run with the same IDs
+	for execId := range 2 {
+		w, err := logFiles.Create(execId)
+		if err != nil {
+			t.Fatalf("Create failed: %q", err)
+		}
+		io.WriteString(w, "secret output\n")
+		w.Close()
+	}
+	err = logFiles.Compress(1)
+	if err != nil {
+		t.Fatalf("Compress failed: %q", err)
+	}
+	st := &mockStorage{
+		commands: []entity.Command{
+			{Id: "0", Name: "Restore", Command: "ok", RequiresApproval: &entity.Approval{Role: "dba"}},
+			{Id: "1", Name: "Workflow", Steps: []entity.WorkflowStep{{Command: "2"}}},
+			{Id: "2", Name: "Ok", Command: "ok"},
+		},
+	}
+	cs := NewCommandService(st, nil, &mockCommander{}, nil, logFiles, nil)
+	user := entity.User{Username: "alice", Roles: []string{"dba"}}
+
+	// Act
+	pending, err := cs.ExecuteCommand(context.Background(), user, "0", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+	workflow, err := cs.ExecuteCommand(context.Background(), user, "1", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+	cs.WaitExecutions(context.Background())
+
+	// Assert
+	for _, execId := range []int{pending, workflow} {
+		_, err = cs.OpenExecutionLog(context.Background(), user, execId)
+		if !errors.Is(err, storage.LogFileNotFoundError) {
+			t.Errorf("Expected LogFileNotFoundError for execution %d, got %v", execId, err)
+		}
+		_, err = logFiles.Open(execId)
+		if !errors.Is(err, storage.LogFileNotFoundError) {
+			t.Errorf("Expected the stale log file of execution %d to be removed, got %v", execId, err)
+		}
+	}
+}
+
+func TestExecuteCommandTiming(t *testing.T) {
+	// Arrange
+	cs := NewCommandService(mockSt, nil, commander, nil, nil, nil)
+	before := time.Now()
+
+	// Act
+	execID, err := cs.ExecuteCommand(context.Background(), user1, "0", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+	cs.WaitExecutions(context.Background())
+
+	// Assert
+	exec, err := cs.GetExecution(context.Background(), user1, execID)
+	if err != nil {
+		t.Fatalf("GetExecution failed: %q", err)
+	}
+	if exec.StartTime == nil || exec.StartTime.Before(before) {
+		t.Errorf("Expected start time after %v, got %v", before, exec.StartTime)
+	}
+	if duration, ok := exec.Duration(); !ok || duration < 0 {
+		t.Errorf("Expected duration of finished execution, got %v", exec.EndTime)
+	}
+	for _, entry := range exec.Log {
+		if entry.Time.Before(*exec.StartTime) || entry.Time.After(*exec.EndTime) {
+			t.Errorf("Expected log time between start and end, got %v", entry.Time)
+		}
+	}
+	history, err := cs.GetExecutionHistory(context.Background(), user1, entity.ExecutionFilter{})
+	if err != nil {
+		t.Fatalf("GetExecutionHistory failed: %q", err)
+	}
+	if history[len(history)-1].Duration == nil {
+		t.Errorf("Expected duration in history entry")
+	}
+}
+
+type mockNotificationService struct {
+	mu     sync.Mutex
+	events []entity.NotificationEvent
+}
+
+func (m *mockNotificationService) Notify(ctx context.Context, event entity.NotificationEvent, execution entity.CommandExecution) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, event)
+}
+
+func (m *mockNotificationService) GetDeliveries(ctx context.Context, user entity.User) ([]entity.WebhookDelivery, error) {
+	return nil, nil
+}
+
+func TestExecuteCommandNotifications(t *testing.T) {
+	timeout := entity.Duration(10 * time.Millisecond)
+	testCases := []struct {
+		name    string
+		command entity.Command
+		events  []entity.NotificationEvent
+	}{
+		{"succeeded", entity.Command{Name: "Ok", Command: "ok"}, []entity.NotificationEvent{entity.NotificationEventStarted, entity.NotificationEventSucceeded}},
+		{"failed", entity.Command{Name: "Fail", Command: "fail"}, []entity.NotificationEvent{entity.NotificationEventStarted, entity.NotificationEventFailed}},
+		{"timed out", entity.Command{Name: "Blocking", Command: "block", Timeout: &timeout}, []entity.NotificationEvent{entity.NotificationEventStarted, entity.NotificationEventTimedOut}},
+		{"not started", entity.Command{Name: "Other user", Command: "ok", RunAs: &entity.RunAs{User: "nobody"}}, []entity.NotificationEvent{entity.NotificationEventFailed}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			st := &mockStorage{
+				commands: []entity.Command{tc.command},
+				settings: entity.Settings{CancelGracePeriod: entity.Duration(10 * time.Millisecond)},
+			}
+			notifications := &mockNotificationService{}
+			cs := NewCommandService(st, nil, &mockCommander{}, nil, nil, notifications)
+
+			// Act
+			_, err := cs.ExecuteCommand(context.Background(), user1, "0", nil, trigger)
+			if err != nil {
+				t.Fatalf("ExecuteCommand failed: %q", err)
+			}
+			cs.WaitExecutions(context.Background())
+
+			// Assert
+			if !slices.Equal(notifications.events, tc.events) {
+				t.Errorf("Expected events %v, got %v", tc.events, notifications.events)
+			}
+		})
+	}
+}
+
+func TestExecuteWorkflow(t *testing.T) {
+	testCases := []struct {
+		name     string
+		steps    []entity.WorkflowStep
+		exitCode int
+		children []string
+	}{
+		{"succeeded", []entity.WorkflowStep{{Command: "0"}, {Parallel: []string{"0", "2"}}}, 0, []string{"Ok", "Ok", "Cleanup"}},
+		{"stop", []entity.WorkflowStep{{Command: "1"}, {Command: "0"}}, 1, []string{"Failing"}},
+		{"continue", []entity.WorkflowStep{{Command: "1", OnFailure: entity.FailurePolicyContinue}, {Command: "0"}}, 0, []string{"Failing", "Ok"}},
+		{"cleanup", []entity.WorkflowStep{{Command: "1", OnFailure: entity.FailurePolicyCleanup, Cleanup: "2"}, {Command: "0"}}, 1, []string{"Failing", "Cleanup"}},
+		{"parallel failure", []entity.WorkflowStep{{Parallel: []string{"0", "1"}}, {Command: "0"}}, 1, []string{"Ok", "Failing"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			st := &mockStorage{
+				commands: []entity.Command{
+					{Id: "0", Name: "Ok", Command: "ok", Parameters: []entity.CommandParameter{
+						{Name: "version", Type: entity.ParameterTypeString, Default: &defaultVersion},
+					}},
+					{Id: "1", Name: "Failing", Command: "fail"},
+					{Id: "2", Name: "Cleanup", Command: "ok"},
+					{Id: "3", Name: "Workflow", Steps: tc.steps, Parameters: []entity.CommandParameter{
+						{Name: "version", Type: entity.ParameterTypeString},
+					}},
+				},
+			}
+			cs := NewCommandService(st, nil, &mockCommander{}, nil, nil, nil)
+
+			// Act
+			execID, err := cs.ExecuteCommand(context.Background(), user1, "3", map[string]string{"version": "v2"}, trigger)
+			if err != nil {
+				t.Fatalf("ExecuteCommand failed: %q", err)
+			}
+			cs.WaitExecutions(context.Background())
+
+			// Assert
+			exec, err := cs.GetExecution(context.Background(), user1, execID)
+			if err != nil {
+				t.Fatalf("Got error %q when getting execution", err)
+			}
+			if exec.ExitCode == nil || *exec.ExitCode != tc.exitCode {
+				t.Errorf("Expected exit code %d, got %v", tc.exitCode, exec.ExitCode)
+			}
+			steps, err := cs.GetExecutionHistory(context.Background(), user1, entity.ExecutionFilter{ParentId: &execID})
+			if err != nil {
+				t.Fatalf("GetExecutionHistory failed: %q", err)
+			}
+			names := make([]string, 0, len(steps))
+			for _, step := range steps {
+				names = append(names, step.CommandName)
+				if step.ParentId == nil || *step.ParentId != execID {
+					t.Errorf("Expected step to reference workflow %d, got %v", execID, step.ParentId)
+				}
+			}
+			if !slices.Equal(names, tc.children) {
+				t.Errorf("Expected steps %v, got %v", tc.children, names)
+			}
+			first, err := cs.GetExecution(context.Background(), user1, steps[0].ExecId)
+			if err != nil {
+				t.Fatalf("Got error %q when getting execution", err)
+			}
+			if first.CommandName == "Ok" && !slices.Equal(first.Parameters, []entity.ParameterValue{{Name: "version", Value: "v2"}}) {
+				t.Errorf("Expected workflow parameter to be passed to the step, got %v", first.Parameters)
+			}
+		})
+	}
+}
+
+func TestCancelWorkflow(t *testing.T) {
+	// Arrange
+	st := &mockStorage{
+		commands: []entity.Command{
+			{Id: "0", Name: "Blocking", Command: "block"},
+			{Id: "1", Name: "Ok", Command: "ok"},
+			{Id: "2", Name: "Workflow", Steps: []entity.WorkflowStep{
+				{Command: "0", OnFailure: entity.FailurePolicyCleanup, Cleanup: "1"},
+				{Command: "1"},
+			}},
+		},
+		settings: entity.Settings{CancelGracePeriod: entity.Duration(time.Millisecond)},
+	}
+	cs := NewCommandService(st, nil, &mockCommander{}, nil, nil, nil)
+	user := entity.User{Username: "alice"}
+	execID, err := cs.ExecuteCommand(context.Background(), user, "2", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		steps, _ := cs.GetExecutionHistory(context.Background(), user, entity.ExecutionFilter{ParentId: &execID})
+		if len(steps) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected first step to be started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Act
+	err = cs.CancelExecution(context.Background(), user, execID)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("CancelExecution failed: %q", err)
+	}
+	cs.WaitExecutions(context.Background())
+	exec, err := cs.GetExecution(context.Background(), user, execID)
+	if err != nil {
+		t.Fatalf("Got error %q when getting execution", err)
+	}
+	if exec.Status != entity.ExecutionStatusCancelled || exec.CancelledBy == nil || *exec.CancelledBy != "alice" {
+		t.Errorf("Expected workflow to be cancelled by alice, got %q by %v", exec.Status, exec.CancelledBy)
+	}
+	steps, err := cs.GetExecutionHistory(context.Background(), user, entity.ExecutionFilter{ParentId: &execID})
+	if err != nil {
+		t.Fatalf("GetExecutionHistory failed: %q", err)
+	}
+	if len(steps) != 1 || steps[0].Status != entity.ExecutionStatusCancelled {
+		t.Errorf("Expected only the cancelled first step without cleanup, got %+v", steps)
+	}
+}
+
+func TestExecuteCommandApproval(t *testing.T) {
+	// Arrange
+	st := &mockStorage{
+		commands: []entity.Command{{Id: "0", Name: "Restore", Command: "ok", RequiresApproval: &entity.Approval{Role: "dba"}}},
+	}
+	audit := &mockAuditService{}
+	cs := NewCommandService(st, nil, &mockCommander{}, audit, nil, nil)
+	alice := entity.User{Username: "alice", Roles: []string{"dba"}}
+	bob := entity.User{Username: "bob", Roles: []string{"dba"}}
+	carol := entity.User{Username: "carol"}
+	execID, err := cs.ExecuteCommand(context.Background(), alice, "0", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+	pendingExec := waitForStatus(t, cs, execID, entity.ExecutionStatusPendingApproval)
+	ownPending, _ := cs.GetPendingApprovals(context.Background(), alice)
+	bobPending, _ := cs.GetPendingApprovals(context.Background(), bob)
+
+	// Act
+	selfErr := cs.DecideApproval(context.Background(), alice, execID, true, "")
+	unauthorizedErr := cs.DecideApproval(context.Background(), carol, execID, true, "")
+	err = cs.DecideApproval(context.Background(), bob, execID, true, "checked the backup")
+	cs.WaitExecutions(context.Background())
+	againErr := cs.DecideApproval(context.Background(), bob, execID, false, "")
+
+	// Assert
+	if err != nil {
+		t.Fatalf("DecideApproval failed: %q", err)
+	}
+	if pendingExec.ExitCode != nil || pendingExec.Approval == nil || pendingExec.Approval.Role != "dba" {
+		t.Errorf("Expected execution to wait for approval by dba, got %+v", pendingExec)
+	}
+	if len(ownPending) != 0 || len(bobPending) != 1 || bobPending[0].ExecId != execID {
+		t.Errorf("Expected request to be pending for bob only, got %v and %v", ownPending, bobPending)
+	}
+	if !errors.Is(selfErr, SelfApprovalError) {
+		t.Errorf("Expected SelfApprovalError, got %v", selfErr)
+	}
+	if !errors.Is(unauthorizedErr, UnauthorizedError) {
+		t.Errorf("Expected UnauthorizedError, got %v", unauthorizedErr)
+	}
+	if !errors.Is(againErr, ApprovalNotPendingError) {
+		t.Errorf("Expected ApprovalNotPendingError, got %v", againErr)
+	}
+	exec, err := cs.GetExecution(context.Background(), alice, execID)
+	if err != nil {
+		t.Fatalf("Got error %q when getting execution", err)
+	}
+	if exec.ExitCode == nil || *exec.ExitCode != 0 || exec.Status != "" || exec.StartTime == nil {
+		t.Errorf("Expected approved execution to run, got %+v", exec)
+	}
+	approval := exec.Approval
+	if approval == nil || approval.Decision != entity.ApprovalDecisionApproved || approval.DecidedBy != "bob" || approval.Comment != "checked the backup" || approval.DecisionTime == nil {
+		t.Errorf("Expected approval by bob to be recorded, got %+v", approval)
+	}
+	if len(audit.events) != 2 || audit.events[1].Type != entity.AuditEventApproval || audit.events[1].User != "bob" || audit.events[1].Details["decision"] != "approved" {
+		t.Errorf("Expected request and approval to be audited, got %v", audit.events)
+	}
+}
+
+func TestExecuteCommandNotApproved(t *testing.T) {
+	expiry := entity.Duration(10 * time.Millisecond)
+	testCases := []struct {
+		name     string
+		expiry   *entity.Duration
+		decide   func(cs *CommandService, execID int) error
+		status   entity.ExecutionStatus
+		decision entity.ApprovalDecision
+	}{
+		{"rejected", nil, func(cs *CommandService, execID int) error {
+			return cs.DecideApproval(context.Background(), entity.User{Username: "bob", Roles: []string{"dba"}}, execID, false, "not now")
+		}, entity.ExecutionStatusRejected, entity.ApprovalDecisionRejected},
+		{"cancelled", nil, func(cs *CommandService, execID int) error {
+			return cs.CancelExecution(context.Background(), entity.User{Username: "alice"}, execID)
+		}, entity.ExecutionStatusCancelled, ""},
+		{"expired", &expiry, func(cs *CommandService, execID int) error {
+			return nil
+		}, entity.ExecutionStatusExpired, entity.ApprovalDecisionExpired},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			commander := &mockCommander{}
+			st := &mockStorage{
+				commands: []entity.Command{{Id: "0", Name: "Restore", Command: "ok", RequiresApproval: &entity.Approval{Role: "dba", Expiry: tc.expiry}}},
+			}
+			notifications := &mockNotificationService{}
+			cs := NewCommandService(st, nil, commander, nil, nil, notifications)
+			execID, err := cs.ExecuteCommand(context.Background(), entity.User{Username: "alice"}, "0", nil, trigger)
+			if err != nil {
+				t.Fatalf("ExecuteCommand failed: %q", err)
+			}
+
+			// Act
+			err = tc.decide(cs, execID)
+
+			// Assert
+			if err != nil {
+				t.Fatalf("Decision failed: %q", err)
+			}
+			exec := waitForStatus(t, cs, execID, tc.status)
+			if exec.ExitCode == nil || exec.StartTime != nil || commander.lastOpts.ExecId != 0 {
+				t.Errorf("Expected execution to finish without starting, got %+v", exec)
+			}
+			if exec.Approval == nil || exec.Approval.Decision != tc.decision {
+				t.Errorf("Expected decision %q, got %+v", tc.decision, exec.Approval)
+			}
+			pending, _ := cs.GetPendingApprovals(context.Background(), entity.User{Username: "bob", Roles: []string{"dba"}})
+			if len(pending) != 0 {
+				t.Errorf("Expected no pending requests, got %v", pending)
+			}
+			if !slices.Equal(notifications.events, []entity.NotificationEvent{entity.NotificationEventCancelled}) {
+				t.Errorf("Expected cancelled notification, got %v", notifications.events)
+			}
+		})
+	}
+}
+
+func TestWorkflowStepApproval(t *testing.T) {
+	// Arrange
+	st := &mockStorage{
+		commands: []entity.Command{
+			{Id: "0", Name: "Restore", Command: "ok", RequiresApproval: &entity.Approval{Role: "dba"}},
+			{Id: "1", Name: "Workflow", Steps: []entity.WorkflowStep{{Command: "0"}}},
+		},
+	}
+	cs := NewCommandService(st, nil, &mockCommander{}, nil, nil, nil)
+	bob := entity.User{Username: "bob", Roles: []string{"dba"}}
+	execID, err := cs.ExecuteCommand(context.Background(), user1, "1", nil, trigger)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %q", err)
+	}
+	var pending []entity.CommandExecution
+	deadline := time.Now().Add(time.Second)
+	for len(pending) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected step to wait for approval")
+		}
+		time.Sleep(time.Millisecond)
+		pending, _ = cs.GetPendingApprovals(context.Background(), bob)
+	}
+
+	// Act
+	err = cs.DecideApproval(context.Background(), bob, pending[0].ExecId, true, "")
+	cs.WaitExecutions(context.Background())
+
+	// Assert
+	if err != nil {
+		t.Fatalf("DecideApproval failed: %q", err)
+	}
+	exec, err := cs.GetExecution(context.Background(), user1, execID)
+	if err != nil {
+		t.Fatalf("Got error %q when getting execution", err)
+	}
+	if exec.ExitCode == nil || *exec.ExitCode != 0 {
+		t.Errorf("Expected workflow to succeed after approval of its step, got %v", exec.ExitCode)
+	}
+}
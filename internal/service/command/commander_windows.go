@@ -3,23 +3,85 @@
 package command
 
 import (
+	"errors"
+	"os"
 	"os/exec"
+	"strconv"
 	"syscall"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
 )
 
 type Commander interface {
-	Command(command string) Command
+	Command(command string, opts ExecOptions) (Command, error)
 }
 
+var RunAsUnsupportedError = errors.New("run_as is not supported on Windows, run the server as the user the commands should run as instead")
+var LimitsUnsupportedError = errors.New("limits are not supported on Windows")
+var TtyUnsupportedError = errors.New("tty is not supported on Windows")
+
 type execCommander struct{}
 
-func (rc *execCommander) Command(command string) Command {
+func (rc *execCommander) Command(command string, opts ExecOptions) (Command, error) {
+	if opts.RunAs != nil {
+		return nil, RunAsUnsupportedError
+	}
+	if opts.Limits != nil {
+		return nil, LimitsUnsupportedError
+	}
+	if opts.Tty {
+		return nil, TtyUnsupportedError
+	}
+	// cmd.exe has no safe way to pass positional arguments, so parameters are
+	// only available as environment variables
 	cmd := exec.Command("cmd", "/c", command)
+	cmd.Env = commandEnv(os.Environ(), opts)
+	cmd.Dir = opts.Dir
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP,
 	}
 
 	return &execCommand{
-		cmd: cmd,
+		cmd:    cmd,
+		finish: processUsage,
+	}, nil
+}
+
+// processUsage returns the CPU time of the process, the peak memory is not
+// measured on Windows.
+func processUsage(state *os.ProcessState) entity.ResourceUsage {
+	if state == nil {
+		return entity.ResourceUsage{}
+	}
+	cpuTime := state.UserTime() + state.SystemTime()
+	return entity.ResourceUsage{CpuTime: &cpuTime}
+}
+
+// checkCommand rejects the features that are not supported on Windows.
+func checkCommand(command entity.Command) error {
+	if command.RunAs != nil {
+		return RunAsUnsupportedError
+	}
+	if command.Limits != nil {
+		return LimitsUnsupportedError
+	}
+	if command.Tty {
+		return TtyUnsupportedError
+	}
+	return nil
+}
+
+// Terminate kills the process, as there is no equivalent of SIGTERM for
+// processes without a console window.
+func (e *execCommand) Terminate() error {
+	return e.Kill()
+}
+
+// Kill stops the whole process tree, since killing only cmd.exe would leave its
+// children running.
+func (e *execCommand) Kill() error {
+	if e.cmd.Process == nil {
+		return errors.New("command not started")
 	}
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(e.cmd.Process.Pid)).Run()
 }
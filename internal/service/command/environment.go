@@ -0,0 +1,91 @@
+package command
+
+import (
+	"fmt"
+	"maps"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+)
+
+// commandExecOptions combines the environment, working directory and user of
+// the command with the global defaults and the parameter values.
+func commandExecOptions(command *entity.Command, settings entity.Settings, values []entity.ParameterValue) ExecOptions {
+	opts := ExecOptions{
+		InheritEnv:   command.InheritEnv,
+		Dir:          command.Workdir,
+		RunAs:        command.RunAs,
+		Limits:       command.Limits,
+		CgroupParent: settings.CgroupParent,
+		Tty:          command.Tty,
+	}
+	if opts.InheritEnv == nil {
+		opts.InheritEnv = settings.InheritEnv
+	}
+	if opts.Dir == "" {
+		opts.Dir = settings.Workdir
+	}
+
+	env := maps.Clone(settings.Env)
+	if env == nil {
+		env = make(map[string]string)
+	}
+	maps.Copy(env, command.Env)
+	for _, name := range slices.Sorted(maps.Keys(env)) {
+		opts.Env = append(opts.Env, name+"="+env[name])
+	}
+
+	// parameters come last, so they can not be overridden by the config
+	params := parameterExecOptions(values)
+	opts.Args = params.Args
+	opts.Env = append(opts.Env, params.Env...)
+	return opts
+}
+
+// commandEnv returns the environment of a command: the variables of environ
+// allowed by opts.InheritEnv followed by opts.Env. If opts.InheritEnv is nil,
+// the whole environment is inherited.
+func commandEnv(environ []string, opts ExecOptions) []string {
+	env := make([]string, 0, len(environ)+len(opts.Env))
+	for _, v := range environ {
+		name, _, _ := strings.Cut(v, "=")
+		if opts.InheritEnv == nil || inheritAllowed(opts.InheritEnv, name) {
+			env = append(env, v)
+		}
+	}
+	return append(env, opts.Env...)
+}
+
+// inheritAllowed reports whether a variable is in the allow-list. Entries
+// ending in * match all variables with that prefix. Variable names are case
+// insensitive on Windows.
+func inheritAllowed(allowed []string, name string) bool {
+	equal := func(a, b string) bool { return a == b }
+	if runtime.GOOS == "windows" {
+		equal = strings.EqualFold
+	}
+	for _, pattern := range allowed {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if len(name) >= len(prefix) && equal(name[:len(prefix)], prefix) {
+				return true
+			}
+		} else if equal(name, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckCommands verifies the parts of the commands that depend on the system,
+// e.g. that the users of run_as can be switched to.
+func CheckCommands(commands []entity.Command) error {
+	for _, command := range commands {
+		err := checkCommand(command)
+		if err != nil {
+			return fmt.Errorf("command %q: %w", command.Name, err)
+		}
+	}
+	return nil
+}
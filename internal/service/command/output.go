@@ -0,0 +1,205 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+	"github.com/jrammler/wheelhouse/internal/storage"
+)
+
+// maxLogLineLen is the length in bytes at which long lines are split into
+// several log entries.
+const maxLogLineLen = 16 * 1024
+
+// readLog splits the output of a command into the data of log entries until r
+// returns EOF. Lines end with \n, \r\n or a single \r, so every update of a
+// progress indicator is kept. An unterminated last line is emitted as well.
+func readLog(r io.Reader, emit func(data string)) error {
+	buf := make([]byte, 32*1024)
+	line := make([]byte, 0, 256)
+	// crEnded is set if the previous byte was a \r that ended a line, so a
+	// following \n does not end another one
+	crEnded := false
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			ended := false
+			switch {
+			case b == '\n' && crEnded:
+				// second half of \r\n
+			case b == '\n':
+				emit(logData(line))
+				line = line[:0]
+			case b == '\r':
+				// an empty line is only emitted by the \n of \r\n, so
+				// repeated \r do not add empty lines
+				if len(line) > 0 {
+					emit(logData(line))
+					line = line[:0]
+					ended = true
+				}
+			default:
+				line = append(line, b)
+				if len(line) > maxLogLineLen {
+					cut := splitPoint(line, maxLogLineLen)
+					emit(logData(line[:cut]))
+					line = line[:copy(line, line[cut:])]
+				}
+			}
+			crEnded = ended
+		}
+		if err != nil {
+			if len(line) > 0 {
+				emit(logData(line))
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// splitPoint returns the largest index up to max at which data can be split
+// without splitting a UTF-8 encoded character.
+func splitPoint(data []byte, max int) int {
+	for i := max; i > max-utf8.UTFMax && i > 0; i-- {
+		if utf8.RuneStart(data[i]) {
+			return i
+		}
+	}
+	return max
+}
+
+// logData converts output to a string that is valid UTF-8. Invalid bytes are
+// escaped like \xff, so binary output is kept without breaking the storage.
+func logData(data []byte) string {
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	var b strings.Builder
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			fmt.Fprintf(&b, "\\x%02x", data[0])
+		} else {
+			b.Write(data[:size])
+		}
+		data = data[size:]
+	}
+	return b.String()
+}
+
+// logTailLen is the number of lines at the end of a truncated log that are
+// kept in the history in addition to the first maxLogLen lines.
+const logTailLen = 200
+
+// fullLog writes the complete output of an execution to its log file, up to
+// the configured size.
+type fullLog struct {
+	w       io.WriteCloser
+	created bool
+	maxSize int64
+	size    int64
+}
+
+// createFullLog creates the log file of an execution. Failures are only
+// logged, the execution runs without a log file then.
+func (s *CommandService) createFullLog(execId int, settings entity.Settings) *fullLog {
+	l := &fullLog{maxSize: int64(settings.LogMaxSize)}
+	if s.logFiles == nil {
+		return l
+	}
+	w, err := s.logFiles.Create(execId)
+	if err != nil {
+		slog.Error("Failed to create log file", "exec_id", execId, "error", err)
+		return l
+	}
+	l.w = w
+	l.created = true
+	return l
+}
+
+// write appends a log entry to the file. Once the size limit is reached, a
+// note is written instead and the remaining output is discarded.
+func (l *fullLog) write(entry entity.LogEntry) {
+	if l.w == nil {
+		return
+	}
+	line := entry.Data + "\n"
+	if l.maxSize > 0 && l.size+int64(len(line)) > l.maxSize {
+		io.WriteString(l.w, "log file size limit reached, further output is discarded\n")
+		l.close()
+		return
+	}
+	n, err := io.WriteString(l.w, line)
+	l.size += int64(n)
+	if err != nil {
+		slog.Error("Failed to write log file", "error", err)
+		l.close()
+	}
+}
+
+func (l *fullLog) close() {
+	if l.w == nil {
+		return
+	}
+	err := l.w.Close()
+	if err != nil {
+		slog.Error("Failed to close log file", "error", err)
+	}
+	l.w = nil
+}
+
+func (l *fullLog) omittedMessage(omitted int) string {
+	if l.created {
+		return fmt.Sprintf("%d lines omitted, they are contained in the full log", omitted)
+	}
+	return fmt.Sprintf("%d lines omitted", omitted)
+}
+
+func (s *CommandService) compressLog(execId int) {
+	err := s.logFiles.Compress(execId)
+	if err != nil {
+		slog.Error("Failed to compress log file", "exec_id", execId, "error", err)
+	}
+}
+
+// removeStaleLog deletes the log file left behind by an earlier execution with
+// the same ID, as the IDs of the in-memory history start again after a
+// restart.
+func (s *CommandService) removeStaleLog(execId int) {
+	if s.logFiles == nil {
+		return
+	}
+	err := s.logFiles.Remove(execId)
+	if err != nil {
+		slog.Error("Failed to remove stale log file", "exec_id", execId, "error", err)
+	}
+}
+
+// OpenExecutionLog returns the complete output of an execution from its log
+// file. Executions that did not run a process have none.
+func (s *CommandService) OpenExecutionLog(ctx context.Context, user entity.User, execId int) (io.ReadCloser, error) {
+	// checks that the user may access the execution
+	execution, err := s.GetExecution(ctx, user, execId)
+	if err != nil {
+		return nil, err
+	}
+	if s.logFiles == nil || execution.StartTime == nil {
+		return nil, storage.LogFileNotFoundError
+	}
+	steps, err := s.executions.GetExecutions(ctx, entity.ExecutionFilter{ParentId: &execId, Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(steps) > 0 {
+		return nil, storage.LogFileNotFoundError
+	}
+	return s.logFiles.Open(execId)
+}
@@ -0,0 +1,109 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+)
+
+var InvalidParameterError = errors.New("Invalid parameter value")
+
+const maskedValue = "********"
+
+// resolveParameters validates the provided values against the parameters
+// declared by the command and returns the values in declaration order, with
+// defaults applied for missing values.
+func resolveParameters(command *entity.Command, values map[string]string) ([]entity.ParameterValue, error) {
+	for name := range values {
+		if !slices.ContainsFunc(command.Parameters, func(p entity.CommandParameter) bool { return p.Name == name }) {
+			return nil, fmt.Errorf("%w: unknown parameter %q", InvalidParameterError, name)
+		}
+	}
+
+	resolved := make([]entity.ParameterValue, 0, len(command.Parameters))
+	for _, param := range command.Parameters {
+		value, ok := values[param.Name]
+		if !ok || (value == "" && param.Default == nil) {
+			if param.Default == nil {
+				return nil, fmt.Errorf("%w: parameter %q is required", InvalidParameterError, param.Name)
+			}
+			value = *param.Default
+		}
+		value, err := validateParameter(param, value)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, entity.ParameterValue{Name: param.Name, Value: value})
+	}
+	return resolved, nil
+}
+
+func validateParameter(param entity.CommandParameter, value string) (string, error) {
+	switch param.Type {
+	case entity.ParameterTypeString, entity.ParameterTypeSecret, "":
+	case entity.ParameterTypeInt:
+		num, err := strconv.Atoi(value)
+		if err != nil {
+			return "", fmt.Errorf("%w: parameter %q must be an integer", InvalidParameterError, param.Name)
+		}
+		value = strconv.Itoa(num)
+	case entity.ParameterTypeChoice:
+		if !slices.Contains(param.Choices, value) {
+			return "", fmt.Errorf("%w: parameter %q must be one of %s", InvalidParameterError, param.Name, strings.Join(param.Choices, ", "))
+		}
+	case entity.ParameterTypeBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("%w: parameter %q must be a boolean", InvalidParameterError, param.Name)
+		}
+		value = strconv.FormatBool(b)
+	default:
+		return "", fmt.Errorf("%w: parameter %q has unknown type %q", InvalidParameterError, param.Name, param.Type)
+	}
+
+	if param.Pattern != "" {
+		re, err := regexp.Compile("^(?:" + param.Pattern + ")$")
+		if err != nil {
+			return "", fmt.Errorf("%w: parameter %q has invalid pattern: %w", InvalidParameterError, param.Name, err)
+		}
+		if !re.MatchString(value) {
+			return "", fmt.Errorf("%w: parameter %q does not match pattern %q", InvalidParameterError, param.Name, param.Pattern)
+		}
+	}
+	return value, nil
+}
+
+// ParameterEnvName returns the name of the environment variable a parameter
+// value is passed in.
+func ParameterEnvName(name string) string {
+	return "PARAM_" + strings.ToUpper(name)
+}
+
+// parameterExecOptions passes the parameter values as environment variables and
+// positional arguments, so they never become part of the shell command string.
+func parameterExecOptions(values []entity.ParameterValue) ExecOptions {
+	opts := ExecOptions{}
+	for _, v := range values {
+		opts.Args = append(opts.Args, v.Value)
+		opts.Env = append(opts.Env, ParameterEnvName(v.Name)+"="+v.Value)
+	}
+	return opts
+}
+
+// maskParameters returns a copy of the values with secrets masked, suitable
+// for recording on the execution.
+func maskParameters(command *entity.Command, values []entity.ParameterValue) []entity.ParameterValue {
+	masked := make([]entity.ParameterValue, len(values))
+	for i, v := range values {
+		masked[i] = v
+		if command.Parameters[i].Type == entity.ParameterTypeSecret {
+			masked[i].Value = maskedValue
+		}
+	}
+	return masked
+}
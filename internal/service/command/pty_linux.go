@@ -0,0 +1,125 @@
+//go:build linux
+
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ptySize is the terminal size reported to commands running in tty mode.
+var ptySize = unix.Winsize{Row: 40, Col: 120}
+
+// openPty creates a pseudo-terminal and returns its master and slave side.
+func openPty() (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := master.SyscallConn()
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	var ptyNum uint32
+	var ioctlErr error
+	err = conn.Control(func(fd uintptr) {
+		ioctlErr = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0)
+		if ioctlErr == nil {
+			ptyNum, ioctlErr = unix.IoctlGetUint32(int(fd), unix.TIOCGPTN)
+		}
+	})
+	if err == nil {
+		err = ioctlErr
+	}
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to set up pseudo-terminal: %w", err)
+	}
+
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", ptyNum), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	err = unix.IoctlSetWinsize(int(slave.Fd()), unix.TIOCSWINSZ, &ptySize)
+	if err != nil {
+		master.Close()
+		slave.Close()
+		return nil, nil, fmt.Errorf("failed to set terminal size: %w", err)
+	}
+	return master, slave, nil
+}
+
+// ptyCommand is a command whose stdout and stderr are connected to a
+// pseudo-terminal, so tools behave like in an interactive shell. Both streams
+// are read from the master side as stdout.
+type ptyCommand struct {
+	*execCommand
+	master *os.File
+	slave  *os.File
+}
+
+func (p *ptyCommand) Start() error {
+	err := p.execCommand.Start()
+	// the command has its own copy of the slave, closing ours lets reads of
+	// the master end once the command exited
+	p.slave.Close()
+	if err != nil {
+		p.master.Close()
+	}
+	return err
+}
+
+func (p *ptyCommand) Release() {
+	p.execCommand.Release()
+	p.slave.Close()
+	p.master.Close()
+}
+
+// Wait waits for the command to exit. Reads of the master only end once all
+// slave descriptors are closed, which background processes of the command
+// may keep open, so the master is closed after outputWaitDelay like the pipes
+// of other commands.
+func (p *ptyCommand) Wait() error {
+	err := p.execCommand.Wait()
+	time.AfterFunc(outputWaitDelay, func() { p.master.Close() })
+	return err
+}
+
+func (p *ptyCommand) StdoutPipe() (io.ReadCloser, error) {
+	return &ptyReader{master: p.master}, nil
+}
+
+func (p *ptyCommand) StderrPipe() (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+// ptyReader reads the output of a command from the master side. Linux reports
+// EIO once all slave descriptors are closed, which is turned into EOF like
+// reads of the master after it was closed by Wait.
+type ptyReader struct {
+	master *os.File
+}
+
+func (r *ptyReader) Read(b []byte) (int, error) {
+	n, err := r.master.Read(b)
+	if errors.Is(err, syscall.EIO) || errors.Is(err, os.ErrClosed) {
+		err = io.EOF
+	}
+	if err == io.EOF {
+		r.master.Close()
+	}
+	return n, err
+}
+
+func (r *ptyReader) Close() error {
+	return r.master.Close()
+}
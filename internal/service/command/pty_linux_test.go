@@ -0,0 +1,53 @@
+//go:build linux
+
+package command
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPtyCommandBackgroundChild(t *testing.T) {
+	// Arrange
+	// the child ignores the SIGHUP sent when the shell exits, like daemons do
+	cmd, err := (&execCommander{}).Command(`sh -c 'trap "" HUP; exec sleep 30' & echo $!; sleep 0.2`, ExecOptions{Tty: true})
+	if err != nil {
+		t.Skipf("Pseudo-terminals are not available: %q", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe failed: %q", err)
+	}
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(stdout)
+		output <- string(data)
+	}()
+
+	// Act
+	err = cmd.Start()
+	if err != nil {
+		t.Fatalf("Start failed: %q", err)
+	}
+	err = cmd.Wait()
+	if err != nil {
+		t.Fatalf("Wait failed: %q", err)
+	}
+
+	// Assert
+	var data string
+	select {
+	case data = <-output:
+	case <-time.After(outputWaitDelay + 5*time.Second):
+		t.Fatalf("Expected the output to end after the command exited")
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(data))
+	if err != nil {
+		t.Fatalf("Expected the pid of the background child, got %q", data)
+	}
+	syscall.Kill(pid, syscall.SIGKILL)
+}
@@ -0,0 +1,99 @@
+package command
+
+import (
+	"context"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+)
+
+// SubscribeExecution streams the log of an execution, starting with the entry
+// at index start. The events carry the start time of the execution once it
+// started, the final event contains the finished execution. The channel is
+// closed after the final event or once ctx is done.
+func (s *CommandService) SubscribeExecution(ctx context.Context, user entity.User, execId int, start int) (<-chan entity.ExecutionEvent, error) {
+	execution, err := s.GetExecution(ctx, user, execId)
+	if err != nil {
+		return nil, err
+	}
+	startTime := execution.StartTime
+
+	events := make(chan entity.ExecutionEvent)
+	go func() {
+		defer close(events)
+		send := func(event entity.ExecutionEvent) bool {
+			select {
+			case events <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		next := max(start, 0)
+		for {
+			// fetch the change notification before reading the log, so no
+			// entry appended in between is missed
+			changed := s.changedChan(execId)
+
+			log, err := s.executions.GetLog(ctx, execId, next)
+			if err != nil {
+				return
+			}
+			// queued executions start later, so their start time is looked
+			// up again after changes until they did
+			if startTime == nil && len(log) > 0 {
+				execution, err := s.executions.GetExecution(ctx, execId)
+				if err != nil {
+					return
+				}
+				startTime = execution.StartTime
+			}
+			for _, entry := range log {
+				if !send(entity.ExecutionEvent{Index: next, Log: &entry, StartTime: startTime}) {
+					return
+				}
+				next += 1
+			}
+
+			if changed == nil {
+				execution, err := s.executions.GetExecution(ctx, execId)
+				if err != nil {
+					return
+				}
+				execution.Log = nil
+				send(entity.ExecutionEvent{Index: next, StartTime: execution.StartTime, Execution: execution})
+				return
+			}
+
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// changedChan returns a channel that is closed on the next change of the
+// execution, or nil if the execution is not running.
+func (s *CommandService) changedChan(execId int) <-chan any {
+	s.runningMutex.Lock()
+	defer s.runningMutex.Unlock()
+	run, ok := s.running[execId]
+	if !ok {
+		return nil
+	}
+	return run.changed
+}
+
+func (s *CommandService) notifySubscribers(execId int) {
+	s.runningMutex.Lock()
+	defer s.runningMutex.Unlock()
+	run, ok := s.running[execId]
+	if !ok {
+		return
+	}
+	close(run.changed)
+	run.changed = make(chan any)
+}
@@ -0,0 +1,232 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+)
+
+// workflowText describes the steps of a workflow, it is recorded in place of
+// the command string.
+func workflowText(command *entity.Command) string {
+	lines := make([]string, 0, len(command.Steps))
+	for i, step := range command.Steps {
+		line := fmt.Sprintf("%d. %s", i+1, strings.Join(step.Commands(), " & "))
+		switch step.OnFailure {
+		case entity.FailurePolicyContinue:
+			line += " (continue on failure)"
+		case entity.FailurePolicyCleanup:
+			line += fmt.Sprintf(" (on failure: %s)", step.Cleanup)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// startWorkflow creates the execution of a workflow and runs its steps in the
+// background. The workflow execution itself does not run a process, so it is
+// not subject to the concurrency limits, its steps are.
+func (s *CommandService) startWorkflow(ctx context.Context, command *entity.Command, execution *entity.CommandExecution, paramValues []entity.ParameterValue) (int, error) {
+	startTime := time.Now()
+	execution.StartTime = &startTime
+	err := s.createExecution(ctx, execution)
+	if err != nil {
+		return 0, err
+	}
+	s.recordAudit(ctx, executionAuditEvent(execution))
+
+	run := &runningExecution{
+		done:     make(chan any),
+		changed:  make(chan any),
+		workflow: true,
+	}
+	s.runningMutex.Lock()
+	s.running[execution.ExecId] = run
+	s.runningMutex.Unlock()
+	s.beginWorkflow(command, execution, run, paramValues)
+	return execution.ExecId, nil
+}
+
+// beginWorkflow runs the steps of a stored workflow execution in the
+// background.
+func (s *CommandService) beginWorkflow(command *entity.Command, execution *entity.CommandExecution, run *runningExecution, paramValues []entity.ParameterValue) {
+	s.execWaitGroup.Add(1)
+	params := make(map[string]string, len(paramValues))
+	for _, param := range paramValues {
+		params[param.Name] = param.Value
+	}
+	slog.Info("Executing workflow", "exec_id", execution.ExecId, "command_id", command.Id)
+	s.sendNotification(entity.NotificationEventStarted, execution)
+	go s.runWorkflow(*command, execution, run, params)
+}
+
+func (s *CommandService) runWorkflow(command entity.Command, execution *entity.CommandExecution, run *runningExecution, params map[string]string) {
+	failed := false
+	for i, step := range command.Steps {
+		if s.workflowCancelled(run) {
+			break
+		}
+		if s.runStep(execution, run, fmt.Sprintf("step %d", i+1), step.Commands(), params) {
+			continue
+		}
+		if step.OnFailure == entity.FailurePolicyContinue {
+			s.workflowLog(execution.ExecId, "step %d failed, continuing", i+1)
+			continue
+		}
+		failed = true
+		if step.OnFailure == entity.FailurePolicyCleanup && !s.workflowCancelled(run) {
+			s.runStep(execution, run, fmt.Sprintf("cleanup of step %d", i+1), []string{step.Cleanup}, params)
+		}
+		s.workflowLog(execution.ExecId, "step %d failed, stopping", i+1)
+		break
+	}
+
+	s.runningMutex.Lock()
+	run.exited = true
+	cancelledBy := run.cancelledBy
+	s.runningMutex.Unlock()
+
+	exitCode := 0
+	if cancelledBy != nil {
+		s.workflowLog(execution.ExecId, "execution cancelled by %s", *cancelledBy)
+		exitCode = -1
+		execution.Status = entity.ExecutionStatusCancelled
+		execution.CancelledBy = cancelledBy
+	} else if failed {
+		exitCode = 1
+	}
+	execution.ExitCode = &exitCode
+	s.finishExecution(execution, run)
+	slog.Info("Executing workflow completed", "exec_id", execution.ExecId)
+}
+
+// runStep executes the commands of a step at the same time and waits for them
+// to finish. It reports whether all of them succeeded.
+func (s *CommandService) runStep(parent *entity.CommandExecution, run *runningExecution, name string, commandIds []string, params map[string]string) bool {
+	succeeded := true
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, commandId := range commandIds {
+		execId, err := s.executeStep(parent, commandId, params)
+		if err != nil {
+			s.workflowLog(parent.ExecId, "%s: %s could not be started: %s", name, commandId, err)
+			succeeded = false
+			continue
+		}
+		s.workflowLog(parent.ExecId, "%s: %s started as execution %d", name, commandId, execId)
+
+		s.runningMutex.Lock()
+		run.children = append(run.children, execId)
+		cancelledBy := run.cancelledBy
+		s.runningMutex.Unlock()
+		if cancelledBy != nil {
+			// the workflow was cancelled while the step was started
+			s.cancelStep(execId, *cancelledBy)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			child := s.waitExecution(execId)
+			if child == nil {
+				mu.Lock()
+				succeeded = false
+				mu.Unlock()
+				return
+			}
+			state := entity.ExecutionState(child.ExitCode, child.Status)
+			s.workflowLog(parent.ExecId, "%s: %s %s", name, commandId, state)
+			if child.Status != "" || child.ExitCode == nil || *child.ExitCode != 0 {
+				mu.Lock()
+				succeeded = false
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return succeeded
+}
+
+// executeStep starts an execution of a command as a step of a workflow
+// execution. The step gets the values of the workflow parameters with the
+// same names as its own parameters, the others get their defaults.
+func (s *CommandService) executeStep(parent *entity.CommandExecution, commandId string, params map[string]string) (int, error) {
+	ctx := context.Background()
+	command, err := s.storage.GetCommandById(ctx, commandId)
+	if err != nil {
+		return 0, err
+	}
+	if command == nil {
+		return 0, CommandNotFoundError
+	}
+	stepParams := make(map[string]string)
+	for _, param := range command.Parameters {
+		if value, ok := params[param.Name]; ok {
+			stepParams[param.Name] = value
+		}
+	}
+	paramValues, err := resolveParameters(command, stepParams)
+	if err != nil {
+		return 0, err
+	}
+	parentId := parent.ExecId
+	execution := entity.CommandExecution{
+		CommandId:   command.Id,
+		CommandName: command.Name,
+		CommandText: command.Command,
+		CommandRole: command.Role,
+		User:        parent.User,
+		Trigger:     parent.Trigger,
+		ExecTime:    time.Now(),
+		Parameters:  maskParameters(command, paramValues),
+		ParentId:    &parentId,
+	}
+	if command.RequiresApproval != nil {
+		return s.requestApproval(ctx, command, &execution, paramValues)
+	}
+	return s.admit(ctx, command, &execution, paramValues)
+}
+
+// waitExecution waits until an execution finished and returns its final
+// state, nil if it can not be read.
+func (s *CommandService) waitExecution(execId int) *entity.CommandExecution {
+	s.runningMutex.Lock()
+	run, ok := s.running[execId]
+	s.runningMutex.Unlock()
+	if ok {
+		<-run.done
+	}
+	execution, err := s.executions.GetExecution(context.Background(), execId)
+	if err != nil {
+		slog.Error("Failed to read step execution", "exec_id", execId, "error", err)
+		return nil
+	}
+	return execution
+}
+
+func (s *CommandService) workflowCancelled(run *runningExecution) bool {
+	s.runningMutex.Lock()
+	defer s.runningMutex.Unlock()
+	return run.cancelledBy != nil
+}
+
+// cancelStep cancels a step of a cancelled workflow on behalf of the user who
+// cancelled the workflow.
+func (s *CommandService) cancelStep(execId int, username string) {
+	err := s.CancelExecution(context.Background(), entity.User{Username: username, System: true}, execId)
+	if err != nil && err != ExecutionNotRunningError {
+		slog.Error("Failed to cancel workflow step", "exec_id", execId, "error", err)
+	}
+}
+
+func (s *CommandService) workflowLog(execId int, format string, args ...any) {
+	s.appendLog(execId, entity.LogEntry{
+		Stream: "system",
+		Data:   fmt.Sprintf(format, args...),
+	})
+}
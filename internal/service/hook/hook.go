@@ -0,0 +1,226 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+	"github.com/jrammler/wheelhouse/internal/service"
+	"github.com/jrammler/wheelhouse/internal/storage"
+)
+
+var TriggerNotFoundError = errors.New("Webhook trigger not found")
+var InvalidSignatureError = errors.New("Missing or invalid webhook signature")
+var ExpiredRequestError = errors.New("Webhook timestamp is outside of the allowed window")
+var ReplayError = errors.New("Webhook request was already received")
+var GithubSignatureDisabledError = errors.New("GitHub style signatures are not enabled for this webhook trigger")
+var InvalidPayloadError = errors.New("Invalid webhook payload")
+
+const (
+	// maxClockSkew is how far the timestamp of a request may differ from the
+	// current time.
+	maxClockSkew = 5 * time.Minute
+	// signatureRetention is how long the signatures of accepted requests are
+	// remembered to reject replays. Later replays have an expired timestamp.
+	signatureRetention = 2 * maxClockSkew
+	// hubSignatureRetention is how long the signatures of accepted GitHub
+	// style requests are remembered, they do not expire on their own.
+	hubSignatureRetention = 24 * time.Hour
+)
+
+type HookService struct {
+	storage  storage.Storage
+	commands service.CommandService
+	mu       sync.Mutex
+	// seen holds the keys of accepted requests and until when they are
+	// remembered.
+	seen map[string]time.Time
+	now  func() time.Time
+}
+
+func NewHookService(sto storage.Storage, commands service.CommandService) *HookService {
+	return &HookService{
+		storage:  sto,
+		commands: commands,
+		seen:     make(map[string]time.Time),
+		now:      time.Now,
+	}
+}
+
+// TriggerWebhook verifies a request to a webhook trigger and executes its
+// command, returning the execution ID.
+func (s *HookService) TriggerWebhook(ctx context.Context, name string, req entity.WebhookRequest) (int, error) {
+	settings, err := s.storage.GetSettings(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var trigger *entity.WebhookTrigger
+	for i := range settings.Triggers {
+		if settings.Triggers[i].Name == name {
+			trigger = &settings.Triggers[i]
+			break
+		}
+	}
+	if trigger == nil {
+		return 0, TriggerNotFoundError
+	}
+
+	key, retention, err := s.verify(trigger, req)
+	if err != nil {
+		return 0, err
+	}
+	params, err := extractParameters(trigger.Parameters, req.Body)
+	if err != nil {
+		return 0, err
+	}
+	key = trigger.Name + ":" + key
+	err = s.markSeen(key, retention)
+	if err != nil {
+		slog.Warn("Webhook request replayed", "trigger", trigger.Name, "delivery", req.Delivery, "source_ip", req.SourceIp)
+		return 0, err
+	}
+
+	user := entity.User{Username: "webhook:" + trigger.Name, System: true}
+	execId, err := s.commands.ExecuteCommand(ctx, user, trigger.Command, params, entity.Trigger{
+		Type:      entity.TriggerTypeWebhook,
+		Source:    trigger.Name,
+		SourceIp:  req.SourceIp,
+		UserAgent: req.UserAgent,
+	})
+	if err != nil {
+		// only requests that started an execution are remembered, so the
+		// sender may retry the others
+		s.forget(key)
+		return 0, err
+	}
+	return execId, nil
+}
+
+// verify checks the signature of a request. It returns the key identifying
+// the request to reject replays and how long the key has to be remembered.
+// The generic signature covers the timestamp, so it takes precedence over the
+// GitHub style one.
+func (s *HookService) verify(trigger *entity.WebhookTrigger, req entity.WebhookRequest) (string, time.Duration, error) {
+	if req.Signature != "" {
+		timestamp, err := strconv.ParseInt(req.Timestamp, 10, 64)
+		if err != nil {
+			return "", 0, InvalidSignatureError
+		}
+		data := append([]byte(req.Timestamp+"."), req.Body...)
+		if !hmac.Equal([]byte(req.Signature), []byte(sign(trigger.Secret, data))) {
+			return "", 0, InvalidSignatureError
+		}
+		skew := s.now().Sub(time.Unix(timestamp, 0))
+		if skew > maxClockSkew || skew < -maxClockSkew {
+			return "", 0, ExpiredRequestError
+		}
+		return "signature:" + req.Signature, signatureRetention, nil
+	}
+	if req.HubSignature == "" {
+		return "", 0, InvalidSignatureError
+	}
+	if !trigger.GithubSignature {
+		return "", 0, GithubSignatureDisabledError
+	}
+	if !hmac.Equal([]byte(req.HubSignature), []byte(sign(trigger.Secret, req.Body))) {
+		return "", 0, InvalidSignatureError
+	}
+	// the delivery ID is not signed, so only the signature identifies the
+	// request
+	return "signature:" + req.HubSignature, hubSignatureRetention, nil
+}
+
+// sign returns the hex encoded HMAC-SHA256 of data prefixed with "sha256=".
+func sign(secret string, data []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(data)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// markSeen records the key of an accepted request for the retention and fails
+// if it was already seen. Expired keys are removed.
+func (s *HookService) markSeen(key string, retention time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for k, expiry := range s.seen {
+		if now.After(expiry) {
+			delete(s.seen, k)
+		}
+	}
+	if _, ok := s.seen[key]; ok {
+		return ReplayError
+	}
+	s.seen[key] = now.Add(retention)
+	return nil
+}
+
+func (s *HookService) forget(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.seen, key)
+}
+
+// extractParameters takes the parameter values from the fields of the JSON
+// payload. Missing fields leave the parameter unset, so its default applies.
+func extractParameters(fields map[string]string, body []byte) (map[string]string, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var payload any
+	err := decoder.Decode(&payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", InvalidPayloadError, err)
+	}
+	params := make(map[string]string)
+	for name, path := range fields {
+		value, ok := lookup(payload, path)
+		if !ok {
+			continue
+		}
+		switch value := value.(type) {
+		case string:
+			params[name] = value
+		case json.Number:
+			params[name] = value.String()
+		case bool:
+			params[name] = strconv.FormatBool(value)
+		default:
+			return nil, fmt.Errorf("%w: field %q is not a string, number or boolean", InvalidPayloadError, path)
+		}
+	}
+	return params, nil
+}
+
+// lookup returns the value at a dot separated path in a decoded JSON value,
+// false if it does not exist or is null.
+func lookup(value any, path string) (any, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]any:
+			value = v[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, value != nil
+}
@@ -0,0 +1,253 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+)
+
+type mockStorage struct {
+	settings entity.Settings
+}
+
+func (m *mockStorage) GetCommands(ctx context.Context) ([]entity.Command, error) {
+	return nil, errors.New("not supported")
+}
+
+func (m *mockStorage) GetCommandById(ctx context.Context, id string) (*entity.Command, error) {
+	return nil, errors.New("not supported")
+}
+
+func (m *mockStorage) GetUser(ctx context.Context, username string) (entity.User, error) {
+	return entity.User{}, errors.New("not supported")
+}
+
+func (m *mockStorage) GetSettings(ctx context.Context) (entity.Settings, error) {
+	return m.settings, nil
+}
+
+func (m *mockStorage) LoadConfig() error {
+	return nil
+}
+
+type execution struct {
+	user    entity.User
+	id      string
+	params  map[string]string
+	trigger entity.Trigger
+}
+
+type mockCommandService struct {
+	executions []execution
+	err        error
+}
+
+func (m *mockCommandService) GetCommands(ctx context.Context, user entity.User) ([]entity.Command, error) {
+	return nil, nil
+}
+
+func (m *mockCommandService) ExecuteCommand(ctx context.Context, user entity.User, id string, params map[string]string, trigger entity.Trigger) (int, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	m.executions = append(m.executions, execution{user, id, params, trigger})
+	return len(m.executions), nil
+}
+
+func (m *mockCommandService) GetExecutionHistory(ctx context.Context, user entity.User, filter entity.ExecutionFilter) ([]entity.ExecutionHistoryEntry, error) {
+	return nil, nil
+}
+
+func (m *mockCommandService) GetExecution(ctx context.Context, user entity.User, execId int) (*entity.CommandExecution, error) {
+	return nil, nil
+}
+
+func (m *mockCommandService) CancelExecution(ctx context.Context, user entity.User, execId int) error {
+	return nil
+}
+
+func (m *mockCommandService) SubscribeExecution(ctx context.Context, user entity.User, execId int, start int) (<-chan entity.ExecutionEvent, error) {
+	return nil, nil
+}
+
+func (m *mockCommandService) OpenExecutionLog(ctx context.Context, user entity.User, execId int) (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (m *mockCommandService) GetPendingApprovals(ctx context.Context, user entity.User) ([]entity.CommandExecution, error) {
+	return nil, nil
+}
+
+func (m *mockCommandService) DecideApproval(ctx context.Context, user entity.User, execId int, approved bool, comment string) error {
+	return nil
+}
+
+func (m *mockCommandService) WaitExecutions(ctx context.Context) {}
+
+var now = time.Unix(1700000000, 0)
+
+func newHookService(commands *mockCommandService) *HookService {
+	st := &mockStorage{settings: entity.Settings{Triggers: []entity.WebhookTrigger{
+		{
+			Name:            "push",
+			Command:         "deploy",
+			Secret:          "s3cret",
+			Parameters:      map[string]string{"repo": "repository.name", "commit": "commits.0.id", "forced": "forced", "size": "size"},
+			GithubSignature: true,
+		},
+		{Name: "generic", Command: "deploy", Secret: "s3cret"},
+	}}}
+	s := NewHookService(st, commands)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func signedRequest(body string, timestamp time.Time) entity.WebhookRequest {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	return entity.WebhookRequest{
+		Body:      []byte(body),
+		Signature: sign("s3cret", []byte(ts+"."+body)),
+		Timestamp: ts,
+		SourceIp:  "192.0.2.1",
+	}
+}
+
+const pushBody = `{"repository": {"name": "app"}, "commits": [{"id": "abc"}], "forced": false, "size": 3, "pusher": null}`
+
+func TestTriggerWebhook(t *testing.T) {
+	// Arrange
+	commands := &mockCommandService{}
+	hookService := newHookService(commands)
+	body := pushBody
+
+	// Act
+	execId, err := hookService.TriggerWebhook(context.Background(), "push", entity.WebhookRequest{
+		Body:         []byte(body),
+		HubSignature: sign("s3cret", []byte(body)),
+	})
+
+	// Assert
+	if err != nil {
+		t.Fatalf("TriggerWebhook failed: %q", err)
+	}
+	if execId != 1 || len(commands.executions) != 1 {
+		t.Fatalf("Expected one execution, got %v", commands.executions)
+	}
+	exec := commands.executions[0]
+	if exec.id != "deploy" || !exec.user.System || exec.user.Username != "webhook:push" {
+		t.Errorf("Expected deploy to be executed by the webhook, got %+v", exec)
+	}
+	expected := map[string]string{"repo": "app", "commit": "abc", "forced": "false", "size": "3"}
+	if !maps.Equal(exec.params, expected) {
+		t.Errorf("Expected parameters %v, got %v", expected, exec.params)
+	}
+	if exec.trigger.Type != entity.TriggerTypeWebhook || exec.trigger.Source != "push" {
+		t.Errorf("Expected webhook trigger push, got %+v", exec.trigger)
+	}
+}
+
+func TestTriggerWebhookRejected(t *testing.T) {
+	testCases := []struct {
+		name    string
+		trigger string
+		req     entity.WebhookRequest
+		err     error
+	}{
+		{"unknown trigger", "other", signedRequest(pushBody, now), TriggerNotFoundError},
+		{"unsigned", "push", entity.WebhookRequest{Body: []byte(pushBody)}, InvalidSignatureError},
+		{"github signature not enabled", "generic", entity.WebhookRequest{Body: []byte(pushBody), HubSignature: sign("s3cret", []byte(pushBody))}, GithubSignatureDisabledError},
+		{"wrong secret", "push", entity.WebhookRequest{Body: []byte(pushBody), HubSignature: sign("other", []byte(pushBody))}, InvalidSignatureError},
+		{"modified body", "push", func() entity.WebhookRequest {
+			req := signedRequest(pushBody, now)
+			req.Body = []byte(`{}`)
+			return req
+		}(), InvalidSignatureError},
+		{"missing timestamp", "push", func() entity.WebhookRequest {
+			req := signedRequest(pushBody, now)
+			req.Timestamp = ""
+			return req
+		}(), InvalidSignatureError},
+		{"old timestamp", "push", signedRequest(pushBody, now.Add(-10*time.Minute)), ExpiredRequestError},
+		{"invalid payload", "push", signedRequest(`not json`, now), InvalidPayloadError},
+		{"object field", "push", signedRequest(`{"repository": {"name": {"full": "app"}}}`, now), InvalidPayloadError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			commands := &mockCommandService{}
+			hookService := newHookService(commands)
+
+			// Act
+			_, err := hookService.TriggerWebhook(context.Background(), tc.trigger, tc.req)
+
+			// Assert
+			if !errors.Is(err, tc.err) {
+				t.Errorf("Expected %v, got %v", tc.err, err)
+			}
+			if len(commands.executions) != 0 {
+				t.Errorf("Expected no execution, got %v", commands.executions)
+			}
+		})
+	}
+}
+
+func TestTriggerWebhookReplay(t *testing.T) {
+	// Arrange
+	commands := &mockCommandService{err: errors.New("busy")}
+	hookService := newHookService(commands)
+	req := signedRequest(pushBody, now)
+
+	// Act
+	_, failedErr := hookService.TriggerWebhook(context.Background(), "push", req)
+	commands.err = nil
+	_, retryErr := hookService.TriggerWebhook(context.Background(), "push", req)
+	_, replayErr := hookService.TriggerWebhook(context.Background(), "push", req)
+	now := now.Add(signatureRetention + time.Hour)
+	hookService.now = func() time.Time { return now }
+	_, expiredErr := hookService.TriggerWebhook(context.Background(), "push", req)
+
+	// Assert
+	if failedErr == nil || retryErr != nil {
+		t.Errorf("Expected request to be accepted again after failed execution, got %v and %v", failedErr, retryErr)
+	}
+	if !errors.Is(replayErr, ReplayError) {
+		t.Errorf("Expected ReplayError, got %v", replayErr)
+	}
+	if !errors.Is(expiredErr, ExpiredRequestError) {
+		t.Errorf("Expected ExpiredRequestError, got %v", expiredErr)
+	}
+}
+
+func TestTriggerWebhookDeliveries(t *testing.T) {
+	// Arrange
+	commands := &mockCommandService{}
+	hookService := newHookService(commands)
+	delivery := func(id string) entity.WebhookRequest {
+		return entity.WebhookRequest{
+			Body:         []byte(pushBody),
+			HubSignature: sign("s3cret", []byte(pushBody)),
+			Delivery:     id,
+		}
+	}
+
+	// Act
+	_, firstErr := hookService.TriggerWebhook(context.Background(), "push", delivery("1"))
+	_, replayedErr := hookService.TriggerWebhook(context.Background(), "push", delivery("2"))
+
+	// Assert
+	if firstErr != nil {
+		t.Errorf("Expected the first delivery to be accepted, got %v", firstErr)
+	}
+	if !errors.Is(replayedErr, ReplayError) {
+		t.Errorf("Expected ReplayError for the signed body with a new delivery ID, got %v", replayedErr)
+	}
+	if len(commands.executions) != 1 {
+		t.Errorf("Expected 1 execution, got %d", len(commands.executions))
+	}
+}
@@ -0,0 +1,332 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/ansi"
+	"github.com/jrammler/wheelhouse/internal/entity"
+	"github.com/jrammler/wheelhouse/internal/storage"
+)
+
+var UnauthorizedError = errors.New("User is not authorized to view the webhook deliveries")
+
+const (
+	// maxDeliveries is the number of deliveries kept for display.
+	maxDeliveries   = 100
+	defaultLogLines = 20
+	maxAttempts     = 5
+	requestTimeout  = 10 * time.Second
+)
+
+// defaultRetryDelay is the delay before the first retry of a failed delivery,
+// it doubles with every further attempt.
+const defaultRetryDelay = 5 * time.Second
+
+// NotifyService sends notifications about executions to the configured
+// webhooks.
+type NotifyService struct {
+	storage    storage.Storage
+	executions storage.ExecutionStore
+	client     *http.Client
+	retryDelay time.Duration
+	// stopping is closed on shutdown to cancel the pending retries
+	stopping   chan any
+	stopOnce   sync.Once
+	wg         sync.WaitGroup
+	mu         sync.Mutex
+	deliveries []*entity.WebhookDelivery
+	nextId     int
+}
+
+func NewNotifyService(sto storage.Storage, executions storage.ExecutionStore, client *http.Client) *NotifyService {
+	if client == nil {
+		client = &http.Client{Timeout: requestTimeout}
+	}
+	return &NotifyService{
+		storage:    sto,
+		executions: executions,
+		client:     client,
+		retryDelay: defaultRetryDelay,
+		stopping:   make(chan any),
+		nextId:     1,
+	}
+}
+
+// payload is the body of a notification.
+type payload struct {
+	Event     entity.NotificationEvent `json:"event"`
+	Time      time.Time                `json:"time"`
+	Execution executionPayload         `json:"execution"`
+	// Log holds the end of the log of finished executions.
+	Log []logPayload `json:"log,omitempty"`
+}
+
+type executionPayload struct {
+	ExecId        int               `json:"exec_id"`
+	CommandId     string            `json:"command_id"`
+	CommandName   string            `json:"command_name"`
+	User          string            `json:"user"`
+	Trigger       string            `json:"trigger"`
+	TriggerSource string            `json:"trigger_source,omitempty"`
+	State         string            `json:"state"`
+	Time          time.Time         `json:"time"`
+	StartTime     *time.Time        `json:"start_time,omitempty"`
+	EndTime       *time.Time        `json:"end_time,omitempty"`
+	Duration      string            `json:"duration,omitempty"`
+	ExitCode      *int              `json:"exit_code"`
+	CancelledBy   *string           `json:"cancelled_by,omitempty"`
+	Parameters    map[string]string `json:"parameters"`
+}
+
+type logPayload struct {
+	Stream string `json:"stream"`
+	Data   string `json:"data"`
+}
+
+// Notify sends the event to the webhooks matching the execution. The requests
+// are sent in the background, failures are retried and recorded in the
+// deliveries.
+func (s *NotifyService) Notify(ctx context.Context, event entity.NotificationEvent, execution entity.CommandExecution) {
+	settings, err := s.storage.GetSettings(ctx)
+	if err != nil {
+		slog.Error("Failed to determine webhooks", "error", err)
+		return
+	}
+	hooks := make([]entity.Webhook, 0)
+	for _, hook := range settings.Webhooks {
+		if webhookMatches(hook, event, &execution) {
+			hooks = append(hooks, hook)
+		}
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	var log []entity.LogEntry
+	if event != entity.NotificationEventStarted && s.executions != nil {
+		log, err = s.executions.GetLog(ctx, execution.ExecId, 0)
+		if err != nil {
+			slog.Error("Failed to read log for notification", "exec_id", execution.ExecId, "error", err)
+		}
+	}
+	now := time.Now()
+	for _, hook := range hooks {
+		body, err := json.Marshal(newPayload(event, now, &execution, logTail(log, hook)))
+		if err != nil {
+			slog.Error("Failed to encode notification", "error", err)
+			return
+		}
+		delivery := s.addDelivery(entity.WebhookDelivery{
+			Webhook: hook.Name,
+			Event:   event,
+			ExecId:  execution.ExecId,
+			Time:    now,
+			Status:  entity.DeliveryStatusPending,
+		})
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.deliver(hook, body, delivery)
+		}()
+	}
+}
+
+func webhookMatches(hook entity.Webhook, event entity.NotificationEvent, execution *entity.CommandExecution) bool {
+	if len(hook.Events) > 0 && !slices.Contains(hook.Events, event) {
+		return false
+	}
+	if len(hook.Commands) > 0 && !slices.Contains(hook.Commands, execution.CommandId) {
+		return false
+	}
+	if len(hook.Roles) > 0 && (execution.CommandRole == nil || !slices.Contains(hook.Roles, *execution.CommandRole)) {
+		return false
+	}
+	return true
+}
+
+func logTail(log []entity.LogEntry, hook entity.Webhook) []entity.LogEntry {
+	n := defaultLogLines
+	if hook.LogLines != nil {
+		n = *hook.LogLines
+	}
+	if len(log) > n {
+		return log[len(log)-n:]
+	}
+	return log
+}
+
+func newPayload(event entity.NotificationEvent, now time.Time, execution *entity.CommandExecution, log []entity.LogEntry) payload {
+	params := make(map[string]string, len(execution.Parameters))
+	// secret values are already masked
+	for _, param := range execution.Parameters {
+		params[param.Name] = param.Value
+	}
+	p := payload{
+		Event: event,
+		Time:  now,
+		Execution: executionPayload{
+			ExecId:        execution.ExecId,
+			CommandId:     execution.CommandId,
+			CommandName:   execution.CommandName,
+			User:          execution.User,
+			Trigger:       string(execution.Trigger.Type),
+			TriggerSource: execution.Trigger.Source,
+			State:         entity.ExecutionState(execution.ExitCode, execution.Status),
+			Time:          execution.ExecTime,
+			StartTime:     execution.StartTime,
+			EndTime:       execution.EndTime,
+			ExitCode:      execution.ExitCode,
+			CancelledBy:   execution.CancelledBy,
+			Parameters:    params,
+		},
+	}
+	if duration, ok := execution.Duration(); ok {
+		p.Execution.Duration = duration.String()
+	}
+	for _, entry := range log {
+		p.Log = append(p.Log, logPayload{Stream: entry.Stream, Data: ansi.Strip(entry.Data)})
+	}
+	return p
+}
+
+// deliver sends the notification until the webhook accepts it or the
+// attempts are used up.
+func (s *NotifyService) deliver(hook entity.Webhook, body []byte, delivery *entity.WebhookDelivery) {
+	delay := s.retryDelay
+	for attempt := 1; ; attempt++ {
+		code, err := s.send(hook, body, delivery)
+		retry := err != nil || code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+		if err == nil && (code < 200 || code >= 300) {
+			err = fmt.Errorf("unexpected response status %d", code)
+		}
+
+		s.mu.Lock()
+		delivery.Attempts = attempt
+		delivery.ResponseCode = code
+		delivery.Error = ""
+		if err == nil {
+			delivery.Status = entity.DeliveryStatusDelivered
+		} else {
+			delivery.Error = err.Error()
+			if !retry || attempt == maxAttempts {
+				delivery.Status = entity.DeliveryStatusFailed
+			}
+		}
+		status := delivery.Status
+		s.mu.Unlock()
+
+		if status != entity.DeliveryStatusPending {
+			if status == entity.DeliveryStatusFailed {
+				slog.Warn("Webhook delivery failed", "webhook", hook.Name, "exec_id", delivery.ExecId, "attempts", attempt, "error", err)
+			}
+			return
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-s.stopping:
+			timer.Stop()
+			s.mu.Lock()
+			delivery.Status = entity.DeliveryStatusFailed
+			s.mu.Unlock()
+			slog.Warn("Webhook delivery cancelled by shutdown", "webhook", hook.Name, "exec_id", delivery.ExecId, "attempts", attempt, "error", err)
+			return
+		}
+		delay *= 2
+	}
+}
+
+// send posts the notification once and returns the response status.
+func (s *NotifyService) send(hook entity.Webhook, body []byte, delivery *entity.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	for name, value := range hook.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "wheelhouse")
+	req.Header.Set("X-Wheelhouse-Event", string(delivery.Event))
+	req.Header.Set("X-Wheelhouse-Delivery", strconv.Itoa(delivery.Id))
+	if hook.Secret != "" {
+		req.Header.Set("X-Wheelhouse-Signature-256", Sign(hook.Secret, body))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature header value of a body, the hex encoded
+// HMAC-SHA256 prefixed with "sha256=".
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *NotifyService) addDelivery(delivery entity.WebhookDelivery) *entity.WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delivery.Id = s.nextId
+	s.nextId += 1
+	if len(s.deliveries) == maxDeliveries {
+		s.deliveries = slices.Delete(s.deliveries, 0, 1)
+	}
+	s.deliveries = append(s.deliveries, &delivery)
+	return &delivery
+}
+
+// GetDeliveries returns the most recent deliveries, oldest first.
+func (s *NotifyService) GetDeliveries(ctx context.Context, user entity.User) ([]entity.WebhookDelivery, error) {
+	settings, err := s.storage.GetSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !settings.IsAdmin(user) {
+		return nil, UnauthorizedError
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deliveries := make([]entity.WebhookDelivery, len(s.deliveries))
+	for i, delivery := range s.deliveries {
+		deliveries[i] = *delivery
+	}
+	return deliveries, nil
+}
+
+// Shutdown cancels the pending retries and waits until the requests in
+// flight are finished or the context is cancelled.
+func (s *NotifyService) Shutdown(ctx context.Context) {
+	s.stopOnce.Do(func() { close(s.stopping) })
+	s.Wait(ctx)
+}
+
+// Wait blocks until all deliveries are finished or the context is cancelled.
+func (s *NotifyService) Wait(ctx context.Context) {
+	done := make(chan any)
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,203 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+	"github.com/jrammler/wheelhouse/internal/storage"
+)
+
+type mockStorage struct {
+	settings entity.Settings
+}
+
+func (m *mockStorage) GetCommands(ctx context.Context) ([]entity.Command, error) {
+	return nil, errors.New("not supported")
+}
+
+func (m *mockStorage) GetCommandById(ctx context.Context, id string) (*entity.Command, error) {
+	return nil, errors.New("not supported")
+}
+
+func (m *mockStorage) GetUser(ctx context.Context, username string) (entity.User, error) {
+	return entity.User{}, errors.New("not supported")
+}
+
+func (m *mockStorage) GetSettings(ctx context.Context) (entity.Settings, error) {
+	return m.settings, nil
+}
+
+func (m *mockStorage) LoadConfig() error {
+	return nil
+}
+
+// receiver records the requests sent to a test server, which answers with
+// the given status codes in order and 200 afterwards.
+type receiver struct {
+	mu       sync.Mutex
+	codes    []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rec *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.requests = append(rec.requests, r)
+	rec.bodies = append(rec.bodies, body)
+	code := http.StatusOK
+	if len(rec.codes) > 0 {
+		code, rec.codes = rec.codes[0], rec.codes[1:]
+	}
+	w.WriteHeader(code)
+}
+
+func newFinishedExecution(t *testing.T, executions storage.ExecutionStore, lines int) entity.CommandExecution {
+	t.Helper()
+	role := "deploy"
+	exitCode := 1
+	start := time.Now().Add(-time.Minute)
+	end := time.Now()
+	execution := entity.CommandExecution{
+		CommandId:   "cmd",
+		CommandName: "Deploy",
+		CommandRole: &role,
+		User:        "alice",
+		Trigger:     entity.Trigger{Type: entity.TriggerTypeWeb},
+		ExecTime:    start,
+		StartTime:   &start,
+		EndTime:     &end,
+		ExitCode:    &exitCode,
+		Parameters:  []entity.ParameterValue{{Name: "token", Value: "***"}},
+	}
+	err := executions.CreateExecution(context.Background(), &execution)
+	if err != nil {
+		t.Fatalf("CreateExecution failed: %q", err)
+	}
+	for i := range lines {
+		executions.AppendLog(context.Background(), execution.ExecId, entity.LogEntry{Stream: "stdout", Data: "\x1b[31mline\x1b[0m " + string(rune('a'+i))})
+	}
+	return execution
+}
+
+func TestNotify(t *testing.T) {
+	// Arrange
+	rec := &receiver{}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+	logLines := 2
+	st := &mockStorage{settings: entity.Settings{Webhooks: []entity.Webhook{
+		{
+			Name:     "chat",
+			Url:      server.URL,
+			Events:   []entity.NotificationEvent{entity.NotificationEventFailed},
+			Roles:    []string{"deploy"},
+			Headers:  map[string]string{"Authorization": "Bearer abc"},
+			Secret:   "s3cret",
+			LogLines: &logLines,
+		},
+		{Name: "other event", Url: server.URL, Events: []entity.NotificationEvent{entity.NotificationEventSucceeded}},
+		{Name: "other command", Url: server.URL, Commands: []string{"backup"}},
+	}}}
+	executions := storage.NewMemoryExecutionStore(10)
+	execution := newFinishedExecution(t, executions, 3)
+	notifyService := NewNotifyService(st, executions, nil)
+
+	// Act
+	notifyService.Notify(context.Background(), entity.NotificationEventFailed, execution)
+	notifyService.Wait(context.Background())
+
+	// Assert
+	if len(rec.requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(rec.requests))
+	}
+	req, body := rec.requests[0], rec.bodies[0]
+	if req.Header.Get("Authorization") != "Bearer abc" || req.Header.Get("X-Wheelhouse-Event") != "failed" {
+		t.Errorf("Unexpected headers: %v", req.Header)
+	}
+	if sig := req.Header.Get("X-Wheelhouse-Signature-256"); sig != Sign("s3cret", body) {
+		t.Errorf("Unexpected signature %q", sig)
+	}
+	var p payload
+	err := json.Unmarshal(body, &p)
+	if err != nil {
+		t.Fatalf("Invalid payload: %q", err)
+	}
+	if p.Event != entity.NotificationEventFailed || p.Execution.ExecId != execution.ExecId || p.Execution.State != "error" || p.Execution.Duration == "" {
+		t.Errorf("Unexpected payload: %+v", p)
+	}
+	if p.Execution.Parameters["token"] != "***" {
+		t.Errorf("Expected parameters in payload, got %v", p.Execution.Parameters)
+	}
+	if len(p.Log) != 2 || p.Log[0].Data != "line b" || p.Log[1].Data != "line c" {
+		t.Errorf("Expected the last two log lines without escape sequences, got %v", p.Log)
+	}
+	deliveries, _ := notifyService.GetDeliveries(context.Background(), entity.User{Roles: []string{"admin"}})
+	if len(deliveries) != 1 || deliveries[0].Status != entity.DeliveryStatusDelivered || deliveries[0].ResponseCode != http.StatusOK {
+		t.Errorf("Expected delivered notification, got %+v", deliveries)
+	}
+}
+
+func TestNotifyRetry(t *testing.T) {
+	testCases := []struct {
+		name     string
+		codes    []int
+		status   entity.DeliveryStatus
+		attempts int
+	}{
+		{"retried until delivered", []int{http.StatusBadGateway, http.StatusTooManyRequests}, entity.DeliveryStatusDelivered, 3},
+		{"client error is not retried", []int{http.StatusNotFound}, entity.DeliveryStatusFailed, 1},
+		{"attempts used up", []int{500, 500, 500, 500, 500}, entity.DeliveryStatusFailed, maxAttempts},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			rec := &receiver{codes: tc.codes}
+			server := httptest.NewServer(rec)
+			defer server.Close()
+			st := &mockStorage{settings: entity.Settings{Webhooks: []entity.Webhook{{Name: "hook", Url: server.URL}}}}
+			executions := storage.NewMemoryExecutionStore(10)
+			execution := newFinishedExecution(t, executions, 0)
+			notifyService := NewNotifyService(st, executions, nil)
+			notifyService.retryDelay = time.Millisecond
+
+			// Act
+			notifyService.Notify(context.Background(), entity.NotificationEventFailed, execution)
+			notifyService.Wait(context.Background())
+
+			// Assert
+			deliveries, _ := notifyService.GetDeliveries(context.Background(), entity.User{Roles: []string{"admin"}})
+			if len(deliveries) != 1 {
+				t.Fatalf("Expected 1 delivery, got %d", len(deliveries))
+			}
+			if deliveries[0].Status != tc.status || deliveries[0].Attempts != tc.attempts {
+				t.Errorf("Expected status %s after %d attempts, got %+v", tc.status, tc.attempts, deliveries[0])
+			}
+			if len(rec.requests) != tc.attempts {
+				t.Errorf("Expected %d requests, got %d", tc.attempts, len(rec.requests))
+			}
+		})
+	}
+}
+
+func TestGetDeliveriesUnauthorized(t *testing.T) {
+	// Arrange
+	notifyService := NewNotifyService(&mockStorage{}, storage.NewMemoryExecutionStore(10), nil)
+
+	// Act
+	_, err := notifyService.GetDeliveries(context.Background(), entity.User{Username: "bob", Roles: []string{"dev"}})
+
+	// Assert
+	if !errors.Is(err, UnauthorizedError) {
+		t.Errorf("Expected UnauthorizedError, got %v", err)
+	}
+}
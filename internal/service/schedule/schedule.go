@@ -0,0 +1,236 @@
+package schedule
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/cron"
+	"github.com/jrammler/wheelhouse/internal/entity"
+	"github.com/jrammler/wheelhouse/internal/service"
+	"github.com/jrammler/wheelhouse/internal/storage"
+)
+
+// schedulerUser is the principal scheduled executions are started as.
+var schedulerUser = entity.User{Username: "scheduler", System: true}
+
+type job struct {
+	command  entity.Command
+	schedule cron.Schedule
+	location *time.Location
+	next     time.Time
+	lastRun  *time.Time
+	lastExec *int
+	running  bool
+	queued   bool
+}
+
+// ScheduleService executes commands that have a schedule.
+type ScheduleService struct {
+	storage  storage.Storage
+	commands service.CommandService
+	jobs     map[string]*job
+	mu       sync.Mutex
+	reload   chan any
+	now      func() time.Time
+}
+
+func NewScheduleService(sto storage.Storage, commands service.CommandService) *ScheduleService {
+	return &ScheduleService{
+		storage:  sto,
+		commands: commands,
+		jobs:     make(map[string]*job),
+		reload:   make(chan any, 1),
+		now:      time.Now,
+	}
+}
+
+// Reload makes the scheduler pick up changed schedules after the config was
+// reloaded.
+func (s *ScheduleService) Reload() {
+	select {
+	case s.reload <- nil:
+	default:
+	}
+}
+
+// Run executes the scheduled commands until ctx is done.
+func (s *ScheduleService) Run(ctx context.Context) {
+	s.loadJobs(ctx)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		s.runDue(ctx)
+
+		var timerChan <-chan time.Time
+		wait, ok := s.untilNext()
+		if ok {
+			timer.Reset(wait)
+			timerChan = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.reload:
+			s.loadJobs(ctx)
+		case <-timerChan:
+		}
+	}
+}
+
+// loadJobs creates the jobs for the configured schedules. Existing jobs are
+// updated in place, so their state is kept.
+func (s *ScheduleService) loadJobs(ctx context.Context) {
+	commands, err := s.storage.GetCommands(ctx)
+	if err != nil {
+		slog.Error("Failed to load scheduled commands", "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	jobs := make(map[string]*job)
+	for _, command := range commands {
+		if command.Schedule == "" {
+			continue
+		}
+		old, ok := s.jobs[command.Id]
+		if ok && old.command.Schedule == command.Schedule && old.command.Timezone == command.Timezone {
+			// keep the next run time
+			old.command = command
+			jobs[command.Id] = old
+			continue
+		}
+
+		schedule, err := cron.Parse(command.Schedule)
+		if err != nil {
+			slog.Error("Invalid schedule", "command_id", command.Id, "error", err)
+			continue
+		}
+		location, err := time.LoadLocation(command.Timezone)
+		if err != nil {
+			slog.Error("Invalid timezone", "command_id", command.Id, "error", err)
+			continue
+		}
+		j := old
+		if !ok {
+			j = &job{}
+		}
+		j.command = command
+		j.schedule = schedule
+		j.location = location
+		j.next = schedule.Next(now.In(location))
+		jobs[command.Id] = j
+	}
+	s.jobs = jobs
+	slog.Info("Loaded schedules", "count", len(jobs))
+}
+
+// untilNext returns the time until the next job is due, or false if no job is
+// scheduled.
+func (s *ScheduleService) untilNext() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var next time.Time
+	for _, j := range s.jobs {
+		if !j.next.IsZero() && (next.IsZero() || j.next.Before(next)) {
+			next = j.next
+		}
+	}
+	if next.IsZero() {
+		return 0, false
+	}
+	return max(next.Sub(s.now()), 0), true
+}
+
+// runDue triggers all jobs that are due. Runs missed while the process was
+// suspended are executed only once.
+func (s *ScheduleService) runDue(ctx context.Context) {
+	s.mu.Lock()
+	now := s.now()
+	due := make([]*job, 0)
+	for _, j := range s.jobs {
+		if !j.next.IsZero() && !j.next.After(now) {
+			due = append(due, j)
+			j.next = j.schedule.Next(now.In(j.location))
+		}
+	}
+	s.mu.Unlock()
+
+	for _, j := range due {
+		s.trigger(ctx, j)
+	}
+}
+
+func (s *ScheduleService) trigger(ctx context.Context, j *job) {
+	s.mu.Lock()
+	if j.running {
+		if j.command.Overlap == entity.OverlapPolicyQueue && !j.queued {
+			slog.Info("Previous scheduled run still running, queueing", "command_id", j.command.Id)
+			j.queued = true
+		} else {
+			slog.Info("Previous scheduled run still running, skipping", "command_id", j.command.Id)
+		}
+		s.mu.Unlock()
+		return
+	}
+	j.running = true
+	now := s.now()
+	j.lastRun = &now
+	s.mu.Unlock()
+
+	execId, err := s.commands.ExecuteCommand(ctx, schedulerUser, j.command.Id, nil, entity.Trigger{Type: entity.TriggerTypeSchedule})
+	if err != nil {
+		slog.Error("Failed to execute scheduled command", "command_id", j.command.Id, "error", err)
+		s.mu.Lock()
+		j.running = false
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Lock()
+	j.lastExec = &execId
+	s.mu.Unlock()
+	go s.watch(ctx, j, execId)
+}
+
+// watch waits for a scheduled execution to finish and starts the queued run,
+// if there is one.
+func (s *ScheduleService) watch(ctx context.Context, j *job, execId int) {
+	// only the final event is of interest, so the log is skipped
+	events, err := s.commands.SubscribeExecution(ctx, schedulerUser, execId, math.MaxInt32)
+	if err == nil {
+		for range events {
+		}
+	}
+
+	s.mu.Lock()
+	j.running = false
+	queued := j.queued
+	j.queued = false
+	s.mu.Unlock()
+	if queued && ctx.Err() == nil {
+		s.trigger(ctx, j)
+	}
+}
+
+func (s *ScheduleService) GetSchedules(ctx context.Context) map[string]entity.CommandSchedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedules := make(map[string]entity.CommandSchedule, len(s.jobs))
+	for id, j := range s.jobs {
+		schedule := entity.CommandSchedule{
+			CommandId:  id,
+			LastRun:    j.lastRun,
+			LastExecId: j.lastExec,
+		}
+		if !j.next.IsZero() {
+			next := j.next
+			schedule.NextRun = &next
+		}
+		schedules[id] = schedule
+	}
+	return schedules
+}
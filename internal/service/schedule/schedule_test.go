@@ -0,0 +1,230 @@
+package schedule
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+	"github.com/jrammler/wheelhouse/internal/storage"
+)
+
+type mockStorage struct {
+	commands []entity.Command
+}
+
+func (m *mockStorage) GetCommands(ctx context.Context) ([]entity.Command, error) {
+	return m.commands, nil
+}
+
+func (m *mockStorage) GetCommandById(ctx context.Context, id string) (*entity.Command, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) GetUser(ctx context.Context, username string) (entity.User, error) {
+	return entity.User{}, storage.UserNotFoundError
+}
+
+func (m *mockStorage) GetSettings(ctx context.Context) (entity.Settings, error) {
+	return entity.Settings{}, nil
+}
+
+func (m *mockStorage) LoadConfig() error {
+	return nil
+}
+
+// mockCommandService records executions, which keep running until finish is
+// called.
+type mockCommandService struct {
+	mu       sync.Mutex
+	executed []string
+	users    []entity.User
+	running  map[int]chan entity.ExecutionEvent
+}
+
+func (m *mockCommandService) GetCommands(ctx context.Context, user entity.User) ([]entity.Command, error) {
+	return nil, nil
+}
+
+func (m *mockCommandService) ExecuteCommand(ctx context.Context, user entity.User, id string, params map[string]string, trigger entity.Trigger) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	execId := len(m.executed)
+	m.executed = append(m.executed, id)
+	m.users = append(m.users, user)
+	m.running[execId] = make(chan entity.ExecutionEvent)
+	return execId, nil
+}
+
+func (m *mockCommandService) GetExecutionHistory(ctx context.Context, user entity.User, filter entity.ExecutionFilter) ([]entity.ExecutionHistoryEntry, error) {
+	return nil, nil
+}
+
+func (m *mockCommandService) GetExecution(ctx context.Context, user entity.User, execId int) (*entity.CommandExecution, error) {
+	return nil, nil
+}
+
+func (m *mockCommandService) CancelExecution(ctx context.Context, user entity.User, execId int) error {
+	return nil
+}
+
+func (m *mockCommandService) SubscribeExecution(ctx context.Context, user entity.User, execId int, start int) (<-chan entity.ExecutionEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.running[execId], nil
+}
+
+func (m *mockCommandService) OpenExecutionLog(ctx context.Context, user entity.User, execId int) (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (m *mockCommandService) GetPendingApprovals(ctx context.Context, user entity.User) ([]entity.CommandExecution, error) {
+	return nil, nil
+}
+
+func (m *mockCommandService) DecideApproval(ctx context.Context, user entity.User, execId int, approved bool, comment string) error {
+	return nil
+}
+
+func (m *mockCommandService) WaitExecutions(ctx context.Context) {}
+
+func (m *mockCommandService) finish(execId int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	close(m.running[execId])
+}
+
+func (m *mockCommandService) executedCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.executed)
+}
+
+// waitFor polls cond, as queued runs are started by a background goroutine.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func newTestService(commands []entity.Command) (*ScheduleService, *mockCommandService, *time.Time) {
+	now := time.Date(2025, 1, 15, 10, 0, 30, 0, time.UTC)
+	cs := &mockCommandService{running: make(map[int]chan entity.ExecutionEvent)}
+	s := NewScheduleService(&mockStorage{commands: commands}, cs)
+	s.now = func() time.Time { return now }
+	return s, cs, &now
+}
+
+func TestScheduleRun(t *testing.T) {
+	// Arrange
+	s, cs, now := newTestService([]entity.Command{
+		{Id: "hourly", Schedule: "@hourly"},
+		{Id: "minutely", Schedule: "* * * * *"},
+		{Id: "manual"},
+	})
+	s.loadJobs(context.Background())
+
+	// Act
+	*now = now.Add(time.Minute)
+	s.runDue(context.Background())
+
+	// Assert
+	if cs.executedCount() != 1 || cs.executed[0] != "minutely" {
+		t.Fatalf("Expected only minutely to be executed, got %v", cs.executed)
+	}
+	if !cs.users[0].System {
+		t.Errorf("Expected execution by a system user, got %+v", cs.users[0])
+	}
+	schedules := s.GetSchedules(context.Background())
+	if len(schedules) != 2 {
+		t.Fatalf("Expected 2 schedules, got %v", schedules)
+	}
+	minutely := schedules["minutely"]
+	if minutely.LastRun == nil || minutely.LastExecId == nil || *minutely.LastExecId != 0 {
+		t.Errorf("Expected last run to be recorded, got %+v", minutely)
+	}
+	expectedNext := time.Date(2025, 1, 15, 10, 2, 0, 0, time.UTC)
+	if minutely.NextRun == nil || !minutely.NextRun.Equal(expectedNext) {
+		t.Errorf("Expected next run %s, got %v", expectedNext, minutely.NextRun)
+	}
+}
+
+func TestScheduleOverlap(t *testing.T) {
+	testCases := []struct {
+		name     string
+		overlap  entity.OverlapPolicy
+		expected int
+	}{
+		{"Skip", entity.OverlapPolicySkip, 1},
+		{"Queue", entity.OverlapPolicyQueue, 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			s, cs, now := newTestService([]entity.Command{
+				{Id: "slow", Schedule: "* * * * *", Overlap: tc.overlap},
+			})
+			s.loadJobs(context.Background())
+			*now = now.Add(time.Minute)
+			s.runDue(context.Background())
+
+			// Act
+			for i := 0; i < 3; i++ {
+				*now = now.Add(time.Minute)
+				s.runDue(context.Background())
+			}
+			cs.finish(0)
+
+			// Assert
+			waitFor(t, func() bool {
+				return cs.executedCount() >= tc.expected
+			})
+			if cs.executedCount() != tc.expected {
+				t.Errorf("Expected %d executions, got %d", tc.expected, cs.executedCount())
+			}
+		})
+	}
+}
+
+func TestScheduleReload(t *testing.T) {
+	// Arrange
+	commands := []entity.Command{{Id: "job", Schedule: "* * * * *"}}
+	s, cs, now := newTestService(commands)
+	s.loadJobs(context.Background())
+	*now = now.Add(time.Minute)
+	s.runDue(context.Background())
+
+	// Act
+	s.storage.(*mockStorage).commands = []entity.Command{
+		{Id: "job", Schedule: "0 * * * *"},
+		{Id: "new", Schedule: "@daily"},
+	}
+	s.loadJobs(context.Background())
+
+	// Assert
+	schedules := s.GetSchedules(context.Background())
+	if len(schedules) != 2 {
+		t.Fatalf("Expected 2 schedules, got %v", schedules)
+	}
+	if schedules["job"].LastRun == nil {
+		t.Errorf("Expected last run to survive the reload")
+	}
+	expectedNext := time.Date(2025, 1, 15, 11, 0, 0, 0, time.UTC)
+	if !schedules["job"].NextRun.Equal(expectedNext) {
+		t.Errorf("Expected next run %s, got %s", expectedNext, schedules["job"].NextRun)
+	}
+
+	*now = now.Add(time.Hour)
+	s.runDue(context.Background())
+	if cs.executedCount() != 1 {
+		t.Errorf("Expected run to be skipped while the previous one is running, got %d executions", cs.executedCount())
+	}
+}
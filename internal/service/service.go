@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"io"
 	"time"
 
 	"github.com/jrammler/wheelhouse/internal/entity"
@@ -9,19 +10,59 @@ import (
 
 type CommandService interface {
 	GetCommands(ctx context.Context, user entity.User) ([]entity.Command, error)
-	ExecuteCommand(ctx context.Context, user entity.User, id string) (int, error)
-	GetExecutionHistory(ctx context.Context, user entity.User) ([]entity.ExecutionHistoryEntry, error)
+	ExecuteCommand(ctx context.Context, user entity.User, id string, params map[string]string, trigger entity.Trigger) (int, error)
+	GetExecutionHistory(ctx context.Context, user entity.User, filter entity.ExecutionFilter) ([]entity.ExecutionHistoryEntry, error)
 	GetExecution(ctx context.Context, user entity.User, execId int) (*entity.CommandExecution, error)
+	CancelExecution(ctx context.Context, user entity.User, execId int) error
+	SubscribeExecution(ctx context.Context, user entity.User, execId int, start int) (<-chan entity.ExecutionEvent, error)
+	// OpenExecutionLog returns the complete output of an execution, which
+	// may be longer than its log.
+	OpenExecutionLog(ctx context.Context, user entity.User, execId int) (io.ReadCloser, error)
+	// GetPendingApprovals returns the executions waiting for approval the
+	// user may decide about.
+	GetPendingApprovals(ctx context.Context, user entity.User) ([]entity.CommandExecution, error)
+	DecideApproval(ctx context.Context, user entity.User, execId int, approved bool, comment string) error
 	WaitExecutions(ctx context.Context)
 }
 
 type AuthService interface {
 	LoginUser(ctx context.Context, username, password string) (sessionToken string, expiration *time.Time, err error)
 	LogoutUser(ctx context.Context, sessionToken string)
-	GetSessionUser(ctx context.Context, sessionToken string) (user entity.User, err error)
+	// GetSessionUser returns the user of a session and the token
+	// state-changing requests of the session have to carry.
+	GetSessionUser(ctx context.Context, sessionToken string) (user entity.User, csrfToken string, err error)
+	GetTokenUser(ctx context.Context, token string) (user entity.User, err error)
+}
+
+type ScheduleService interface {
+	// GetSchedules returns the state of all scheduled commands by command ID.
+	GetSchedules(ctx context.Context) map[string]entity.CommandSchedule
+}
+
+type AuditService interface {
+	// Record appends an event to the audit log.
+	Record(ctx context.Context, event entity.AuditEvent)
+	GetEvents(ctx context.Context, user entity.User) ([]entity.AuditEvent, error)
+}
+
+type NotificationService interface {
+	// Notify sends an event of an execution to the matching webhooks in the
+	// background.
+	Notify(ctx context.Context, event entity.NotificationEvent, execution entity.CommandExecution)
+	GetDeliveries(ctx context.Context, user entity.User) ([]entity.WebhookDelivery, error)
+}
+
+type WebhookService interface {
+	// TriggerWebhook verifies a request to a webhook trigger and executes its
+	// command.
+	TriggerWebhook(ctx context.Context, name string, req entity.WebhookRequest) (execId int, err error)
 }
 
 type Service struct {
-	CommandService CommandService
-	AuthService    AuthService
+	CommandService      CommandService
+	AuthService         AuthService
+	ScheduleService     ScheduleService
+	AuditService        AuditService
+	NotificationService NotificationService
+	WebhookService      WebhookService
 }
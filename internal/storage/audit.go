@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+)
+
+// maxAuditRecordSize limits the length of a line of the audit log.
+const maxAuditRecordSize = 1024 * 1024
+
+type AuditLog interface {
+	// Append assigns the sequence number and hashes of the event and stores
+	// it.
+	Append(ctx context.Context, event *entity.AuditEvent) error
+	// GetEvents returns all events, oldest first.
+	GetEvents(ctx context.Context) ([]entity.AuditEvent, error)
+	Close() error
+}
+
+// FileAuditLog appends audit events as JSON lines to a file that is never
+// rewritten.
+type FileAuditLog struct {
+	filepath string
+	file     *os.File
+	lastSeq  int
+	lastHash string
+	mu       sync.Mutex
+}
+
+func NewFileAuditLog(filepath string) (*FileAuditLog, error) {
+	file, err := os.OpenFile(filepath, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	l := &FileAuditLog{filepath: filepath, file: file}
+
+	// continue the chain of the existing records
+	err = readAuditEvents(file, func(event entity.AuditEvent) error {
+		l.lastSeq = event.Seq
+		l.lastHash = event.Hash
+		return nil
+	})
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return l, nil
+}
+
+func (l *FileAuditLog) Append(ctx context.Context, event *entity.AuditEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	event.Seq = l.lastSeq + 1
+	event.Time = event.Time.UTC()
+	event.PrevHash = l.lastHash
+	hash, err := hashAuditEvent(*event)
+	if err != nil {
+		return err
+	}
+	event.Hash = hash
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = l.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	err = l.file.Sync()
+	if err != nil {
+		return err
+	}
+	l.lastSeq = event.Seq
+	l.lastHash = event.Hash
+	return nil
+}
+
+func (l *FileAuditLog) GetEvents(ctx context.Context) ([]entity.AuditEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.Open(l.filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	events := make([]entity.AuditEvent, 0)
+	err = readAuditEvents(file, func(event entity.AuditEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	return events, err
+}
+
+func (l *FileAuditLog) Close() error {
+	return l.file.Close()
+}
+
+func readAuditEvents(r io.Reader, handle func(event entity.AuditEvent) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxAuditRecordSize)
+	line := 0
+	for scanner.Scan() {
+		line += 1
+		var event entity.AuditEvent
+		err := json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		err = handle(event)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// hashAuditEvent computes the hash of an event, which covers all fields
+// including the hash of the previous event.
+func hashAuditEvent(event entity.AuditEvent) (string, error) {
+	event.Hash = ""
+	data, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+var AuditChainBrokenError = errors.New("Audit log hash chain is broken")
+
+// VerifyAuditLog checks the hash chain of an audit log and returns the number
+// of valid records. Records removed from the end of the log can not be
+// detected.
+func VerifyAuditLog(r io.Reader) (int, error) {
+	count := 0
+	prevHash := ""
+	err := readAuditEvents(r, func(event entity.AuditEvent) error {
+		if event.Seq != count+1 {
+			return fmt.Errorf("%w: expected sequence number %d, got %d", AuditChainBrokenError, count+1, event.Seq)
+		}
+		if event.PrevHash != prevHash {
+			return fmt.Errorf("%w: previous hash does not match", AuditChainBrokenError)
+		}
+		hash, err := hashAuditEvent(event)
+		if err != nil {
+			return err
+		}
+		if hash != event.Hash {
+			return fmt.Errorf("%w: record was modified", AuditChainBrokenError)
+		}
+		count += 1
+		prevHash = event.Hash
+		return nil
+	})
+	return count, err
+}
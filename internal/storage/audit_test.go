@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+)
+
+func TestFileAuditLog(t *testing.T) {
+	// Arrange
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := NewFileAuditLog(path)
+	if err != nil {
+		t.Fatalf("NewFileAuditLog failed: %q", err)
+	}
+
+	// Act
+	err = log.Append(ctx, &entity.AuditEvent{Time: time.Now(), Type: entity.AuditEventLogin, User: "alice"})
+	if err != nil {
+		t.Fatalf("Append failed: %q", err)
+	}
+	log.Close()
+	// reopening continues the existing chain
+	log, err = NewFileAuditLog(path)
+	if err != nil {
+		t.Fatalf("NewFileAuditLog failed: %q", err)
+	}
+	defer log.Close()
+	err = log.Append(ctx, &entity.AuditEvent{Time: time.Now(), Type: entity.AuditEventLogout, User: "alice"})
+	if err != nil {
+		t.Fatalf("Append failed: %q", err)
+	}
+
+	// Assert
+	events, err := log.GetEvents(ctx)
+	if err != nil {
+		t.Fatalf("GetEvents failed: %q", err)
+	}
+	if len(events) != 2 || events[0].Seq != 1 || events[1].Seq != 2 {
+		t.Fatalf("Expected two events in order, got %v", events)
+	}
+	if events[0].PrevHash != "" || events[1].PrevHash != events[0].Hash {
+		t.Errorf("Expected events to be chained, got %v", events)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %q", err)
+	}
+	defer file.Close()
+	count, err := VerifyAuditLog(file)
+	if err != nil || count != 2 {
+		t.Errorf("Expected valid log with 2 records, got %d, %v", count, err)
+	}
+}
+
+func TestVerifyAuditLogTampered(t *testing.T) {
+	// Arrange
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := NewFileAuditLog(path)
+	if err != nil {
+		t.Fatalf("NewFileAuditLog failed: %q", err)
+	}
+	for _, user := range []string{"alice", "bob", "carol"} {
+		err = log.Append(ctx, &entity.AuditEvent{Time: time.Now(), Type: entity.AuditEventLogin, User: user})
+		if err != nil {
+			t.Fatalf("Append failed: %q", err)
+		}
+	}
+	log.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %q", err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+
+	testCases := []struct {
+		name     string
+		content  string
+		expected int
+	}{
+		{"modified", lines[0] + strings.Replace(lines[1], "bob", "eve", 1) + lines[2], 1},
+		{"removed", lines[0] + lines[2], 1},
+		{"reordered", lines[1] + lines[0] + lines[2], 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			count, err := VerifyAuditLog(strings.NewReader(tc.content))
+
+			// Assert
+			if !errors.Is(err, AuditChainBrokenError) {
+				t.Errorf("Expected AuditChainBrokenError, got %v", err)
+			}
+			if count != tc.expected {
+				t.Errorf("Expected %d valid records, got %d", tc.expected, count)
+			}
+		})
+	}
+}
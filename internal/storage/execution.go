@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+)
+
+var ExecutionNotFoundError = errors.New("Execution not found")
+
+// ExecutionStore persists command executions and their logs. Implementations
+// return copies, so callers may not observe later changes to an execution
+// without fetching it again.
+type ExecutionStore interface {
+	// CreateExecution stores a new execution and assigns its ExecId.
+	CreateExecution(ctx context.Context, execution *entity.CommandExecution) error
+	// UpdateExecution stores the state of an existing execution, except for
+	// its log.
+	UpdateExecution(ctx context.Context, execution *entity.CommandExecution) error
+	AppendLog(ctx context.Context, execId int, entries ...entity.LogEntry) error
+	GetExecution(ctx context.Context, execId int) (*entity.CommandExecution, error)
+	// GetLog returns the log entries of an execution starting at the given
+	// index.
+	GetLog(ctx context.Context, execId int, start int) ([]entity.LogEntry, error)
+	// GetExecutions returns the stored executions matching the filter in the
+	// order they were created, without their logs.
+	GetExecutions(ctx context.Context, filter entity.ExecutionFilter) ([]entity.CommandExecution, error)
+	Close() error
+}
+
+// MemoryExecutionStore keeps the most recent executions in memory.
+type MemoryExecutionStore struct {
+	maxLen  int
+	history []*entity.CommandExecution
+	offset  int
+	mu      sync.RWMutex
+}
+
+// DefaultMemoryHistoryLength is the number of executions kept if the history
+// is not stored in a database.
+const DefaultMemoryHistoryLength = 100
+
+func NewMemoryExecutionStore(maxLen int) *MemoryExecutionStore {
+	return &MemoryExecutionStore{
+		maxLen:  maxLen,
+		history: make([]*entity.CommandExecution, 0),
+	}
+}
+
+func (s *MemoryExecutionStore) CreateExecution(ctx context.Context, execution *entity.CommandExecution) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	histLen := len(s.history)
+	execution.ExecId = histLen + s.offset
+	if histLen >= s.maxLen {
+		removeCnt := histLen - s.maxLen + 1
+		for i := 0; i < removeCnt; i++ {
+			s.history[i] = nil
+		}
+		s.history = s.history[removeCnt:]
+		s.offset += removeCnt
+	}
+	s.history = append(s.history, copyExecution(execution))
+	return nil
+}
+
+func (s *MemoryExecutionStore) get(execId int) *entity.CommandExecution {
+	idx := execId - s.offset
+	if idx < 0 || idx >= len(s.history) {
+		return nil
+	}
+	return s.history[idx]
+}
+
+func (s *MemoryExecutionStore) UpdateExecution(ctx context.Context, execution *entity.CommandExecution) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.get(execution.ExecId)
+	if stored == nil {
+		return ExecutionNotFoundError
+	}
+	updated := copyExecution(execution)
+	updated.Log = stored.Log
+	s.history[execution.ExecId-s.offset] = updated
+	return nil
+}
+
+func (s *MemoryExecutionStore) AppendLog(ctx context.Context, execId int, entries ...entity.LogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.get(execId)
+	if stored == nil {
+		return ExecutionNotFoundError
+	}
+	stored.Log = append(stored.Log, entries...)
+	return nil
+}
+
+func (s *MemoryExecutionStore) GetExecution(ctx context.Context, execId int) (*entity.CommandExecution, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stored := s.get(execId)
+	if stored == nil {
+		return nil, ExecutionNotFoundError
+	}
+	return copyExecution(stored), nil
+}
+
+func (s *MemoryExecutionStore) GetLog(ctx context.Context, execId int, start int) ([]entity.LogEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stored := s.get(execId)
+	if stored == nil {
+		return nil, ExecutionNotFoundError
+	}
+	if start >= len(stored.Log) {
+		return nil, nil
+	}
+	return slices.Clone(stored.Log[max(start, 0):]), nil
+}
+
+func (s *MemoryExecutionStore) GetExecutions(ctx context.Context, filter entity.ExecutionFilter) ([]entity.CommandExecution, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	executions := make([]entity.CommandExecution, 0)
+	skipped := 0
+	for _, stored := range slices.Backward(s.history) {
+		if filter.Limit > 0 && len(executions) == filter.Limit {
+			break
+		}
+		if filter.User != "" && stored.User != filter.User {
+			continue
+		}
+		if filter.ParentId != nil && (stored.ParentId == nil || *stored.ParentId != *filter.ParentId) {
+			continue
+		}
+		if skipped < filter.Offset {
+			skipped++
+			continue
+		}
+		execution := copyExecution(stored)
+		execution.Log = nil
+		executions = append(executions, *execution)
+	}
+	slices.Reverse(executions)
+	return executions, nil
+}
+
+func (s *MemoryExecutionStore) Close() error {
+	return nil
+}
+
+func copyExecution(execution *entity.CommandExecution) *entity.CommandExecution {
+	c := *execution
+	c.Parameters = slices.Clone(execution.Parameters)
+	c.Log = slices.Clone(execution.Log)
+	if execution.Approval != nil {
+		approval := *execution.Approval
+		c.Approval = &approval
+	}
+	return &c
+}
@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+)
+
+func testExecutionStore(t *testing.T, store ExecutionStore) {
+	ctx := context.Background()
+
+	first := &entity.CommandExecution{CommandId: "a", User: "alice", ExecTime: time.Now()}
+	err := store.CreateExecution(ctx, first)
+	if err != nil {
+		t.Fatalf("CreateExecution failed: %q", err)
+	}
+	role := "admin"
+	second := &entity.CommandExecution{
+		CommandId:   "b",
+		CommandName: "Deploy",
+		CommandText: "deploy.sh",
+		CommandRole: &role,
+		Trigger:     entity.Trigger{Type: entity.TriggerTypeWebhook, Source: "deploy", SourceIp: "192.0.2.1", UserAgent: "curl/8.0"},
+		User:        "bob",
+		ExecTime:    time.Now(),
+		Parameters:  []entity.ParameterValue{{Name: "target", Value: "prod"}},
+	}
+	second.ParentId = &first.ExecId
+	err = store.CreateExecution(ctx, second)
+	if err != nil {
+		t.Fatalf("CreateExecution failed: %q", err)
+	}
+	if second.ExecId <= first.ExecId {
+		t.Errorf("Expected increasing ExecIds, got %d and %d", first.ExecId, second.ExecId)
+	}
+
+	logTime := time.Now()
+	err = store.AppendLog(ctx, second.ExecId, entity.LogEntry{Stream: "stdout", Data: "line 1", Time: logTime}, entity.LogEntry{Stream: "stderr", Data: "line 2"})
+	if err != nil {
+		t.Fatalf("AppendLog failed: %q", err)
+	}
+	exitCode := 3
+	second.ExitCode = &exitCode
+	peakMemory, cpuTime := int64(1<<20), 1500*time.Millisecond
+	second.Usage = entity.ResourceUsage{PeakMemory: &peakMemory, CpuTime: &cpuTime}
+	startTime, endTime := second.ExecTime.Add(time.Second), second.ExecTime.Add(3*time.Second)
+	second.StartTime, second.EndTime = &startTime, &endTime
+	second.Approval = &entity.ExecutionApproval{
+		Role:         "dba",
+		Expires:      endTime,
+		Decision:     entity.ApprovalDecisionApproved,
+		DecidedBy:    "carol",
+		Comment:      "checked",
+		DecisionTime: &startTime,
+	}
+	err = store.UpdateExecution(ctx, second)
+	if err != nil {
+		t.Fatalf("UpdateExecution failed: %q", err)
+	}
+
+	exec, err := store.GetExecution(ctx, second.ExecId)
+	if err != nil {
+		t.Fatalf("GetExecution failed: %q", err)
+	}
+	if exec.User != "bob" || exec.CommandId != "b" {
+		t.Errorf("Expected execution of b by bob, got %+v", exec)
+	}
+	if exec.CommandName != "Deploy" || exec.CommandText != "deploy.sh" || exec.CommandRole == nil || *exec.CommandRole != "admin" {
+		t.Errorf("Expected command snapshot to be stored, got %+v", exec)
+	}
+	if exec.Trigger.Type != entity.TriggerTypeWebhook || exec.Trigger.Source != "deploy" || exec.Trigger.SourceIp != "192.0.2.1" || exec.Trigger.UserAgent != "curl/8.0" {
+		t.Errorf("Expected trigger to be stored, got %+v", exec.Trigger)
+	}
+	if exec.ParentId == nil || *exec.ParentId != first.ExecId {
+		t.Errorf("Expected parent %d, got %v", first.ExecId, exec.ParentId)
+	}
+	if exec.Approval == nil || exec.Approval.Decision != entity.ApprovalDecisionApproved || exec.Approval.DecidedBy != "carol" || !exec.Approval.DecisionTime.Equal(startTime) {
+		t.Errorf("Expected approval to be stored, got %+v", exec.Approval)
+	}
+	if exec.ExitCode == nil || *exec.ExitCode != 3 {
+		t.Errorf("Expected exit code 3, got %v", exec.ExitCode)
+	}
+	if exec.Usage.PeakMemory == nil || *exec.Usage.PeakMemory != peakMemory || exec.Usage.CpuTime == nil || *exec.Usage.CpuTime != cpuTime {
+		t.Errorf("Expected resource usage to be stored, got %+v", exec.Usage)
+	}
+	if duration, ok := exec.Duration(); !ok || duration != 2*time.Second || !exec.StartTime.Equal(startTime) {
+		t.Errorf("Expected start and end time to be stored, got %v and %v", exec.StartTime, exec.EndTime)
+	}
+	if len(exec.Log) != 2 || exec.Log[0].Data != "line 1" || exec.Log[1].Stream != "stderr" {
+		t.Errorf("Expected both log entries in order, got %v", exec.Log)
+	}
+	if !exec.Log[0].Time.Equal(logTime) || !exec.Log[1].Time.IsZero() {
+		t.Errorf("Expected log times to be stored, got %v and %v", exec.Log[0].Time, exec.Log[1].Time)
+	}
+	if len(exec.Parameters) != 1 || exec.Parameters[0].Value != "prod" {
+		t.Errorf("Expected parameters to be stored, got %v", exec.Parameters)
+	}
+
+	executions, err := store.GetExecutions(ctx, entity.ExecutionFilter{})
+	if err != nil {
+		t.Fatalf("GetExecutions failed: %q", err)
+	}
+	if len(executions) != 2 || executions[0].ExecId != first.ExecId || executions[1].ExecId != second.ExecId {
+		t.Errorf("Expected both executions in order, got %v", executions)
+	}
+
+	executions, err = store.GetExecutions(ctx, entity.ExecutionFilter{User: "bob", ParentId: &first.ExecId})
+	if err != nil {
+		t.Fatalf("GetExecutions failed: %q", err)
+	}
+	if len(executions) != 1 || executions[0].ExecId != second.ExecId {
+		t.Errorf("Expected the filtered execution, got %v", executions)
+	}
+	executions, err = store.GetExecutions(ctx, entity.ExecutionFilter{User: "alice", ParentId: &first.ExecId})
+	if err != nil {
+		t.Fatalf("GetExecutions failed: %q", err)
+	}
+	if len(executions) != 0 {
+		t.Errorf("Expected no executions, got %v", executions)
+	}
+	executions, err = store.GetExecutions(ctx, entity.ExecutionFilter{Limit: 1})
+	if err != nil {
+		t.Fatalf("GetExecutions failed: %q", err)
+	}
+	if len(executions) != 1 || executions[0].ExecId != second.ExecId {
+		t.Errorf("Expected the most recent execution, got %v", executions)
+	}
+	executions, err = store.GetExecutions(ctx, entity.ExecutionFilter{Offset: 1, Limit: 1})
+	if err != nil {
+		t.Fatalf("GetExecutions failed: %q", err)
+	}
+	if len(executions) != 1 || executions[0].ExecId != first.ExecId {
+		t.Errorf("Expected the execution before the most recent one, got %v", executions)
+	}
+
+	_, err = store.GetExecution(ctx, second.ExecId+100)
+	if !errors.Is(err, ExecutionNotFoundError) {
+		t.Errorf("Expected ExecutionNotFoundError, got %q", err)
+	}
+}
+
+func TestMemoryExecutionStore(t *testing.T) {
+	t.Run("Store", func(t *testing.T) {
+		testExecutionStore(t, NewMemoryExecutionStore(10))
+	})
+
+	t.Run("Ring", func(t *testing.T) {
+		store := NewMemoryExecutionStore(2)
+		for i := 0; i < 3; i++ {
+			err := store.CreateExecution(context.Background(), &entity.CommandExecution{})
+			if err != nil {
+				t.Fatalf("CreateExecution failed: %q", err)
+			}
+		}
+		executions, _ := store.GetExecutions(context.Background(), entity.ExecutionFilter{})
+		if len(executions) != 2 || executions[0].ExecId != 1 {
+			t.Errorf("Expected the two newest executions, got %v", executions)
+		}
+	})
+}
+
+func TestSqliteExecutionStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+
+	t.Run("Store", func(t *testing.T) {
+		store, err := NewSqliteExecutionStore(path)
+		if err != nil {
+			t.Fatalf("NewSqliteExecutionStore failed: %q", err)
+		}
+		defer store.Close()
+		testExecutionStore(t, store)
+
+		err = store.CreateExecution(context.Background(), &entity.CommandExecution{CommandId: "running", ExecTime: time.Now()})
+		if err != nil {
+			t.Fatalf("CreateExecution failed: %q", err)
+		}
+		err = store.CreateExecution(context.Background(), &entity.CommandExecution{CommandId: "pending", ExecTime: time.Now(), Status: entity.ExecutionStatusPendingApproval})
+		if err != nil {
+			t.Fatalf("CreateExecution failed: %q", err)
+		}
+	})
+
+	t.Run("Reopen", func(t *testing.T) {
+		store, err := NewSqliteExecutionStore(path)
+		if err != nil {
+			t.Fatalf("NewSqliteExecutionStore failed: %q", err)
+		}
+		defer store.Close()
+
+		executions, err := store.GetExecutions(context.Background(), entity.ExecutionFilter{})
+		if err != nil {
+			t.Fatalf("GetExecutions failed: %q", err)
+		}
+		if len(executions) != 4 {
+			t.Fatalf("Expected 4 persisted executions, got %d", len(executions))
+		}
+		running := executions[2]
+		if running.Status != entity.ExecutionStatusInterrupted {
+			t.Errorf("Expected unfinished execution to be interrupted, got %q", running.Status)
+		}
+		if pending := executions[3]; pending.Status != entity.ExecutionStatusInterrupted || pending.ExitCode == nil {
+			t.Errorf("Expected pending execution to be interrupted, got %q", pending.Status)
+		}
+
+		exec := &entity.CommandExecution{ExecTime: time.Now()}
+		err = store.CreateExecution(context.Background(), exec)
+		if err != nil {
+			t.Fatalf("CreateExecution failed: %q", err)
+		}
+		if exec.ExecId <= executions[3].ExecId {
+			t.Errorf("Expected ExecId greater than %d, got %d", executions[3].ExecId, exec.ExecId)
+		}
+	})
+}
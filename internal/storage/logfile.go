@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var LogFileNotFoundError = errors.New("Log file not found")
+
+// LogFileStore keeps the complete output of executions, so the log stored
+// with the execution can be shortened.
+type LogFileStore interface {
+	// Create starts a new log file for an execution, replacing an existing
+	// one.
+	Create(execId int) (io.WriteCloser, error)
+	// Remove deletes the log file of an execution if there is one.
+	Remove(execId int) error
+	// Open returns the uncompressed content of the log file of an execution.
+	Open(execId int) (io.ReadCloser, error)
+	// Compress replaces the log file of a finished execution by a gzip
+	// compressed copy.
+	Compress(execId int) error
+	// Prune removes the log files last written before the given time and
+	// returns how many were removed.
+	Prune(before time.Time) (int, error)
+}
+
+// DirLogFileStore writes the logs to one file per execution named after the
+// execution ID.
+type DirLogFileStore struct {
+	dir string
+}
+
+const (
+	logFileSuffix           = ".log"
+	compressedLogFileSuffix = ".log.gz"
+)
+
+func NewDirLogFileStore(dir string) (*DirLogFileStore, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	return &DirLogFileStore{dir: dir}, nil
+}
+
+func (s *DirLogFileStore) path(execId int, suffix string) string {
+	return filepath.Join(s.dir, strconv.Itoa(execId)+suffix)
+}
+
+func (s *DirLogFileStore) Create(execId int) (io.WriteCloser, error) {
+	// execution IDs of the in-memory history start again after a restart
+	err := os.Remove(s.path(execId, compressedLogFileSuffix))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return os.OpenFile(s.path(execId, logFileSuffix), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+}
+
+func (s *DirLogFileStore) Remove(execId int) error {
+	for _, suffix := range []string{logFileSuffix, compressedLogFileSuffix} {
+		err := os.Remove(s.path(execId, suffix))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DirLogFileStore) Open(execId int) (io.ReadCloser, error) {
+	file, err := os.Open(s.path(execId, logFileSuffix))
+	if err == nil {
+		return file, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	file, err = os.Open(s.path(execId, compressedLogFileSuffix))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, LogFileNotFoundError
+	}
+	if err != nil {
+		return nil, err
+	}
+	r, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read compressed log: %w", err)
+	}
+	return &gzipFile{Reader: r, file: file}, nil
+}
+
+// gzipFile closes the file along with the decompressing reader.
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (f *gzipFile) Close() error {
+	f.Reader.Close()
+	return f.file.Close()
+}
+
+func (s *DirLogFileStore) Compress(execId int) error {
+	path := s.path(execId, logFileSuffix)
+	src, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return LogFileNotFoundError
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// the compressed file is written under a temporary name, so a
+	// partially written file is never served
+	tmp, err := os.CreateTemp(s.dir, strconv.Itoa(execId)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := gzip.NewWriter(tmp)
+	_, err = io.Copy(w, src)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return err
+	}
+	// Windows can not remove open files
+	src.Close()
+	err = os.Rename(tmp.Name(), s.path(execId, compressedLogFileSuffix))
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (s *DirLogFileStore) Prune(before time.Time) (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !(strings.HasSuffix(name, logFileSuffix) || strings.HasSuffix(name, compressedLogFileSuffix)) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(before) {
+			continue
+		}
+		err = os.Remove(filepath.Join(s.dir, name))
+		if err != nil {
+			slog.Warn("Failed to remove log file", "file", name, "error", err)
+			continue
+		}
+		removed += 1
+	}
+	return removed, nil
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readLogFile(t *testing.T, store LogFileStore, execId int) string {
+	t.Helper()
+	r, err := store.Open(execId)
+	if err != nil {
+		t.Fatalf("Open failed: %q", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Reading log file failed: %q", err)
+	}
+	return string(data)
+}
+
+func TestDirLogFileStore(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	store, err := NewDirLogFileStore(filepath.Join(dir, "logs"))
+	if err != nil {
+		t.Fatalf("NewDirLogFileStore failed: %q", err)
+	}
+
+	// Act
+	w, err := store.Create(1)
+	if err != nil {
+		t.Fatalf("Create failed: %q", err)
+	}
+	io.WriteString(w, "line 1\nline 2\n")
+	w.Close()
+	plain := readLogFile(t, store, 1)
+	err = store.Compress(1)
+	if err != nil {
+		t.Fatalf("Compress failed: %q", err)
+	}
+	compressed := readLogFile(t, store, 1)
+
+	// Assert
+	if plain != "line 1\nline 2\n" || compressed != plain {
+		t.Errorf("Expected log content to be kept, got %q and %q", plain, compressed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "logs", "1.log")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected uncompressed file to be removed, got %v", err)
+	}
+	if _, err := store.Open(2); !errors.Is(err, LogFileNotFoundError) {
+		t.Errorf("Expected LogFileNotFoundError, got %v", err)
+	}
+}
+
+func TestDirLogFileStoreReplace(t *testing.T) {
+	// Arrange
+	store, err := NewDirLogFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDirLogFileStore failed: %q", err)
+	}
+	w, _ := store.Create(1)
+	io.WriteString(w, "old\n")
+	w.Close()
+	store.Compress(1)
+
+	// Act
+	w, err = store.Create(1)
+	if err != nil {
+		t.Fatalf("Create failed: %q", err)
+	}
+	io.WriteString(w, "new\n")
+	w.Close()
+
+	// Assert
+	if content := readLogFile(t, store, 1); content != "new\n" {
+		t.Errorf("Expected new log to replace the old one, got %q", content)
+	}
+}
+
+func TestDirLogFileStoreRemove(t *testing.T) {
+	// Arrange
+	store, err := NewDirLogFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDirLogFileStore failed: %q", err)
+	}
+	for _, id := range []int{1, 2} {
+		w, _ := store.Create(id)
+		w.Close()
+	}
+	store.Compress(2)
+
+	// Act
+	errs := []error{store.Remove(1), store.Remove(2), store.Remove(3)}
+
+	// Assert
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Expected no error removing log %d, got %q", i+1, err)
+		}
+	}
+	for _, id := range []int{1, 2} {
+		if _, err := store.Open(id); !errors.Is(err, LogFileNotFoundError) {
+			t.Errorf("Expected log %d to be removed, got %v", id, err)
+		}
+	}
+}
+
+func TestDirLogFileStorePrune(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	store, err := NewDirLogFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewDirLogFileStore failed: %q", err)
+	}
+	for _, id := range []int{1, 2} {
+		w, _ := store.Create(id)
+		w.Close()
+	}
+	store.Compress(2)
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(filepath.Join(dir, "1.log"), old, old)
+	os.Chtimes(filepath.Join(dir, "2.log.gz"), old, old)
+	w, _ := store.Create(3)
+	w.Close()
+	os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o600)
+	os.Chtimes(filepath.Join(dir, "notes.txt"), old, old)
+
+	// Act
+	removed, err := store.Prune(time.Now().Add(-24 * time.Hour))
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Prune failed: %q", err)
+	}
+	if removed != 2 {
+		t.Errorf("Expected 2 removed files, got %d", removed)
+	}
+	if _, err := store.Open(3); err != nil {
+		t.Errorf("Expected recent log to be kept, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Errorf("Expected unrelated file to be kept, got %v", err)
+	}
+}
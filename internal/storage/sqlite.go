@@ -0,0 +1,402 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations are applied in order to bring the database schema up to
+// date. The number of applied migrations is tracked in PRAGMA user_version,
+// so existing entries must never be changed.
+var sqliteMigrations = []string{
+	`CREATE TABLE executions (
+		exec_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		command_id TEXT NOT NULL,
+		username TEXT NOT NULL,
+		exec_time TEXT NOT NULL,
+		exit_code INTEGER,
+		status TEXT NOT NULL,
+		cancelled_by TEXT,
+		parameters TEXT NOT NULL
+	);
+	CREATE TABLE log_entries (
+		entry_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		exec_id INTEGER NOT NULL REFERENCES executions(exec_id) ON DELETE CASCADE,
+		stream TEXT NOT NULL,
+		data TEXT NOT NULL
+	);
+	CREATE INDEX log_entries_exec_id ON log_entries(exec_id, entry_id);`,
+	`ALTER TABLE executions ADD COLUMN command_name TEXT NOT NULL DEFAULT '';
+	ALTER TABLE executions ADD COLUMN command_text TEXT NOT NULL DEFAULT '';
+	ALTER TABLE executions ADD COLUMN command_role TEXT;`,
+	`ALTER TABLE executions ADD COLUMN trigger_type TEXT NOT NULL DEFAULT '';
+	ALTER TABLE executions ADD COLUMN source_ip TEXT NOT NULL DEFAULT '';
+	ALTER TABLE executions ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+	CREATE INDEX executions_username ON executions(username);`,
+	// peak_memory is stored in bytes, cpu_time in nanoseconds
+	`ALTER TABLE executions ADD COLUMN peak_memory INTEGER;
+	ALTER TABLE executions ADD COLUMN cpu_time INTEGER;`,
+	// log_time is stored in nanoseconds since the Unix epoch
+	`ALTER TABLE executions ADD COLUMN start_time TEXT;
+	ALTER TABLE executions ADD COLUMN end_time TEXT;
+	ALTER TABLE log_entries ADD COLUMN log_time INTEGER;`,
+	`ALTER TABLE executions ADD COLUMN trigger_source TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE executions ADD COLUMN parent_id INTEGER;`,
+	// approval is stored as JSON
+	`ALTER TABLE executions ADD COLUMN approval TEXT;`,
+	`CREATE INDEX executions_parent_id ON executions(parent_id);`,
+}
+
+// SqliteExecutionStore persists executions in a SQLite database, so the
+// history survives restarts.
+type SqliteExecutionStore struct {
+	db *sql.DB
+}
+
+func NewSqliteExecutionStore(path string) (*SqliteExecutionStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only supports a single writer, serializing access avoids
+	// SQLITE_BUSY errors
+	db.SetMaxOpenConns(1)
+
+	s := &SqliteExecutionStore{db: db}
+	err = s.migrate()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	err = s.markInterrupted()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SqliteExecutionStore) migrate() error {
+	var version int
+	err := s.db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+	for i := version; i < len(sqliteMigrations); i++ {
+		slog.Info("Migrating execution database", "version", i+1)
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(sqliteMigrations[i])
+		if err == nil {
+			_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1))
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markInterrupted finishes executions that were still running, queued or
+// waiting for approval when the previous process stopped.
+func (s *SqliteExecutionStore) markInterrupted() error {
+	_, err := s.db.Exec(
+		"UPDATE executions SET exit_code = -1, status = ? WHERE exit_code IS NULL AND status IN ('', ?, ?)",
+		entity.ExecutionStatusInterrupted, entity.ExecutionStatusQueued, entity.ExecutionStatusPendingApproval,
+	)
+	return err
+}
+
+func (s *SqliteExecutionStore) CreateExecution(ctx context.Context, execution *entity.CommandExecution) error {
+	params, err := json.Marshal(execution.Parameters)
+	if err != nil {
+		return err
+	}
+	approval, err := marshalApproval(execution.Approval)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO executions (command_id, command_name, command_text, command_role, username,
+			trigger_type, trigger_source, source_ip, user_agent, exec_time, start_time, end_time, exit_code, status, cancelled_by, peak_memory, cpu_time, parameters, parent_id, approval)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		execution.CommandId, execution.CommandName, execution.CommandText, execution.CommandRole, execution.User,
+		execution.Trigger.Type, execution.Trigger.Source, execution.Trigger.SourceIp, execution.Trigger.UserAgent, execution.ExecTime.Format(time.RFC3339Nano),
+		formatOptionalTime(execution.StartTime), formatOptionalTime(execution.EndTime), execution.ExitCode, execution.Status, execution.CancelledBy, execution.Usage.PeakMemory, execution.Usage.CpuTime, string(params), execution.ParentId, approval,
+	)
+	if err != nil {
+		return err
+	}
+	execId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	execution.ExecId = int(execId)
+
+	if len(execution.Log) > 0 {
+		return s.AppendLog(ctx, execution.ExecId, execution.Log...)
+	}
+	return nil
+}
+
+func (s *SqliteExecutionStore) UpdateExecution(ctx context.Context, execution *entity.CommandExecution) error {
+	params, err := json.Marshal(execution.Parameters)
+	if err != nil {
+		return err
+	}
+	approval, err := marshalApproval(execution.Approval)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE executions SET command_id = ?, command_name = ?, command_text = ?, command_role = ?, username = ?,
+			trigger_type = ?, trigger_source = ?, source_ip = ?, user_agent = ?, exec_time = ?, start_time = ?, end_time = ?, exit_code = ?,
+			status = ?, cancelled_by = ?, peak_memory = ?, cpu_time = ?, parameters = ?, parent_id = ?, approval = ?
+		WHERE exec_id = ?`,
+		execution.CommandId, execution.CommandName, execution.CommandText, execution.CommandRole, execution.User,
+		execution.Trigger.Type, execution.Trigger.Source, execution.Trigger.SourceIp, execution.Trigger.UserAgent, execution.ExecTime.Format(time.RFC3339Nano),
+		formatOptionalTime(execution.StartTime), formatOptionalTime(execution.EndTime), execution.ExitCode, execution.Status, execution.CancelledBy, execution.Usage.PeakMemory, execution.Usage.CpuTime, string(params), execution.ParentId, approval,
+		execution.ExecId,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ExecutionNotFoundError
+	}
+	return nil
+}
+
+func (s *SqliteExecutionStore) AppendLog(ctx context.Context, execId int, entries ...entity.LogEntry) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, entry := range entries {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO log_entries (exec_id, stream, data, log_time) VALUES (?, ?, ?, ?)",
+			execId, entry.Stream, entry.Data, logTime(entry.Time),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+const executionColumns = "exec_id, command_id, command_name, command_text, command_role, username, " +
+	"trigger_type, trigger_source, source_ip, user_agent, exec_time, start_time, end_time, exit_code, status, cancelled_by, peak_memory, cpu_time, parameters, parent_id, approval"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanExecution(row rowScanner) (*entity.CommandExecution, error) {
+	var execution entity.CommandExecution
+	var execTime, params string
+	var exitCode, peakMemory, cpuTime, parentId sql.NullInt64
+	var commandRole, cancelledBy, startTime, endTime, approval sql.NullString
+	err := row.Scan(
+		&execution.ExecId, &execution.CommandId, &execution.CommandName, &execution.CommandText, &commandRole,
+		&execution.User, &execution.Trigger.Type, &execution.Trigger.Source, &execution.Trigger.SourceIp, &execution.Trigger.UserAgent,
+		&execTime, &startTime, &endTime, &exitCode, &execution.Status, &cancelledBy, &peakMemory, &cpuTime, &params, &parentId, &approval,
+	)
+	if err != nil {
+		return nil, err
+	}
+	execution.ExecTime, err = time.Parse(time.RFC3339Nano, execTime)
+	if err != nil {
+		return nil, err
+	}
+	execution.StartTime, err = parseOptionalTime(startTime)
+	if err != nil {
+		return nil, err
+	}
+	execution.EndTime, err = parseOptionalTime(endTime)
+	if err != nil {
+		return nil, err
+	}
+	if exitCode.Valid {
+		code := int(exitCode.Int64)
+		execution.ExitCode = &code
+	}
+	if commandRole.Valid {
+		execution.CommandRole = &commandRole.String
+	}
+	if cancelledBy.Valid {
+		execution.CancelledBy = &cancelledBy.String
+	}
+	if peakMemory.Valid {
+		execution.Usage.PeakMemory = &peakMemory.Int64
+	}
+	if cpuTime.Valid {
+		duration := time.Duration(cpuTime.Int64)
+		execution.Usage.CpuTime = &duration
+	}
+	if parentId.Valid {
+		id := int(parentId.Int64)
+		execution.ParentId = &id
+	}
+	err = json.Unmarshal([]byte(params), &execution.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	if approval.Valid {
+		err = json.Unmarshal([]byte(approval.String), &execution.Approval)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &execution, nil
+}
+
+// marshalApproval encodes the approval of an execution, nil if there is none.
+func marshalApproval(approval *entity.ExecutionApproval) (*string, error) {
+	if approval == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(approval)
+	if err != nil {
+		return nil, err
+	}
+	str := string(data)
+	return &str, nil
+}
+
+func formatOptionalTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	str := t.Format(time.RFC3339Nano)
+	return &str
+}
+
+func parseOptionalTime(str sql.NullString) (*time.Time, error) {
+	if !str.Valid {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, str.String)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// logTime returns the time of a log entry in nanoseconds, or nil for entries
+// without a time.
+func logTime(t time.Time) *int64 {
+	if t.IsZero() {
+		return nil
+	}
+	nanos := t.UnixNano()
+	return &nanos
+}
+
+func (s *SqliteExecutionStore) GetExecution(ctx context.Context, execId int) (*entity.CommandExecution, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+executionColumns+" FROM executions WHERE exec_id = ?", execId)
+	execution, err := scanExecution(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ExecutionNotFoundError
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	execution.Log, err = s.GetLog(ctx, execId, 0)
+	if err != nil {
+		return nil, err
+	}
+	return execution, nil
+}
+
+func (s *SqliteExecutionStore) GetLog(ctx context.Context, execId int, start int) ([]entity.LogEntry, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT stream, data, log_time FROM log_entries WHERE exec_id = ? ORDER BY entry_id LIMIT -1 OFFSET ?",
+		execId, max(start, 0),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var log []entity.LogEntry
+	for rows.Next() {
+		var entry entity.LogEntry
+		var logTime sql.NullInt64
+		err = rows.Scan(&entry.Stream, &entry.Data, &logTime)
+		if err != nil {
+			return nil, err
+		}
+		if logTime.Valid {
+			entry.Time = time.Unix(0, logTime.Int64)
+		}
+		log = append(log, entry)
+	}
+	return log, rows.Err()
+}
+
+func (s *SqliteExecutionStore) GetExecutions(ctx context.Context, filter entity.ExecutionFilter) ([]entity.CommandExecution, error) {
+	query := "SELECT " + executionColumns + " FROM executions"
+	conditions := make([]string, 0, 2)
+	args := make([]any, 0, 4)
+	if filter.User != "" {
+		conditions = append(conditions, "username = ?")
+		args = append(args, filter.User)
+	}
+	if filter.ParentId != nil {
+		conditions = append(conditions, "parent_id = ?")
+		args = append(args, *filter.ParentId)
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	// the most recent executions are selected, a negative limit means none
+	query += " ORDER BY exec_id DESC LIMIT ? OFFSET ?"
+	limit := -1
+	if filter.Limit > 0 {
+		limit = filter.Limit
+	}
+	args = append(args, limit, max(filter.Offset, 0))
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	executions := make([]entity.CommandExecution, 0)
+	for rows.Next() {
+		execution, err := scanExecution(rows)
+		if err != nil {
+			return nil, err
+		}
+		executions = append(executions, *execution)
+	}
+	slices.Reverse(executions)
+	return executions, rows.Err()
+}
+
+func (s *SqliteExecutionStore) Close() error {
+	return s.db.Close()
+}
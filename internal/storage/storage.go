@@ -6,11 +6,19 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"maps"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"log/slog"
 
+	"github.com/jrammler/wheelhouse/internal/cron"
 	"github.com/jrammler/wheelhouse/internal/entity"
 )
 
@@ -19,20 +27,22 @@ var UserNotFoundError = errors.New("User not found")
 type config struct {
 	Commands []entity.Command `json:"commands"`
 	Users    []entity.User    `json:"users"`
+	Settings entity.Settings  `json:"settings"`
 }
 
 type Storage interface {
 	GetCommands(ctx context.Context) ([]entity.Command, error)
 	GetCommandById(ctx context.Context, id string) (*entity.Command, error)
 	GetUser(ctx context.Context, username string) (entity.User, error)
+	GetSettings(ctx context.Context) (entity.Settings, error)
 	LoadConfig() error
 }
 
 type JsonStorage struct {
-	filepath       string
-	config         *config
-	commandsByHash map[string]*entity.Command
-	mu             sync.RWMutex
+	filepath     string
+	config       *config
+	commandsById map[string]*entity.Command
+	mu           sync.RWMutex
 }
 
 func NewJsonStorage(filepath string) (Storage, error) {
@@ -60,21 +70,333 @@ func (s *JsonStorage) LoadConfig() error {
 		return err
 	}
 
-	hashedCommands := make(map[string]*entity.Command)
-	for i, command := range cfg.Commands {
-		hash := sha256.Sum256([]byte(command.Command))
-		hexHash := hex.EncodeToString(hash[:])
-		cfg.Commands[i].Id = hexHash
-		hashedCommands[hexHash] = &command
+	err = validateCommands(cfg.Commands)
+	if err != nil {
+		slog.Error("Invalid command configuration", "path", s.filepath, "err", err)
+		return err
+	}
+
+	err = validateEnvironment(cfg.Settings.Env, cfg.Settings.InheritEnv)
+	if err == nil && (cfg.Settings.LogMaxSize < 0 || cfg.Settings.LogRetention < 0) {
+		err = errors.New("log_max_size and log_retention must not be negative")
+	}
+	if err == nil && cfg.Settings.ApprovalExpiry < 0 {
+		err = errors.New("approval_expiry must not be negative")
+	}
+	if err == nil {
+		err = validateWebhooks(cfg.Settings.Webhooks)
+	}
+	if err != nil {
+		err = fmt.Errorf("settings: %w", err)
+		slog.Error("Invalid settings", "path", s.filepath, "err", err)
+		return err
+	}
+
+	commandsById, err := assignCommandIds(cfg.Commands)
+	if err != nil {
+		slog.Error("Invalid command configuration", "path", s.filepath, "err", err)
+		return err
+	}
+
+	err = validateWorkflowSteps(cfg.Commands, commandsById)
+	if err != nil {
+		slog.Error("Invalid command configuration", "path", s.filepath, "err", err)
+		return err
+	}
+
+	err = validateTriggers(cfg.Settings.Triggers, commandsById)
+	if err != nil {
+		err = fmt.Errorf("settings: %w", err)
+		slog.Error("Invalid settings", "path", s.filepath, "err", err)
+		return err
 	}
 
 	s.mu.Lock()
 	s.config = cfg
-	s.commandsByHash = hashedCommands
+	s.commandsById = commandsById
 	s.mu.Unlock()
 	return nil
 }
 
+var parameterNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+var commandIdRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// assignCommandIds derives the ID of commands without an explicit ID from the
+// hash of their command string and makes sure all IDs are unique.
+func assignCommandIds(commands []entity.Command) (map[string]*entity.Command, error) {
+	commandsById := make(map[string]*entity.Command)
+	for i, command := range commands {
+		if command.Id == "" {
+			hash := sha256.Sum256([]byte(command.Command))
+			commands[i].Id = hex.EncodeToString(hash[:])
+		} else if !commandIdRegexp.MatchString(command.Id) {
+			return nil, fmt.Errorf("command %q: invalid id %q, only letters, digits, '_', '.' and '-' are allowed", command.Name, command.Id)
+		}
+
+		id := commands[i].Id
+		if other, exists := commandsById[id]; exists {
+			return nil, fmt.Errorf("commands %q and %q have the same id %q, commands with identical command strings need an explicit id", other.Name, command.Name, id)
+		}
+		commandsById[id] = &commands[i]
+	}
+	return commandsById, nil
+}
+
+func validateCommands(commands []entity.Command) error {
+	for _, command := range commands {
+		names := make([]string, 0, len(command.Parameters))
+		for _, param := range command.Parameters {
+			if !parameterNameRegexp.MatchString(param.Name) {
+				return fmt.Errorf("command %q: invalid parameter name %q", command.Name, param.Name)
+			}
+			// the environment variables of the parameters are upper case, so
+			// names differing only in case would share one
+			name := strings.ToUpper(param.Name)
+			if slices.Contains(names, name) {
+				return fmt.Errorf("command %q: duplicate parameter %q", command.Name, param.Name)
+			}
+			names = append(names, name)
+
+			switch param.Type {
+			case entity.ParameterTypeString, entity.ParameterTypeInt, entity.ParameterTypeBool, entity.ParameterTypeSecret, "":
+			case entity.ParameterTypeChoice:
+				if len(param.Choices) == 0 {
+					return fmt.Errorf("command %q: parameter %q has no choices", command.Name, param.Name)
+				}
+			default:
+				return fmt.Errorf("command %q: parameter %q has unknown type %q", command.Name, param.Name, param.Type)
+			}
+
+			if param.Pattern != "" {
+				_, err := regexp.Compile(param.Pattern)
+				if err != nil {
+					return fmt.Errorf("command %q: parameter %q has invalid pattern: %w", command.Name, param.Name, err)
+				}
+			}
+		}
+
+		switch command.Concurrency {
+		case entity.ConcurrencyPolicyAllow, entity.ConcurrencyPolicyReject, entity.ConcurrencyPolicyQueue, "":
+		default:
+			return fmt.Errorf("command %q: unknown concurrency policy %q", command.Name, command.Concurrency)
+		}
+
+		switch command.Confirm {
+		case entity.ConfirmPolicyDialog, entity.ConfirmPolicyName, "":
+		default:
+			return fmt.Errorf("command %q: unknown confirm policy %q", command.Name, command.Confirm)
+		}
+
+		if command.RunAs != nil && command.RunAs.User == "" {
+			return fmt.Errorf("command %q: run_as needs a user", command.Name)
+		}
+
+		if command.RequiresApproval != nil && command.RequiresApproval.Role == "" {
+			return fmt.Errorf("command %q: requires_approval needs a role", command.Name)
+		}
+		if command.RequiresApproval != nil && command.RequiresApproval.Expiry != nil && *command.RequiresApproval.Expiry <= 0 {
+			return fmt.Errorf("command %q: requires_approval expiry must be positive", command.Name)
+		}
+
+		if command.Limits != nil && (command.Limits.Cpu < 0 || command.Limits.Pids < 0 || command.Limits.NoFile < 0) {
+			return fmt.Errorf("command %q: limits must not be negative", command.Name)
+		}
+
+		err := validateEnvironment(command.Env, command.InheritEnv)
+		if err != nil {
+			return fmt.Errorf("command %q: %w", command.Name, err)
+		}
+
+		err = validateWorkflowCommand(command)
+		if err != nil {
+			return fmt.Errorf("command %q: %w", command.Name, err)
+		}
+
+		err = validateSchedule(command)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateEnvironment checks the names of environment variables, entries of
+// the inherit list may end in * to match a prefix.
+func validateEnvironment(env map[string]string, inherit []string) error {
+	for name := range env {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+	}
+	for _, name := range inherit {
+		if name == "" || strings.ContainsAny(name, "=\x00") || strings.Contains(strings.TrimSuffix(name, "*"), "*") {
+			return fmt.Errorf("invalid inherit_env entry %q", name)
+		}
+	}
+	return nil
+}
+
+func validateWebhooks(hooks []entity.Webhook) error {
+	names := make(map[string]bool)
+	for _, hook := range hooks {
+		if hook.Name == "" {
+			return errors.New("webhooks need a name")
+		}
+		if names[hook.Name] {
+			return fmt.Errorf("duplicate webhook name %q", hook.Name)
+		}
+		names[hook.Name] = true
+		u, err := url.Parse(hook.Url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook %q: invalid url %q", hook.Name, hook.Url)
+		}
+		for _, event := range hook.Events {
+			switch event {
+			case entity.NotificationEventStarted, entity.NotificationEventSucceeded, entity.NotificationEventFailed,
+				entity.NotificationEventTimedOut, entity.NotificationEventCancelled:
+			default:
+				return fmt.Errorf("webhook %q: unknown event %q", hook.Name, event)
+			}
+		}
+		for name := range hook.Headers {
+			if name == "" || strings.ContainsAny(name, ": \t\r\n") {
+				return fmt.Errorf("webhook %q: invalid header name %q", hook.Name, name)
+			}
+		}
+		if hook.LogLines != nil && *hook.LogLines < 0 {
+			return fmt.Errorf("webhook %q: log_lines must not be negative", hook.Name)
+		}
+	}
+	return nil
+}
+
+// validateWorkflowCommand checks that a workflow only sets the fields that
+// apply to it, as it does not run a process itself.
+func validateWorkflowCommand(command entity.Command) error {
+	if !command.IsWorkflow() {
+		return nil
+	}
+	if command.Id == "" {
+		return errors.New("workflows need an id")
+	}
+	unsupported := map[string]bool{
+		"command":     command.Command != "",
+		"timeout":     command.Timeout != nil,
+		"concurrency": command.Concurrency != "",
+		"env":         command.Env != nil,
+		"inherit_env": command.InheritEnv != nil,
+		"workdir":     command.Workdir != "",
+		"run_as":      command.RunAs != nil,
+		"limits":      command.Limits != nil,
+		"tty":         command.Tty,
+	}
+	for _, field := range slices.Sorted(maps.Keys(unsupported)) {
+		if unsupported[field] {
+			return fmt.Errorf("%s can not be set for workflows, it applies to the step commands", field)
+		}
+	}
+	return nil
+}
+
+// validateWorkflowSteps checks that the steps of workflows execute existing
+// commands. Steps can not be workflows themselves, so workflows can not
+// contain cycles.
+func validateWorkflowSteps(commands []entity.Command, commandsById map[string]*entity.Command) error {
+	for _, command := range commands {
+		checkStepCommand := func(i int, id string) error {
+			step := commandsById[id]
+			if step == nil {
+				return fmt.Errorf("command %q: step %d: unknown command %q", command.Name, i+1, id)
+			}
+			if step.IsWorkflow() {
+				return fmt.Errorf("command %q: step %d: command %q is a workflow", command.Name, i+1, id)
+			}
+			return nil
+		}
+		for i, step := range command.Steps {
+			if (step.Command == "") == (len(step.Parallel) == 0) {
+				return fmt.Errorf("command %q: step %d needs either a command or parallel commands", command.Name, i+1)
+			}
+			for _, id := range step.Commands() {
+				err := checkStepCommand(i, id)
+				if err != nil {
+					return err
+				}
+			}
+			switch step.OnFailure {
+			case entity.FailurePolicyStop, entity.FailurePolicyContinue, "":
+				if step.Cleanup != "" {
+					return fmt.Errorf("command %q: step %d: cleanup needs on_failure %q", command.Name, i+1, entity.FailurePolicyCleanup)
+				}
+			case entity.FailurePolicyCleanup:
+				if step.Cleanup == "" {
+					return fmt.Errorf("command %q: step %d: on_failure %q needs a cleanup command", command.Name, i+1, step.OnFailure)
+				}
+				err := checkStepCommand(i, step.Cleanup)
+				if err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("command %q: step %d: unknown on_failure policy %q", command.Name, i+1, step.OnFailure)
+			}
+		}
+	}
+	return nil
+}
+
+// validateTriggers checks that the webhook triggers have unique names usable in
+// URLs and only set parameters of existing commands.
+func validateTriggers(triggers []entity.WebhookTrigger, commandsById map[string]*entity.Command) error {
+	names := make(map[string]bool)
+	for _, trigger := range triggers {
+		if !commandIdRegexp.MatchString(trigger.Name) {
+			return fmt.Errorf("invalid trigger name %q", trigger.Name)
+		}
+		if names[trigger.Name] {
+			return fmt.Errorf("duplicate trigger name %q", trigger.Name)
+		}
+		names[trigger.Name] = true
+		if trigger.Secret == "" {
+			return fmt.Errorf("trigger %q: secret is required", trigger.Name)
+		}
+		command := commandsById[trigger.Command]
+		if command == nil {
+			return fmt.Errorf("trigger %q: unknown command %q", trigger.Name, trigger.Command)
+		}
+		for name := range trigger.Parameters {
+			if !slices.ContainsFunc(command.Parameters, func(param entity.CommandParameter) bool { return param.Name == name }) {
+				return fmt.Errorf("trigger %q: command %q has no parameter %q", trigger.Name, trigger.Command, name)
+			}
+		}
+	}
+	return nil
+}
+
+func validateSchedule(command entity.Command) error {
+	if command.Schedule == "" {
+		return nil
+	}
+	_, err := cron.Parse(command.Schedule)
+	if err != nil {
+		return fmt.Errorf("command %q: invalid schedule: %w", command.Name, err)
+	}
+	_, err = time.LoadLocation(command.Timezone)
+	if err != nil {
+		return fmt.Errorf("command %q: invalid timezone: %w", command.Name, err)
+	}
+	switch command.Overlap {
+	case entity.OverlapPolicySkip, entity.OverlapPolicyQueue, "":
+	default:
+		return fmt.Errorf("command %q: unknown overlap policy %q", command.Name, command.Overlap)
+	}
+	for _, param := range command.Parameters {
+		if param.Default == nil {
+			return fmt.Errorf("command %q: scheduled commands need a default for parameter %q", command.Name, param.Name)
+		}
+	}
+	return nil
+}
+
 func (s *JsonStorage) GetCommands(ctx context.Context) ([]entity.Command, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -91,10 +413,10 @@ func (s *JsonStorage) GetCommands(ctx context.Context) ([]entity.Command, error)
 func (s *JsonStorage) GetCommandById(ctx context.Context, id string) (*entity.Command, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	if s.commandsByHash == nil {
+	if s.commandsById == nil {
 		return nil, errors.New("config not loaded")
 	}
-	return s.commandsByHash[id], nil
+	return s.commandsById[id], nil
 }
 
 func (s *JsonStorage) GetUser(ctx context.Context, username string) (entity.User, error) {
@@ -111,3 +433,12 @@ func (s *JsonStorage) GetUser(ctx context.Context, username string) (entity.User
 	}
 	return entity.User{}, UserNotFoundError
 }
+
+func (s *JsonStorage) GetSettings(ctx context.Context) (entity.Settings, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.config == nil {
+		return entity.Settings{}, errors.New("config not loaded")
+	}
+	return s.config.Settings, nil
+}
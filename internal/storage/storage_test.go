@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+)
+
+func TestAssignCommandIds(t *testing.T) {
+	t.Run("Hash and explicit", func(t *testing.T) {
+		// Arrange
+		commands := []entity.Command{
+			{Name: "Hello", Command: "echo hello"},
+			{Name: "Deploy", Command: "deploy", Id: "deploy"},
+		}
+
+		// Act
+		commandsById, err := assignCommandIds(commands)
+
+		// Assert
+		if err != nil {
+			t.Fatalf("assignCommandIds failed: %q", err)
+		}
+		if len(commands[0].Id) != 64 {
+			t.Errorf("Expected hash id, got %q", commands[0].Id)
+		}
+		if commandsById["deploy"] != &commands[1] {
+			t.Errorf("Expected explicit id to be kept, got %v", commandsById)
+		}
+	})
+
+	t.Run("Duplicate", func(t *testing.T) {
+		// Arrange
+		commands := []entity.Command{
+			{Name: "First", Command: "make"},
+			{Name: "Second", Command: "make"},
+		}
+
+		// Act
+		_, err := assignCommandIds(commands)
+
+		// Assert
+		if err == nil || !strings.Contains(err.Error(), "same id") {
+			t.Errorf("Expected duplicate id error, got %v", err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		// Arrange
+		commands := []entity.Command{{Name: "Bad", Command: "true", Id: "a/b"}}
+
+		// Act
+		_, err := assignCommandIds(commands)
+
+		// Assert
+		if err == nil {
+			t.Errorf("Expected invalid id error")
+		}
+	})
+}
+
+func TestValidateEnvironment(t *testing.T) {
+	testCases := []struct {
+		name    string
+		env     map[string]string
+		inherit []string
+		valid   bool
+	}{
+		{"valid", map[string]string{"STAGE": "prod"}, []string{"PATH", "LC_*"}, true},
+		{"empty name", map[string]string{"": "x"}, nil, false},
+		{"name with =", map[string]string{"A=B": "x"}, nil, false},
+		{"inner wildcard", nil, []string{"A*B"}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			err := validateEnvironment(tc.env, tc.inherit)
+
+			// Assert
+			if tc.valid && err != nil {
+				t.Errorf("Expected valid environment, got %q", err)
+			}
+			if !tc.valid && err == nil {
+				t.Errorf("Expected error")
+			}
+		})
+	}
+}
+
+func TestValidateWebhooks(t *testing.T) {
+	negative := -1
+	testCases := []struct {
+		name  string
+		hooks []entity.Webhook
+		valid bool
+	}{
+		{"valid", []entity.Webhook{{Name: "chat", Url: "https://example.com/hook", Events: []entity.NotificationEvent{entity.NotificationEventFailed}, Headers: map[string]string{"Authorization": "Bearer x"}}}, true},
+		{"missing name", []entity.Webhook{{Url: "https://example.com"}}, false},
+		{"duplicate name", []entity.Webhook{{Name: "a", Url: "https://example.com"}, {Name: "a", Url: "https://example.org"}}, false},
+		{"invalid scheme", []entity.Webhook{{Name: "a", Url: "ftp://example.com"}}, false},
+		{"unknown event", []entity.Webhook{{Name: "a", Url: "http://example.com", Events: []entity.NotificationEvent{"finished"}}}, false},
+		{"invalid header", []entity.Webhook{{Name: "a", Url: "http://example.com", Headers: map[string]string{"X Y": "z"}}}, false},
+		{"negative log lines", []entity.Webhook{{Name: "a", Url: "http://example.com", LogLines: &negative}}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			err := validateWebhooks(tc.hooks)
+
+			// Assert
+			if tc.valid && err != nil {
+				t.Errorf("Expected valid webhooks, got %q", err)
+			}
+			if !tc.valid && err == nil {
+				t.Errorf("Expected error")
+			}
+		})
+	}
+}
+
+func TestValidateTriggers(t *testing.T) {
+	commandsById := map[string]*entity.Command{
+		"deploy": {Id: "deploy", Parameters: []entity.CommandParameter{{Name: "ref"}}},
+	}
+	testCases := []struct {
+		name     string
+		triggers []entity.WebhookTrigger
+		valid    bool
+	}{
+		{"valid", []entity.WebhookTrigger{{Name: "push", Command: "deploy", Secret: "x", Parameters: map[string]string{"ref": "ref"}}}, true},
+		{"invalid name", []entity.WebhookTrigger{{Name: "a/b", Command: "deploy", Secret: "x"}}, false},
+		{"duplicate name", []entity.WebhookTrigger{{Name: "a", Command: "deploy", Secret: "x"}, {Name: "a", Command: "deploy", Secret: "y"}}, false},
+		{"missing secret", []entity.WebhookTrigger{{Name: "a", Command: "deploy"}}, false},
+		{"unknown command", []entity.WebhookTrigger{{Name: "a", Command: "backup", Secret: "x"}}, false},
+		{"unknown parameter", []entity.WebhookTrigger{{Name: "a", Command: "deploy", Secret: "x", Parameters: map[string]string{"target": "target"}}}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			err := validateTriggers(tc.triggers, commandsById)
+
+			// Assert
+			if tc.valid && err != nil {
+				t.Errorf("Expected valid triggers, got %q", err)
+			}
+			if !tc.valid && err == nil {
+				t.Errorf("Expected error")
+			}
+		})
+	}
+}
+
+func TestValidateWorkflows(t *testing.T) {
+	timeout := entity.Duration(time.Minute)
+	commands := []entity.Command{
+		{Id: "build", Command: "make"},
+		{Id: "test", Command: "make test"},
+		{Id: "flow", Steps: []entity.WorkflowStep{{Command: "build"}}},
+	}
+	testCases := []struct {
+		name    string
+		command entity.Command
+		valid   bool
+	}{
+		{"valid", entity.Command{Id: "w", Steps: []entity.WorkflowStep{
+			{Command: "build", OnFailure: entity.FailurePolicyCleanup, Cleanup: "test"},
+			{Parallel: []string{"build", "test"}, OnFailure: entity.FailurePolicyContinue},
+		}}, true},
+		{"missing id", entity.Command{Steps: []entity.WorkflowStep{{Command: "build"}}}, false},
+		{"command set", entity.Command{Id: "w", Command: "make", Steps: []entity.WorkflowStep{{Command: "build"}}}, false},
+		{"timeout set", entity.Command{Id: "w", Timeout: &timeout, Steps: []entity.WorkflowStep{{Command: "build"}}}, false},
+		{"empty step", entity.Command{Id: "w", Steps: []entity.WorkflowStep{{}}}, false},
+		{"command and parallel", entity.Command{Id: "w", Steps: []entity.WorkflowStep{{Command: "build", Parallel: []string{"test"}}}}, false},
+		{"unknown command", entity.Command{Id: "w", Steps: []entity.WorkflowStep{{Command: "deploy"}}}, false},
+		{"nested workflow", entity.Command{Id: "w", Steps: []entity.WorkflowStep{{Parallel: []string{"build", "flow"}}}}, false},
+		{"unknown policy", entity.Command{Id: "w", Steps: []entity.WorkflowStep{{Command: "build", OnFailure: "retry"}}}, false},
+		{"missing cleanup", entity.Command{Id: "w", Steps: []entity.WorkflowStep{{Command: "build", OnFailure: entity.FailurePolicyCleanup}}}, false},
+		{"cleanup without policy", entity.Command{Id: "w", Steps: []entity.WorkflowStep{{Command: "build", Cleanup: "test"}}}, false},
+		{"unknown cleanup", entity.Command{Id: "w", Steps: []entity.WorkflowStep{{Command: "build", OnFailure: entity.FailurePolicyCleanup, Cleanup: "deploy"}}}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			all := append(slices.Clone(commands), tc.command)
+			commandsById := make(map[string]*entity.Command)
+			for i := range all {
+				commandsById[all[i].Id] = &all[i]
+			}
+
+			// Act
+			err := validateWorkflowCommand(tc.command)
+			if err == nil {
+				err = validateWorkflowSteps(all, commandsById)
+			}
+
+			// Assert
+			if tc.valid && err != nil {
+				t.Errorf("Expected valid workflow, got %q", err)
+			}
+			if !tc.valid && err == nil {
+				t.Errorf("Expected error")
+			}
+		})
+	}
+}
+
+func TestValidateApproval(t *testing.T) {
+	expiry, zero := entity.Duration(time.Hour), entity.Duration(0)
+	testCases := []struct {
+		name     string
+		approval *entity.Approval
+		valid    bool
+	}{
+		{"valid", &entity.Approval{Role: "dba", Expiry: &expiry}, true},
+		{"missing role", &entity.Approval{}, false},
+		{"zero expiry", &entity.Approval{Role: "dba", Expiry: &zero}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			err := validateCommands([]entity.Command{{Name: "restore", Command: "restore.sh", RequiresApproval: tc.approval}})
+
+			// Assert
+			if tc.valid && err != nil {
+				t.Errorf("Expected valid command, got %q", err)
+			}
+			if !tc.valid && err == nil {
+				t.Errorf("Expected error")
+			}
+		})
+	}
+}
+
+func TestValidateConfirm(t *testing.T) {
+	testCases := []struct {
+		name    string
+		confirm entity.ConfirmPolicy
+		valid   bool
+	}{
+		{"none", "", true},
+		{"dialog", entity.ConfirmPolicyDialog, true},
+		{"name", entity.ConfirmPolicyName, true},
+		{"unknown", "always", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			err := validateCommands([]entity.Command{{Name: "restart", Command: "restart.sh", Confirm: tc.confirm}})
+
+			// Assert
+			if tc.valid && err != nil {
+				t.Errorf("Expected valid command, got %q", err)
+			}
+			if !tc.valid && err == nil {
+				t.Errorf("Expected error")
+			}
+		})
+	}
+}
+
+func TestValidateParameterNames(t *testing.T) {
+	testCases := []struct {
+		name   string
+		params []string
+		valid  bool
+	}{
+		{"distinct", []string{"env", "version"}, true},
+		{"duplicate", []string{"env", "env"}, false},
+		{"differing in case", []string{"env", "ENV"}, false},
+		{"invalid", []string{"my-env"}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			params := make([]entity.CommandParameter, 0, len(tc.params))
+			for _, name := range tc.params {
+				params = append(params, entity.CommandParameter{Name: name})
+			}
+
+			// Act
+			err := validateCommands([]entity.Command{{Name: "deploy", Command: "deploy.sh", Parameters: params}})
+
+			// Assert
+			if tc.valid && err != nil {
+				t.Errorf("Expected valid command, got %q", err)
+			}
+			if !tc.valid && err == nil {
+				t.Errorf("Expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jrammler/wheelhouse/internal/entity"
+)
+
+var TokenNotFoundError = errors.New("Token not found")
+
+type TokenStore interface {
+	GetTokens(ctx context.Context) ([]entity.ApiToken, error)
+	AddToken(ctx context.Context, token entity.ApiToken) error
+	RemoveToken(ctx context.Context, id string) error
+}
+
+// JsonTokenStore keeps API tokens in a JSON file. The file is read again
+// whenever it was modified, so tokens managed by another process take effect
+// without a restart.
+type JsonTokenStore struct {
+	filepath string
+	tokens   []entity.ApiToken
+	modTime  time.Time
+	mu       sync.Mutex
+}
+
+func NewJsonTokenStore(filepath string) *JsonTokenStore {
+	return &JsonTokenStore{
+		filepath: filepath,
+	}
+}
+
+// load reads the token file if it changed since it was last read. A missing
+// file contains no tokens. The caller must hold the mutex.
+func (s *JsonTokenStore) load() error {
+	info, err := os.Stat(s.filepath)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.tokens = nil
+		s.modTime = time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(s.modTime) && s.tokens != nil {
+		return nil
+	}
+
+	file, err := os.ReadFile(s.filepath)
+	if err != nil {
+		return err
+	}
+	tokens := make([]entity.ApiToken, 0)
+	err = json.Unmarshal(file, &tokens)
+	if err != nil {
+		return err
+	}
+	s.tokens = tokens
+	s.modTime = info.ModTime()
+	return nil
+}
+
+// save atomically replaces the token file. The caller must hold the mutex.
+func (s *JsonTokenStore) save(tokens []entity.ApiToken) error {
+	data, err := json.MarshalIndent(tokens, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.filepath), ".tokens-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), s.filepath)
+	if err != nil {
+		return err
+	}
+	// force reading the file again, the modification time might not change
+	// if it is written twice in quick succession
+	s.tokens = nil
+	return nil
+}
+
+func (s *JsonTokenStore) GetTokens(ctx context.Context) ([]entity.ApiToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(s.tokens), nil
+}
+
+func (s *JsonTokenStore) AddToken(ctx context.Context, token entity.ApiToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.load()
+	if err != nil {
+		return err
+	}
+	return s.save(append(slices.Clone(s.tokens), token))
+}
+
+func (s *JsonTokenStore) RemoveToken(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.load()
+	if err != nil {
+		return err
+	}
+	idx := slices.IndexFunc(s.tokens, func(t entity.ApiToken) bool { return t.Id == id })
+	if idx < 0 {
+		return TokenNotFoundError
+	}
+	return s.save(slices.Delete(slices.Clone(s.tokens), idx, idx+1))
+}